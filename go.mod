@@ -6,6 +6,7 @@ require (
 	cloud.google.com/go v0.63.0
 	cloud.google.com/go/storage v1.10.0
 	contrib.go.opencensus.io/exporter/stackdriver v0.13.4
+	github.com/andybalholm/brotli v1.0.4
 	github.com/google/go-cmp v0.5.4
 	github.com/honeycombio/beeline-go v0.11.1
 	github.com/kr/text v0.2.0 // indirect
@@ -14,5 +15,6 @@ require (
 	go.opencensus.io v0.22.5
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	google.golang.org/genproto v0.0.0-20200806141610-86f49bd18e98
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
 )
@@ -12,12 +12,11 @@ import (
 	"github.com/CAVaccineInventory/airtable-export/monitoring/freshcf/pkg/metrics"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/config"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
-	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
 	"github.com/honeycombio/beeline-go/wrappers/hnynethttp"
 )
 
 func main() {
-	bucketFlag := flag.String("bucket", "", "Upload into a specific bucket")
+	bucketFlag := flag.String("bucket", "", "Use a specific GCS bucket for every file, instead of the deploy's own")
 	metricsFlag := flag.Bool("metrics", true, "Enable metrics reporting")
 	flag.Parse()
 
@@ -29,7 +28,9 @@ func main() {
 	}
 
 	if *bucketFlag != "" {
-		deploys.SetTestingStorage(storage.UploadToGCS, *bucketFlag)
+		if err := deploys.UseBucket(*bucketFlag); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	log.Printf("Starting freshcf version %s...\n", config.GitCommit)
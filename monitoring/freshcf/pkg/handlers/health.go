@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/CAVaccineInventory/airtable-export/monitoring/freshcf/pkg/stats"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
 )
 
 func Health(w http.ResponseWriter, r *http.Request) {
@@ -43,8 +44,18 @@ func Health(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cxl := context.WithTimeout(ctx, requestTimeout)
 	defer cxl()
-	resultChan := stats.AllResponses(ctx)
 	errs := make([]string, 0)
+	pins, err := stats.GetPins(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("error fetching pins: %s", err))
+	}
+	pinned := control.Pinned(pins)
+	pause, err := stats.GetPause(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("error fetching pause: %s", err))
+	}
+
+	resultChan := stats.AllResponses(ctx)
 	for len(resultChan) != 0 {
 		result := <-resultChan
 		if result.Err != nil {
@@ -58,8 +69,11 @@ func Health(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		// Pinned endpoints, and every endpoint while publishing is paused,
+		// are deliberately not being updated.
+		_, isPinned := pinned[result.Endpoint.String()]
 		ago := int(time.Since(stats.LastModified).Seconds())
-		if ago > thresholdAge {
+		if ago > thresholdAge && !isPinned && pause == nil {
 			errs = append(errs, fmt.Sprintf("%s\nlast modified is too old: %d < %d", result.URL, ago, thresholdAge))
 			continue
 		}
@@ -82,4 +96,16 @@ func Health(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "OK")
 	}
+	if pause != nil {
+		fmt.Fprintf(w, "\n\nPaused since %s by %s: %s\n", pause.Since.Format(time.RFC3339), pause.By, pause.Reason)
+		if pause.RolledBackTo != "" {
+			fmt.Fprintf(w, "rolled back to %s\n", pause.RolledBackTo)
+		}
+	}
+	if len(pins) > 0 {
+		fmt.Fprintf(w, "\n\nPinned:\n")
+		for _, p := range pins {
+			fmt.Fprintf(w, "\n%s\npinned since %s by %s: %s\n", p.Endpoint, p.Since.Format(time.RFC3339), p.By, p.Reason)
+		}
+	}
 }
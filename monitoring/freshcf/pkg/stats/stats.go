@@ -11,6 +11,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
 	"github.com/honeycombio/beeline-go"
 )
@@ -105,6 +107,44 @@ func GetURLStats(ctx context.Context, url string) (ExportedJSONFileStats, error)
 	return output, errors.New("Unknown JSON structure")
 }
 
+// GetPins returns the endpoints which operators have pinned, and so are not
+// being published.
+func GetPins(ctx context.Context) ([]control.Pin, error) {
+	ctx, span := beeline.StartSpan(ctx, "stats.GetPins")
+	defer span.Send()
+
+	sr, err := deploys.GetReader()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	pins, err := control.GetPins(ctx, sr)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	return pins, nil
+}
+
+// GetPause returns the current pause of publishing, or nil if publishing
+// isn't paused.
+func GetPause(ctx context.Context) (*control.Pause, error) {
+	ctx, span := beeline.StartSpan(ctx, "stats.GetPause")
+	defer span.Send()
+
+	sr, err := deploys.GetReader()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	pause, err := control.GetPause(ctx, sr)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	return pause, nil
+}
+
 type Response struct {
 	Endpoint endpoints.Endpoint
 	URL      string
@@ -0,0 +1,54 @@
+// Package main checks that the endpoints keep to the field contract of each
+// API version recorded in the lockfile, printing every incompatibility, and
+// updates the lockfile with new versions, endpoints and fields.  Run it from
+// the root of the repository.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/contract"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+)
+
+func main() {
+	lockFlag := flag.String("lock", contract.DefaultFile, "The lockfile")
+	updateFlag := flag.Bool("update", false, "Record new versions, endpoints and fields in the lockfile, if there are no incompatibilities")
+	flag.Parse()
+
+	current := contract.FromEndpoints(endpoints.AllEndpoints())
+	locked, err := contract.Read(*lockFlag)
+	if os.IsNotExist(err) && *updateFlag {
+		locked = &contract.Lock{}
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	if bad := contract.Check(locked, current); len(bad) > 0 {
+		for _, i := range bad {
+			fmt.Println(i)
+		}
+		fmt.Println("Fields can't be removed, renamed or changed in type within a version; create a new API version instead.")
+		os.Exit(1)
+	}
+	if locked.Equal(current) {
+		fmt.Println("The lockfile is up to date.")
+		return
+	}
+	if !*updateFlag {
+		fmt.Printf("%s is out of date; run with -update to record the new fields.\n", *lockFlag)
+		os.Exit(1)
+	}
+	data, err := current.Encode()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*lockFlag, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Updated %s.\n", *lockFlag)
+}
@@ -0,0 +1,47 @@
+// Package main runs a single publish pass, and exits non-zero if any output
+// failed to publish.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/config"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/dedup"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/publisher"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/secrets"
+)
+
+func main() {
+	bucketFlag := flag.String("bucket", "", "Use a specific GCS bucket for every file, instead of the deploy's own")
+	dedupFlag := flag.String("dedup", string(dedup.PolicyReport), "What to do with duplicate locations: report, keep or merge")
+	flag.Parse()
+
+	policy, err := dedup.ParsePolicy(*dedupFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := endpoints.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *bucketFlag != "" {
+		if err := deploys.UseBucket(*bucketFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cxl := context.WithTimeout(context.Background(), publisher.Timeout)
+	defer cxl()
+	tables := airtable.NewTables(secrets.RequireAirtableSecret(ctx))
+	tables.SetDedupPolicy(policy)
+
+	log.Printf("Publishing with pipeline version %s...\n", config.GitCommit)
+	if _, err := publisher.Run(ctx, tables); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+// Package main pins endpoints to their last good output, so that the
+// pipeline stops publishing them, and unpins them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+)
+
+func main() {
+	endpointFlag := flag.String("endpoint", "", "Endpoint to pin, as <version>/<resource>, e.g. 1/providers")
+	unpinFlag := flag.String("unpin", "", "Endpoint to unpin, as <version>/<resource>")
+	byFlag := flag.String("by", os.Getenv("USER"), "Who is pinning the endpoint")
+	reasonFlag := flag.String("reason", "", "Why the endpoint is pinned")
+	bucketFlag := flag.String("bucket", "", "Use a specific GCS bucket for every file, instead of the deploy's own")
+	flag.Parse()
+
+	if *bucketFlag != "" {
+		if err := deploys.UseBucket(*bucketFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cxl := context.WithTimeout(context.Background(), time.Minute)
+	defer cxl()
+
+	sw, err := deploys.GetStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sr, err := deploys.GetReader()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case *unpinFlag != "":
+		if err := control.RemovePin(ctx, sr, sw, *unpinFlag); err != nil {
+			log.Fatalf("Failed to unpin: %v", err)
+		}
+		log.Printf("Unpinned %s; it will be published on the next run.\n", *unpinFlag)
+	case *endpointFlag != "":
+		if !exists(*endpointFlag) {
+			log.Fatalf("No such endpoint: %s", *endpointFlag)
+		}
+		if *reasonFlag == "" {
+			log.Fatal("-reason is required")
+		}
+		err := control.SetPin(ctx, sr, sw, control.Pin{
+			Endpoint: *endpointFlag,
+			Since:    time.Now().UTC(),
+			By:       *byFlag,
+			Reason:   *reasonFlag,
+		})
+		if err != nil {
+			log.Fatalf("Failed to pin: %v", err)
+		}
+		log.Printf("Pinned %s; it won't be published until it is unpinned.\n", *endpointFlag)
+	}
+
+	pins, err := control.GetPins(ctx, sr)
+	if err != nil {
+		log.Fatalf("Failed to read pins: %v", err)
+	}
+	if len(pins) == 0 {
+		fmt.Println("No endpoints are pinned.")
+	}
+	for _, p := range pins {
+		fmt.Printf("%s: pinned since %s by %s: %s\n", p.Endpoint, p.Since.Format(time.RFC3339), p.By, p.Reason)
+	}
+}
+
+func exists(endpoint string) bool {
+	for _, ep := range endpoints.AllEndpoints() {
+		if ep.String() == endpoint {
+			return true
+		}
+	}
+	return false
+}
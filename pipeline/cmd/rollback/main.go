@@ -0,0 +1,64 @@
+// Package main restores the live API from an archived snapshot, and pauses
+// and resumes scheduled publishes.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/publisher"
+)
+
+func main() {
+	snapshotFlag := flag.String("snapshot", "", "Run ID (e.g. 20261018T153012Z) or minute (e.g. 2026/10/18/1530) of the archived snapshot to restore")
+	resumeFlag := flag.Bool("resume", false, "Resume scheduled publishes, instead of rolling back")
+	byFlag := flag.String("by", os.Getenv("USER"), "Who is rolling back")
+	reasonFlag := flag.String("reason", "", "Why publishing is being rolled back")
+	bucketFlag := flag.String("bucket", "", "Use a specific GCS bucket for every file, instead of the deploy's own")
+	flag.Parse()
+
+	if *bucketFlag != "" {
+		if err := deploys.UseBucket(*bucketFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cxl := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cxl()
+
+	if *resumeFlag {
+		sd, err := deploys.GetDeleter()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := control.ClearPause(ctx, sd); err != nil {
+			log.Fatalf("Failed to resume: %v", err)
+		}
+		log.Println("Resumed scheduled publishes.")
+		return
+	}
+
+	if *snapshotFlag == "" || *reasonFlag == "" {
+		fmt.Fprintln(os.Stderr, "Either -resume, or -snapshot and -reason, are required.")
+		flag.Usage()
+		os.Exit(2)
+	}
+	restored, err := publisher.Rollback(ctx, *snapshotFlag, control.Pause{
+		Since:  time.Now().UTC(),
+		By:     *byFlag,
+		Reason: *reasonFlag,
+	})
+	for _, f := range restored {
+		log.Printf("Restored %s\n", f)
+	}
+	if err != nil {
+		log.Fatalf("Rollback failed: %v", err)
+	}
+	log.Println("Scheduled publishes are paused; resume them with -resume once the data is fixed.")
+}
@@ -0,0 +1,94 @@
+// Package main serves the pipeline over HTTP: each POST to /publish runs one
+// publish pass, as scheduled by Cloud Scheduler.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/config"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/dedup"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/publisher"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/secrets"
+	"github.com/honeycombio/beeline-go/wrappers/hnynethttp"
+)
+
+type server struct {
+	secret string
+	policy dedup.Policy
+	// mu ensures that publish passes don't overlap, as run IDs rely on.
+	mu sync.Mutex
+}
+
+func (s *server) publish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST to publish", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cxl := context.WithTimeout(r.Context(), publisher.Timeout)
+	defer cxl()
+	// Tables caches what it fetches, so each pass gets its own.
+	tables := airtable.NewTables(s.secret)
+	tables.SetDedupPolicy(s.policy)
+	if _, err := publisher.Run(ctx, tables); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, control.ErrPaused) {
+			// Not a failure of the pipeline; don't have the
+			// scheduler retry it.
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+func healthcheck(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "OK")
+}
+
+func main() {
+	bucketFlag := flag.String("bucket", "", "Use a specific GCS bucket for every file, instead of the deploy's own")
+	dedupFlag := flag.String("dedup", string(dedup.PolicyReport), "What to do with duplicate locations: report, keep or merge")
+	flag.Parse()
+
+	policy, err := dedup.ParsePolicy(*dedupFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := endpoints.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *bucketFlag != "" {
+		if err := deploys.UseBucket(*bucketFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cxl := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cxl()
+	s := &server{secret: secrets.RequireAirtableSecret(ctx), policy: policy}
+
+	log.Printf("Starting pipeline version %s...\n", config.GitCommit)
+
+	http.HandleFunc("/publish", s.publish)
+	http.HandleFunc("/healthcheck", healthcheck)
+	err = http.ListenAndServe(":8080", hnynethttp.WrapHandler(http.DefaultServeMux))
+	if err != nil {
+		panic(err)
+	}
+}
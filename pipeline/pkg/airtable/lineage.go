@@ -0,0 +1,109 @@
+package airtable
+
+import (
+	"context"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/filter"
+)
+
+// MungerInfo describes one of the mungers a table is cleaned up with as it's
+// fetched, for the field lineage report.
+type MungerInfo struct {
+	// Name is the name of the munger function.
+	Name string `json:"name"`
+	// Description says what the munger does, for people who don't read Go.
+	Description string `json:"description"`
+	// Fields are the columns whose values the munger changes.  If there are
+	// none, the munger applies to whole rows, e.g. by dropping them.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// tableMunger is a munger a table is cleaned up with as it's fetched, and
+// its description.
+type tableMunger struct {
+	MungerInfo
+	// opt returns the option which applies the munger.
+	opt func(ctx context.Context, t *Tables) (filter.XformOpt, error)
+}
+
+// copyOnWrite returns the opt of a tableMunger which applies a copy-on-write
+// munger that needs no setup.
+func copyOnWrite(m filter.Munger) func(context.Context, *Tables) (filter.XformOpt, error) {
+	return func(context.Context, *Tables) (filter.XformOpt, error) {
+		return filter.WithCopyOnWriteMunger(m), nil
+	}
+}
+
+var dropEmptyInfo = MungerInfo{Name: "dropEmpty", Description: "Drops rows which have no fields set."}
+
+// tableMungers returns the mungers applied to the named table as it's
+// fetched, in the order they run.  They are both applied and described in
+// the lineage report from this list, so the two can't disagree.
+func tableMungers(table string) []tableMunger {
+	switch table {
+	case "Counties", "Provider networks":
+		return []tableMunger{
+			{dropEmptyInfo, copyOnWrite(dropEmpty)},
+		}
+	case "Locations":
+		return []tableMunger{
+			{dropEmptyInfo, copyOnWrite(dropEmpty)},
+			{
+				MungerInfo{Name: "dropSoftDeleted", Description: `Drops rows which have "is_soft_deleted" set.`},
+				copyOnWrite(dropSoftDeleted),
+			},
+			{
+				MungerInfo{
+					Name:        "hideNotes",
+					Description: `Blanks the notes unless "Latest report yes?" is 1.`,
+					Fields:      []string{"Latest report notes"},
+				},
+				copyOnWrite(hideNotes),
+			},
+			{
+				MungerInfo{
+					Name:        "notesScrubber",
+					Description: `Redacts phone numbers and email addresses, other than the location's own "Phone number".`,
+					Fields:      []string{"Latest report notes"},
+				},
+				func(ctx context.Context, t *Tables) (filter.XformOpt, error) {
+					return filter.WithCopyOnWriteMunger(notesScrubber.Munger(ctx)), nil
+				},
+			},
+			{
+				MungerInfo{
+					Name:        "useCountyURL",
+					Description: `Replaces "Uses county scheduling system" with the "County vaccination reservations URL" of the location's county, from the Counties table.`,
+					Fields:      []string{"Appointment scheduling instructions"},
+				},
+				func(ctx context.Context, t *Tables) (filter.XformOpt, error) {
+					cm, err := useCountyURL(ctx, t)
+					if err != nil {
+						return nil, err
+					}
+					return filter.WithCopyOnWriteMunger(cm), nil
+				},
+			},
+			{
+				MungerInfo{
+					Name:        "dedupLocations",
+					Description: "Finds locations which are duplicates of each other, and resolves them according to the deduplication policy.",
+				},
+				func(ctx context.Context, t *Tables) (filter.XformOpt, error) {
+					return filter.WithTableMunger(dedupLocations(ctx, t.dedupPolicy)), nil
+				},
+			},
+		}
+	}
+	return nil
+}
+
+// TableMungers returns descriptions of the mungers applied to the named table
+// as it's fetched, in the order they run.
+func TableMungers(table string) []MungerInfo {
+	var out []MungerInfo
+	for _, m := range tableMungers(table) {
+		out = append(out, m.MungerInfo)
+	}
+	return out
+}
@@ -3,9 +3,14 @@ package airtable
 import (
 	"context"
 	"fmt"
+	"log"
 	"sync"
+	"time"
 
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/dedup"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/filter"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/scrub"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
 	beeline "github.com/honeycombio/beeline-go"
 )
@@ -21,7 +26,10 @@ type Tables struct {
 	mainLock   sync.RWMutex                 // mainLock protects tableLocks.
 	tableLocks map[string]*sync.Mutex       // tableLocks contains a lock for each table, to prevent races to populate a table.
 	tables     map[string]tableFetchResults // Tables contains a map of table name to (table content or error).
+	fetched    map[string]time.Time         // fetched is when each table was downloaded; it is protected by mainLock.
 	fetcher    fetcher
+
+	dedupPolicy dedup.Policy // dedupPolicy is how duplicate Locations are resolved.
 }
 
 type fetcher interface {
@@ -33,16 +41,29 @@ func NewTables(secret string) *Tables {
 		mainLock:   sync.RWMutex{},
 		tableLocks: map[string]*sync.Mutex{},
 		tables:     map[string]tableFetchResults{},
+		fetched:    map[string]time.Time{},
 		fetcher:    newAirtable(secret),
+
+		dedupPolicy: dedup.PolicyReport,
 	}
 }
 
+// SetDedupPolicy sets how duplicate Locations are resolved; it must be called
+// before the Locations table is first fetched.  The default is to only report
+// them.
+func (t *Tables) SetDedupPolicy(p dedup.Policy) {
+	t.dedupPolicy = p
+}
+
+// The mungers each table is cleaned up with are listed in tableMungers, in
+// lineage.go.
+
 func (t *Tables) GetCounties(ctx context.Context) (types.TableContent, error) {
-	return t.getTable(ctx, "Counties", filter.WithMunger(dropEmpty))
+	return t.getMungedTable(ctx, "Counties")
 }
 
 func (t *Tables) GetProviders(ctx context.Context) (types.TableContent, error) {
-	return t.getTable(ctx, "Provider networks", filter.WithMunger(dropEmpty))
+	return t.getMungedTable(ctx, "Provider networks")
 }
 
 func dropEmpty(row map[string]interface{}) (map[string]interface{}, error) {
@@ -55,14 +76,23 @@ func dropEmpty(row map[string]interface{}) (map[string]interface{}, error) {
 }
 
 func hideNotes(row map[string]interface{}) (map[string]interface{}, error) {
-	// Because this function is used as part of the input processing, which only
-	// happens once and inside a lock, it directly modifies the input row.
-	if v, ok := row["Latest report yes?"].(float64); !ok || v != 1 {
-		row["Latest report notes"] = ""
+	// This is a copy-on-write munger; it only copies the row if it needs to
+	// blank out the notes.
+	if v, ok := row["Latest report yes?"].(float64); ok && v == 1 {
+		return row, nil
 	}
+	if n, ok := row["Latest report notes"].(string); ok && n == "" {
+		return row, nil
+	}
+	row = types.CloneRow(row)
+	row["Latest report notes"] = ""
 	return row, nil
 }
 
+// notesScrubber redacts personal information that volunteers sometimes type
+// into report notes, other than the site's own published phone number.
+var notesScrubber = scrub.New([]string{"Latest report notes"}, scrub.WithAllowedField("Phone number"))
+
 func dropSoftDeleted(row map[string]interface{}) (map[string]interface{}, error) {
 	if v, ok := row["is_soft_deleted"].(bool); ok && v {
 		return nil, nil
@@ -91,6 +121,7 @@ func useCountyURL(ctx context.Context, t *Tables) (func(row map[string]interface
 			if inst, ok := row["Appointment scheduling instructions"].(string); ok {
 				if inst == "Uses county scheduling system" {
 					if u, ok := urls[county]; ok {
+						row = types.CloneRow(row)
 						row["Appointment scheduling instructions"] = u
 					}
 				}
@@ -100,12 +131,44 @@ func useCountyURL(ctx context.Context, t *Tables) (func(row map[string]interface
 	}, nil
 }
 
+// dedupLocations returns a table munger which resolves duplicate Locations
+// according to the policy, and records the duplicates it finds in the run
+// report, so the data team can clean them up in Airtable.
+func dedupLocations(ctx context.Context, policy dedup.Policy) filter.TableMunger {
+	return func(in types.TableContent) (types.TableContent, error) {
+		out, groups, err := dedup.Apply(in, policy)
+		if err != nil {
+			return nil, err
+		}
+		if len(groups) > 0 {
+			log.Printf("[Locations] Found %d groups of duplicate locations (policy: %s)\n", len(groups), policy)
+		}
+		beeline.AddField(ctx, "duplicateGroups", len(groups))
+
+		rep := report.FromContext(ctx)
+		rep.Add("locations.duplicate_groups", len(groups))
+		rep.Add("locations.duplicates_removed", len(in)-len(out))
+		rep.Set("duplicates", groups)
+		return out, nil
+	}
+}
+
 func (t *Tables) GetLocations(ctx context.Context) (types.TableContent, error) {
-	cm, err := useCountyURL(ctx, t)
-	if err != nil {
-		return nil, fmt.Errorf("Can't setup useCountyURL: %v", err)
+	return t.getMungedTable(ctx, "Locations")
+}
+
+// getMungedTable does a getTable, cleaning the table up with the mungers
+// listed for it in tableMungers.
+func (t *Tables) getMungedTable(ctx context.Context, tableName string) (types.TableContent, error) {
+	var xfOpts []filter.XformOpt
+	for _, m := range tableMungers(tableName) {
+		opt, err := m.opt(ctx, t)
+		if err != nil {
+			return nil, fmt.Errorf("Can't setup %s: %v", m.Name, err)
+		}
+		xfOpts = append(xfOpts, opt)
 	}
-	return t.getTable(ctx, "Locations", filter.WithMunger(dropEmpty), filter.WithMunger(hideNotes), filter.WithMunger(dropSoftDeleted), filter.WithMunger(cm))
+	return t.getTable(ctx, tableName, xfOpts...)
 }
 
 // getTable does a thread-safe, just-in-time fetch of a table.
@@ -126,6 +189,7 @@ func (t *Tables) getTable(ctx context.Context, tableName string, xfOpts ...filte
 
 	beeline.AddField(ctx, "fetched", 1)
 	table, err := t.fetcher.Download(ctx, tableName)
+	t.setFetchedAt(tableName, time.Now().UTC())
 	if err != nil {
 		beeline.AddField(ctx, "error", err)
 	} else {
@@ -145,6 +209,20 @@ func (t *Tables) getTable(ctx context.Context, tableName string, xfOpts ...filte
 	return table, err
 }
 
+// FetchedAt returns when the table was downloaded from Airtable, or the zero
+// time if it hasn't been.
+func (t *Tables) FetchedAt(tableName string) time.Time {
+	t.mainLock.RLock()
+	defer t.mainLock.RUnlock()
+	return t.fetched[tableName]
+}
+
+func (t *Tables) setFetchedAt(tableName string, at time.Time) {
+	t.mainLock.Lock()
+	defer t.mainLock.Unlock()
+	t.fetched[tableName] = at
+}
+
 // Returns the lock for the specified table.
 // Creates it if it doesn't exist.
 func (t *Tables) getTableLock(tableName string) *sync.Mutex {
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/dedup"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/filter"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
@@ -74,6 +77,29 @@ func TestTables_GetProviders(t *testing.T) {
 	}
 }
 
+func TestTables_FetchedAt(t *testing.T) {
+	f := &stubFetcher{content: []map[string]interface{}{
+		{
+			"id":   "recA",
+			"name": "test county",
+		},
+	}}
+	tables := NewFakeTables(context.Background(), f)
+	assert.True(t, tables.FetchedAt("Counties").IsZero())
+
+	before := time.Now()
+	_, err := tables.GetCounties(context.Background())
+	assert.NoError(t, err)
+	fetched := tables.FetchedAt("Counties")
+	assert.False(t, fetched.Before(before))
+
+	// Cached reads don't change it.
+	_, err = tables.GetCounties(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, fetched, tables.FetchedAt("Counties"))
+	assert.True(t, tables.FetchedAt("Locations").IsZero())
+}
+
 func TestGetTables_XFormError(t *testing.T) {
 	f := &stubFetcher{
 		content: []map[string]interface{}{
@@ -370,3 +396,83 @@ func TestGetLocations_NoCounties(t *testing.T) {
 		t.Errorf("want error, got nil")
 	}
 }
+
+func TestGetLocations_Dedup(t *testing.T) {
+	content := func() map[string][]map[string]interface{} {
+		return map[string][]map[string]interface{}{
+			"Locations": {
+				{
+					"id":               "1",
+					"Name":             "Kaiser",
+					"google_places_id": "ChIJ-kaiser",
+				},
+				{
+					"id":               "2",
+					"Name":             "Kaiser, again",
+					"google_places_id": "ChIJ-kaiser",
+				},
+				{
+					"id":   "3",
+					"Name": "Unique",
+				},
+			},
+			"Counties": {},
+		}
+	}
+
+	tests := []struct {
+		desc    string
+		policy  dedup.Policy
+		wantLen int
+	}{
+		{desc: "default reports only", wantLen: 3},
+		{desc: "keep", policy: dedup.PolicyKeep, wantLen: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rep := report.New()
+			ctx := report.NewContext(context.Background(), rep)
+			tables := NewFakeTables(ctx, &stubMultiFetcher{content: content()})
+			if tt.policy != "" {
+				tables.SetDedupPolicy(tt.policy)
+			}
+
+			got, err := tables.GetLocations(ctx)
+			assert.NoError(t, err)
+			assert.Len(t, got, tt.wantLen)
+			assert.Equal(t, 1, rep.Count("locations.duplicate_groups"))
+			assert.Equal(t, 3-tt.wantLen, rep.Count("locations.duplicates_removed"))
+			assert.Equal(t, []dedup.Group{{
+				IDs:     []string{"1", "2"},
+				Names:   []string{"Kaiser", "Kaiser, again"},
+				Reasons: []string{"google_places_id:ChIJ-kaiser"},
+			}}, rep.Get("duplicates"))
+		})
+	}
+}
+
+func TestGetLocations_ScrubNotes(t *testing.T) {
+	f := &stubMultiFetcher{
+		content: map[string][]map[string]interface{}{
+			"Locations": {
+				{
+					"id":                  "1",
+					"Phone number":        "833-480-4700",
+					"Latest report yes?":  1.0,
+					"Latest report notes": []interface{}{"Call 833-480-4700, or Jane at 415-555-0134 / jane@gmail.com"},
+				},
+			},
+			"Counties": {},
+		},
+	}
+
+	rep := report.New()
+	ctx := report.NewContext(context.Background(), rep)
+	tables := NewFakeTables(ctx, f)
+
+	got, err := tables.GetLocations(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"Call 833-480-4700, or Jane at [redacted phone] / [redacted email]"}, got[0]["Latest report notes"])
+	assert.Equal(t, 1, rep.Count("redactions.phone"))
+	assert.Equal(t, 1, rep.Count("redactions.email"))
+}
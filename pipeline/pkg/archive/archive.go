@@ -0,0 +1,169 @@
+// Package archive describes the timestamped archive of every publish, kept
+// in the API bucket as archive/<version>/YYYY/MM/DD/HHMM/<path>, where path
+// is the file's path in its version's directory, and the indexes which list
+// what is in it.
+//
+// The archive has a top-level index, listing the days which have snapshots,
+// and an index for each day, at archive/YYYY/MM/DD/index.json, listing its
+// snapshots; a single index of every snapshot would be rewritten every
+// minute, and grow to megabytes.
+package archive
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+)
+
+// Dir is the directory of the archive, at the root of the API bucket.
+const Dir = "archive"
+
+// IndexName is the base name of the top-level and daily indexes.
+const IndexName = "index"
+
+// PruneLimit is about how many expired files are deleted per run, to bound
+// how long pruning takes: whole snapshots are deleted, oldest first, until
+// at least this many files have been.  Each run adds one snapshot, so
+// pruning catches up after retention is shortened, as long as snapshots
+// have fewer files than this.
+const PruneLimit = 500
+
+// VersionDir returns the archive directory of an API version: "legacy", or
+// "v" and the version, as in the API bucket.
+func VersionDir(version deploys.VersionType) string {
+	if version == deploys.LegacyVersion {
+		return "legacy"
+	}
+	return "v" + string(version)
+}
+
+// Day returns the archive path of the day of a time, in UTC.
+func Day(t time.Time) string {
+	return t.UTC().Format("2006/01/02")
+}
+
+// Stamp returns the archive path of the minute of a time, in UTC.
+func Stamp(t time.Time) string {
+	return t.UTC().Format("2006/01/02/1504")
+}
+
+// Index is the top-level index of the archive.
+type Index struct {
+	// Retention is how long snapshots are kept, e.g. "720h0m0s".
+	Retention string `json:"retention"`
+	// Days are the days with snapshots, most recent first.
+	Days []DayEntry `json:"days"`
+}
+
+// DayEntry is a day in the top-level index.
+type DayEntry struct {
+	Day string `json:"day"`
+	// URL is the download URL of the day's index.
+	URL       string `json:"url"`
+	Snapshots int    `json:"snapshots"`
+}
+
+// SetDay adds or updates the entry for a day, keeping the days sorted most
+// recent first.
+func (idx *Index) SetDay(e DayEntry) {
+	for i := range idx.Days {
+		if idx.Days[i].Day == e.Day {
+			idx.Days[i] = e
+			return
+		}
+	}
+	idx.Days = append(idx.Days, e)
+	sort.Slice(idx.Days, func(i, j int) bool { return idx.Days[i].Day > idx.Days[j].Day })
+}
+
+// RemoveDay removes the entry for a day, if there is one.
+func (idx *Index) RemoveDay(day string) {
+	for i := range idx.Days {
+		if idx.Days[i].Day == day {
+			idx.Days = append(idx.Days[:i], idx.Days[i+1:]...)
+			return
+		}
+	}
+}
+
+// DayIndex lists the snapshots of a single day.
+type DayIndex struct {
+	Day string `json:"day"`
+	// Snapshots are most recent first.
+	Snapshots []Snapshot `json:"snapshots"`
+}
+
+// Add adds a snapshot, replacing any earlier one from the same minute,
+// whose files it overwrote.  Files of the earlier snapshot which it didn't
+// overwrite are still in the same directory, so they are listed in the
+// replacement, to be restored and pruned with it.
+func (d *DayIndex) Add(s Snapshot) {
+	stamp := s.Stamp()
+	var kept []Snapshot
+	for _, o := range d.Snapshots {
+		if o.Stamp() != stamp {
+			kept = append(kept, o)
+			continue
+		}
+		s.Files = mergeFiles(s.Files, o.Files)
+	}
+	kept = append(kept, s)
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Generated.After(kept[j].Generated) })
+	d.Snapshots = kept
+}
+
+// mergeFiles returns the union of two snapshots' files, sorted.
+func mergeFiles(a, b map[string][]string) map[string][]string {
+	merged := make(map[string][]string, len(a))
+	for _, files := range []map[string][]string{a, b} {
+		for dir, fs := range files {
+			merged[dir] = append(merged[dir], fs...)
+		}
+	}
+	for dir, fs := range merged {
+		sort.Strings(fs)
+		var uniq []string
+		for i, f := range fs {
+			if i == 0 || f != fs[i-1] {
+				uniq = append(uniq, f)
+			}
+		}
+		merged[dir] = uniq
+	}
+	return merged
+}
+
+// Snapshot is the archived output of a single run.
+type Snapshot struct {
+	RunID     string    `json:"runId"`
+	Generated time.Time `json:"generated"`
+	// Files are the paths of the files archived for each version, relative
+	// to the version's directory, keyed by VersionDir.
+	Files map[string][]string `json:"files"`
+}
+
+// Stamp returns the archive path of the snapshot's minute.
+func (s *Snapshot) Stamp() string {
+	return Stamp(s.Generated)
+}
+
+// Paths returns the paths of the snapshot's files, relative to the archive
+// directory, sorted.
+func (s *Snapshot) Paths() []string {
+	var paths []string
+	for dir, files := range s.Files {
+		for _, f := range files {
+			paths = append(paths, path.Join(dir, s.Stamp(), f))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Expired returns if the snapshot is older than the retention at the given
+// time.
+func (s *Snapshot) Expired(now time.Time, retention time.Duration) bool {
+	return s.Generated.Before(now.Add(-retention))
+}
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPaths(t *testing.T) {
+	if got, want := VersionDir(deploys.LegacyVersion), "legacy"; got != want {
+		t.Errorf("VersionDir(legacy): got %q, want %q", got, want)
+	}
+	if got, want := VersionDir("1"), "v1"; got != want {
+		t.Errorf("VersionDir(1): got %q, want %q", got, want)
+	}
+
+	// Stamps are in UTC.
+	pacific := time.FixedZone("PDT", -7*60*60)
+	s := Snapshot{
+		Generated: time.Date(2026, 10, 18, 8, 30, 59, 0, pacific),
+		Files: map[string][]string{
+			"v1":     {"locations.json", "locations.csv"},
+			"legacy": {"Locations.json"},
+		},
+	}
+	if got, want := s.Stamp(), "2026/10/18/1530"; got != want {
+		t.Errorf("Stamp: got %q, want %q", got, want)
+	}
+	want := []string{
+		"legacy/2026/10/18/1530/Locations.json",
+		"v1/2026/10/18/1530/locations.csv",
+		"v1/2026/10/18/1530/locations.json",
+	}
+	if diff := cmp.Diff(want, s.Paths()); diff != "" {
+		t.Errorf("Paths: -want +got:\n%v\n", diff)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	var idx Index
+	idx.SetDay(DayEntry{Day: "2026/10/17", Snapshots: 1})
+	idx.SetDay(DayEntry{Day: "2026/10/18", Snapshots: 1})
+	idx.SetDay(DayEntry{Day: "2026/10/16", Snapshots: 1})
+	idx.SetDay(DayEntry{Day: "2026/10/18", Snapshots: 2})
+	idx.RemoveDay("2026/10/16")
+	idx.RemoveDay("2026/10/01")
+	want := []DayEntry{
+		{Day: "2026/10/18", Snapshots: 2},
+		{Day: "2026/10/17", Snapshots: 1},
+	}
+	if diff := cmp.Diff(want, idx.Days); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
+
+func TestDayIndex(t *testing.T) {
+	at := func(minute, second int) time.Time {
+		return time.Date(2026, 10, 18, 15, minute, second, 0, time.UTC)
+	}
+	var d DayIndex
+	d.Add(Snapshot{RunID: "a", Generated: at(30, 0)})
+	d.Add(Snapshot{RunID: "b", Generated: at(31, 0), Files: map[string][]string{
+		"legacy": {"Locations.json"},
+		"v1":     {"counties.json", "locations.json"},
+	}})
+	// A second run in the same minute overwrote some of the first's files;
+	// the rest are still listed, with its own.
+	d.Add(Snapshot{RunID: "c", Generated: at(31, 30), Files: map[string][]string{
+		"v1": {"locations.json", "providers.json"},
+	}})
+	var got []string
+	for _, s := range d.Snapshots {
+		got = append(got, s.RunID)
+	}
+	if diff := cmp.Diff([]string{"c", "a"}, got); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+	want := map[string][]string{
+		"legacy": {"Locations.json"},
+		"v1":     {"counties.json", "locations.json", "providers.json"},
+	}
+	if diff := cmp.Diff(want, d.Snapshots[0].Files); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+
+	s := Snapshot{Generated: at(30, 0)}
+	if s.Expired(at(30, 0).Add(24*time.Hour), 48*time.Hour) {
+		t.Errorf("snapshot from a day ago expired with two days' retention")
+	}
+	if !s.Expired(at(30, 0).Add(72*time.Hour), 48*time.Hour) {
+		t.Errorf("snapshot from three days ago not expired with two days' retention")
+	}
+}
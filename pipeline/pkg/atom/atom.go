@@ -0,0 +1,271 @@
+// Package atom generates Atom (RFC 4287) feeds of locations whose latest
+// report found vaccine available, for people to follow in feed readers.
+//
+// Feeds are generated from each run's change set: a location gets an entry
+// when its latest report changes, and the new report found vaccine
+// available.  Entries from the previously published feed are kept, so that
+// feed readers which poll less often than the pipeline runs don't miss any.
+// Each entry is identified by the location and the time of the report, so a
+// feed reader shows a location again each time a new report of it says yes.
+package atom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/changes"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// ContentType is the registered media type of Atom feeds.
+const ContentType = "application/atom+xml"
+
+// Extension is the file extension of Atom feeds.
+const Extension = ".atom"
+
+// MaxEntries is the most entries a feed has; the most recent reports are
+// kept.
+const MaxEntries = 100
+
+// The fields of Locations rows which feeds are generated from.
+const (
+	NameField         = "Name"
+	AddressField      = "Address"
+	CountyField       = "County"
+	AvailabilityField = "Availability Info"
+	SchedulingField   = "Appointment scheduling instructions"
+	ReportField       = "Latest report"
+	ReportYesField    = "Latest report yes?"
+)
+
+// idPrefix starts the ids of feeds and entries; it is a tag URI (RFC 4151)
+// under vaccinateca.com.
+const idPrefix = "tag:vaccinateca.com,2021:"
+
+// Feed is an Atom feed.
+type Feed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Author  Person   `xml:"author"`
+	Links   []Link   `xml:"link"`
+	Entries []Entry  `xml:"entry"`
+}
+
+// Person is the author of a feed.
+type Person struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+// Link is a link from a feed.
+type Link struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// Entry is a location's latest report.
+type Entry struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Updated string `xml:"updated"`
+	Content Text   `xml:"content"`
+}
+
+// Text is plain text content.
+type Text struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Options describe a feed as a whole.
+type Options struct {
+	// Name identifies the feed; it is the last part of the feed's id, e.g.
+	// "1/locations".
+	Name  string
+	Title string
+	// Self is the URL the feed is published at.
+	Self string
+}
+
+// Reported returns the rows of the table whose latest report changed in the
+// change set: those which were added, and those whose ReportField was
+// modified.  There are none without a change set, e.g. on the first run.
+func Reported(table types.TableContent, set *changes.Set) types.TableContent {
+	if set == nil {
+		return nil
+	}
+	ids := map[string]bool{}
+	for _, row := range set.Added {
+		ids[fmt.Sprint(row["id"])] = true
+	}
+	for _, m := range set.Modified {
+		if _, ok := m.Fields[ReportField]; ok {
+			ids[m.ID] = true
+		}
+	}
+	var out types.TableContent
+	for _, row := range table {
+		if ids[fmt.Sprint(row["id"])] {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// Build returns a feed of the entries of the previous feed, if there is
+// one, and an entry for each of the rows whose report found vaccine
+// available, newest first.  Its updated time is that of the newest entry, so
+// it only changes when there is a new one.  It also returns how many of the
+// rows reported vaccine available at a time which isn't RFC 3339, so have no
+// entry.
+func Build(previous *Feed, rows types.TableContent, opts Options) (*Feed, int) {
+	byID := map[string]Entry{}
+	if previous != nil {
+		for _, e := range previous.Entries {
+			byID[e.ID] = e
+		}
+	}
+	invalid := 0
+	for _, row := range rows {
+		at, ok, err := availableAt(row)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		title, _ := row[NameField].(string)
+		if title == "" {
+			title = fmt.Sprint(row["id"])
+		}
+		e := Entry{
+			ID:      fmt.Sprintf("%s%v/%s", idPrefix, row["id"], formatTime(at)),
+			Title:   title,
+			Updated: formatTime(at),
+			Content: Text{Type: "text", Body: content(row)},
+		}
+		byID[e.ID] = e
+	}
+	entries := make([]Entry, 0, len(byID))
+	for _, e := range byID {
+		entries = append(entries, e)
+	}
+	// Times are all formatted the same way, in UTC, so sort as strings.
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Updated != entries[j].Updated {
+			return entries[i].Updated > entries[j].Updated
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	if len(entries) > MaxEntries {
+		entries = entries[:MaxEntries]
+	}
+
+	f := &Feed{
+		ID:      idPrefix + opts.Name,
+		Title:   opts.Title,
+		Updated: formatTime(time.Unix(0, 0)),
+		Author:  Person{Name: "VaccinateCA", URI: "https://www.vaccinateca.com/"},
+		Links: []Link{
+			{Href: "https://www.vaccinateca.com/", Rel: "alternate", Type: "text/html"},
+		},
+		Entries: entries,
+	}
+	if opts.Self != "" {
+		f.Links = append(f.Links, Link{Href: opts.Self, Rel: "self", Type: ContentType})
+	}
+	if len(entries) > 0 {
+		f.Updated = entries[0].Updated
+	}
+	return f, invalid
+}
+
+// availableAt returns the time of the row's latest report, and whether it
+// found vaccine available.  It returns an error if the report found vaccine
+// available, but its time can't be parsed.
+func availableAt(row map[string]interface{}) (time.Time, bool, error) {
+	switch yes := row[ReportYesField].(type) {
+	case float64:
+		if yes != 1 {
+			return time.Time{}, false, nil
+		}
+	case int:
+		if yes != 1 {
+			return time.Time{}, false, nil
+		}
+	default:
+		return time.Time{}, false, nil
+	}
+	s, ok := row[ReportField].(string)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return at, true, nil
+}
+
+// Parse decodes a feed, as written by Encode.
+func Parse(data []byte) (*Feed, error) {
+	var f Feed
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// content returns the text of a row's entry: each of its address, county,
+// availability and scheduling instructions which are set, one per line.
+func content(row map[string]interface{}) string {
+	var lines []string
+	add := func(label string, v interface{}) {
+		var s string
+		switch t := v.(type) {
+		case string:
+			s = t
+		case []string:
+			s = strings.Join(t, "; ")
+		case []interface{}:
+			l := make([]string, len(t))
+			for i, e := range t {
+				l[i] = fmt.Sprint(e)
+			}
+			s = strings.Join(l, "; ")
+		}
+		if s = strings.TrimSpace(s); s != "" {
+			lines = append(lines, label+": "+s)
+		}
+	}
+	add("Address", row[AddressField])
+	add("County", row[CountyField])
+	add("Availability", row[AvailabilityField])
+	add("Appointment scheduling instructions", row[SchedulingField])
+	return strings.Join(lines, "\n")
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Encode returns the feed as an XML document.
+func (f *Feed) Encode() ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return nil, err
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+package atom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/changes"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReported(t *testing.T) {
+	table := types.TableContent{
+		{"id": "rec1", "Latest report": "2021-04-01T10:00:00.000Z"},
+		{"id": "rec2", "Latest report": "2021-04-01T12:00:00.000Z"},
+		{"id": "rec3", "Name": "Renamed"},
+		{"id": "rec4"},
+	}
+	set := &changes.Set{
+		Added: types.TableContent{table[0]},
+		Modified: []changes.Modification{
+			{ID: "rec2", Fields: map[string]changes.FieldChange{"Latest report": {After: "2021-04-01T12:00:00.000Z"}}},
+			{ID: "rec3", Fields: map[string]changes.FieldChange{"Name": {After: "Renamed"}}},
+		},
+	}
+	var got []interface{}
+	for _, row := range Reported(table, set) {
+		got = append(got, row["id"])
+	}
+	if diff := cmp.Diff([]interface{}{"rec1", "rec2"}, got); diff != "" {
+		t.Errorf("unexpected rows (-want +got):\n%s", diff)
+	}
+	if got := Reported(table, nil); len(got) != 0 {
+		t.Errorf("got %d rows without a change set, want none", len(got))
+	}
+}
+
+func TestBuild(t *testing.T) {
+	previous := &Feed{Entries: []Entry{
+		{ID: "tag:vaccinateca.com,2021:rec0/2021-04-01T09:00:00Z", Title: "Earlier", Updated: "2021-04-01T09:00:00Z"},
+		// A report which is also in the rows isn't repeated.
+		{ID: "tag:vaccinateca.com,2021:rec1/2021-04-01T10:00:00Z", Title: "Older", Updated: "2021-04-01T10:00:00Z"},
+	}}
+	rows := types.TableContent{
+		{"id": "rec1", "Name": "Older", "Latest report": "2021-04-01T10:00:00.000Z", "Latest report yes?": 1.0,
+			"Address": "1 Main St", "County": "Alameda County",
+			"Availability Info":                   []interface{}{"Yes: appointments", "Yes: 65+"},
+			"Appointment scheduling instructions": "Book online",
+		},
+		{"id": "rec2", "Name": "No", "Latest report": "2021-04-01T12:00:00.000Z", "Latest report yes?": 0.0},
+		{"id": "rec3", "Name": "Newer", "Latest report": "2021-04-01T11:00:00.000Z", "Latest report yes?": 1.0},
+		{"id": "rec4", "Name": "Never reported", "Latest report yes?": 1.0},
+		{"id": "rec5", "Latest report": "2021-04-01T11:00:00.000Z", "Latest report yes?": 1},
+		{"id": "rec6", "Name": "Bad time", "Latest report": "yesterday", "Latest report yes?": 1.0},
+		{"id": "rec7", "Name": "Bad time, but no", "Latest report": "yesterday", "Latest report yes?": 0.0},
+	}
+	f, invalid := Build(previous, rows, Options{Name: "1/locations", Title: "Test", Self: "https://example.com/locations.atom"})
+
+	var got []string
+	for _, e := range f.Entries {
+		got = append(got, e.ID+" "+e.Title)
+	}
+	want := []string{
+		"tag:vaccinateca.com,2021:rec3/2021-04-01T11:00:00Z Newer",
+		"tag:vaccinateca.com,2021:rec5/2021-04-01T11:00:00Z rec5",
+		"tag:vaccinateca.com,2021:rec1/2021-04-01T10:00:00Z Older",
+		"tag:vaccinateca.com,2021:rec0/2021-04-01T09:00:00Z Earlier",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected entries (-want +got):\n%s", diff)
+	}
+	if invalid != 1 {
+		t.Errorf("got %d invalid reports, want 1", invalid)
+	}
+	if f.Updated != "2021-04-01T11:00:00Z" {
+		t.Errorf("got updated %q, want the newest entry's", f.Updated)
+	}
+	wantContent := "Address: 1 Main St\nCounty: Alameda County\nAvailability: Yes: appointments; Yes: 65+\nAppointment scheduling instructions: Book online"
+	if diff := cmp.Diff(wantContent, f.Entries[2].Content.Body); diff != "" {
+		t.Errorf("unexpected content (-want +got):\n%s", diff)
+	}
+	if f.ID != "tag:vaccinateca.com,2021:1/locations" {
+		t.Errorf("got id %q", f.ID)
+	}
+}
+
+func TestBuild_Limit(t *testing.T) {
+	var rows types.TableContent
+	for i := 0; i < MaxEntries+10; i++ {
+		rows = append(rows, map[string]interface{}{
+			"id":                 fmt.Sprintf("rec%03d", i),
+			"Latest report":      fmt.Sprintf("2021-04-01T10:%02d:%02d.000Z", i/60, i%60),
+			"Latest report yes?": 1.0,
+		})
+	}
+	f, _ := Build(nil, rows, Options{Name: "test"})
+	if len(f.Entries) != MaxEntries {
+		t.Fatalf("got %d entries, want %d", len(f.Entries), MaxEntries)
+	}
+	if !strings.HasPrefix(f.Entries[0].ID, "tag:vaccinateca.com,2021:rec109/") {
+		t.Errorf("got first entry %q, want the newest", f.Entries[0].ID)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	f, _ := Build(nil, types.TableContent{}, Options{Name: "test", Title: "Empty & quiet"})
+	data, err := f.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("missing XML header:\n%s", data)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.XMLName.Space != "http://www.w3.org/2005/Atom" {
+		t.Errorf("got namespace %q", got.XMLName.Space)
+	}
+	if got.Title != "Empty & quiet" || got.Updated != "1970-01-01T00:00:00Z" || len(got.Entries) != 0 {
+		t.Errorf("unexpected feed %+v", got)
+	}
+}
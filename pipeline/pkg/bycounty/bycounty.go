@@ -0,0 +1,89 @@
+// Package bycounty splits tables of locations into one table per county, so
+// that county pages only need to fetch the locations in their county.
+package bycounty
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// CountyField is the field of both Locations and Counties rows which holds
+// the county name.
+const CountyField = "County"
+
+var nonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slug returns the name used for a county in file names and URLs: lower
+// case, without a trailing "County", with words joined by "_".  This matches
+// the county pages on vaccinateca.com, e.g. "San Francisco" and "San
+// Francisco County" are both "san_francisco".
+func Slug(county string) string {
+	s := strings.ToLower(strings.TrimSpace(county))
+	s = strings.TrimSuffix(s, " county")
+	return strings.Trim(nonAlnum.ReplaceAllString(s, "_"), "_")
+}
+
+// County is a county which has a split file.
+type County struct {
+	Name string
+	Slug string
+	// Rows are the locations in the county.  They are shared with the
+	// table which was split.
+	Rows types.TableContent
+}
+
+// Split returns a County for every county named in the counties table,
+// sorted by slug, each with the rows of the table which are in that county,
+// and the number of rows which weren't in any of them.  Counties with no
+// rows are included, so every county has a file.
+func Split(table, counties types.TableContent) ([]*County, int) {
+	bySlug := map[string]*County{}
+	for _, c := range counties {
+		name, ok := c[CountyField].(string)
+		if !ok || Slug(name) == "" {
+			continue
+		}
+		slug := Slug(name)
+		if _, ok := bySlug[slug]; !ok {
+			bySlug[slug] = &County{Name: name, Slug: slug, Rows: types.TableContent{}}
+		}
+	}
+
+	unmatched := 0
+	for _, row := range table {
+		name, _ := row[CountyField].(string)
+		c, ok := bySlug[Slug(name)]
+		if !ok {
+			unmatched++
+			continue
+		}
+		c.Rows = append(c.Rows, row)
+	}
+
+	out := make([]*County, 0, len(bySlug))
+	for _, c := range bySlug {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Slug < out[j].Slug
+	})
+	return out, unmatched
+}
+
+// IndexEntry describes one county's split file.
+type IndexEntry struct {
+	County string `json:"county"`
+	Slug   string `json:"slug"`
+	URL    string `json:"url"`
+	Rows   int    `json:"rows"`
+	// Feed is the URL of the county's Atom feed, if the endpoint has one.
+	Feed string `json:"feed,omitempty"`
+}
+
+// Index lists every county's split file.
+type Index struct {
+	Counties []IndexEntry `json:"counties"`
+}
@@ -0,0 +1,54 @@
+package bycounty
+
+import (
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSlug(t *testing.T) {
+	tests := map[string]string{
+		"San Francisco":         "san_francisco",
+		"San Francisco County":  "san_francisco",
+		" Los Angeles County ":  "los_angeles",
+		"Del Norte County":      "del_norte",
+		"El Dorado":             "el_dorado",
+		"County of Los Angeles": "county_of_los_angeles",
+		"":                      "",
+	}
+	for in, want := range tests {
+		if got := Slug(in); got != want {
+			t.Errorf("Slug(%q): got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	counties := types.TableContent{
+		{"id": "c1", "County": "San Francisco"},
+		{"id": "c2", "County": "Alameda County"},
+		{"id": "c3", "County": "Alpine County"},
+		{"id": "c4"},
+	}
+	table := types.TableContent{
+		{"id": "l1", "County": "San Francisco County"},
+		{"id": "l2", "County": "Alameda County"},
+		{"id": "l3", "County": "San Francisco"},
+		{"id": "l4", "County": "Atlantis"},
+		{"id": "l5"},
+	}
+
+	got, unmatched := Split(table, counties)
+	if unmatched != 2 {
+		t.Errorf("got %d unmatched, want 2", unmatched)
+	}
+	want := []*County{
+		{Name: "Alameda County", Slug: "alameda", Rows: types.TableContent{table[1]}},
+		{Name: "Alpine County", Slug: "alpine", Rows: types.TableContent{}},
+		{Name: "San Francisco", Slug: "san_francisco", Rows: types.TableContent{table[0], table[2]}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
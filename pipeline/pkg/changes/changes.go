@@ -0,0 +1,164 @@
+// Package changes compares an endpoint's output with what it previously
+// published, by record id, so that consumers can see what changed without
+// diffing the whole output themselves.
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// Name is the suffix of the change feed files, which are published next to
+// the endpoint's data, as <resource>.changes.json and
+// <resource>.changes.recent.json.
+const Name = "changes"
+
+// RecentName is the suffix of the file holding the rolling window of recent
+// change sets.
+const RecentName = "changes.recent"
+
+// Window is how many change sets are kept in the recent change sets.  Runs
+// which change nothing don't add to it.
+const Window = 60
+
+// Set is the changes between two consecutive outputs of an endpoint.
+type Set struct {
+	// Generated is when the newer output was generated.
+	Generated time.Time `json:"generated"`
+	// RunID is the pipeline run which generated the newer output.
+	RunID string `json:"runId"`
+	// Added and Removed are the records which only appear in the newer or
+	// the older output, respectively.
+	Added    types.TableContent `json:"added"`
+	Removed  types.TableContent `json:"removed"`
+	Modified []Modification     `json:"modified"`
+}
+
+// Empty returns if nothing changed.
+func (s *Set) Empty() bool {
+	return len(s.Added) == 0 && len(s.Removed) == 0 && len(s.Modified) == 0
+}
+
+// Modification lists the fields of a record which changed.
+type Modification struct {
+	ID     string                 `json:"id"`
+	Fields map[string]FieldChange `json:"fields"`
+}
+
+// FieldChange is a single field's value before and after a change; a value
+// is null if the field was not set.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Recent is the rolling window of recent change sets of an endpoint, most
+// recent first.
+type Recent struct {
+	Sets []Set `json:"sets"`
+}
+
+// Add adds a change set to the front of the window, if it isn't empty, and
+// drops the oldest sets beyond Window.
+func (r *Recent) Add(s *Set) {
+	if s.Empty() {
+		return
+	}
+	r.Sets = append([]Set{*s}, r.Sets...)
+	if len(r.Sets) > Window {
+		r.Sets = r.Sets[:Window]
+	}
+}
+
+// Diff returns the changes from the rows in before to those in after.  Both
+// should have been decoded from JSON, so that equal values have equal
+// types.  Added and removed records are in the order they appear in their
+// outputs; modifications are in the order of the newer output.
+func Diff(before, after types.TableContent) (*Set, error) {
+	old := make(map[string]map[string]interface{}, len(before))
+	for _, r := range before {
+		id, err := rowID(r)
+		if err != nil {
+			return nil, fmt.Errorf("previous output: %w", err)
+		}
+		old[id] = r
+	}
+
+	s := &Set{
+		Added:    types.TableContent{},
+		Removed:  types.TableContent{},
+		Modified: []Modification{},
+	}
+	seen := make(map[string]bool, len(after))
+	for _, r := range after {
+		id, err := rowID(r)
+		if err != nil {
+			return nil, err
+		}
+		seen[id] = true
+		prev, ok := old[id]
+		if !ok {
+			s.Added = append(s.Added, r)
+			continue
+		}
+		if fields := diffRow(prev, r); len(fields) > 0 {
+			s.Modified = append(s.Modified, Modification{ID: id, Fields: fields})
+		}
+	}
+	for _, r := range before {
+		id, _ := rowID(r)
+		if !seen[id] {
+			s.Removed = append(s.Removed, r)
+		}
+	}
+	return s, nil
+}
+
+// diffRow returns the fields whose values differ between two versions of a
+// record.
+func diffRow(before, after map[string]interface{}) map[string]FieldChange {
+	fields := map[string]FieldChange{}
+	for k, b := range before {
+		if a := after[k]; !reflect.DeepEqual(b, a) {
+			fields[k] = FieldChange{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			fields[k] = FieldChange{Before: nil, After: a}
+		}
+	}
+	return fields
+}
+
+func rowID(r map[string]interface{}) (string, error) {
+	id, ok := r["id"].(string)
+	if !ok || id == "" {
+		return "", errors.New("row without an id")
+	}
+	return id, nil
+}
+
+// Rows returns the rows of an endpoint's JSON output, which is either a bare
+// list of rows, or a metadata.APIResponse.
+func Rows(data []byte) (types.TableContent, error) {
+	var rows types.TableContent
+	if err := json.Unmarshal(data, &rows); err == nil {
+		return rows, nil
+	}
+	var wrapped struct {
+		Content *types.TableContent `json:"content"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, err
+	}
+	if wrapped.Content == nil {
+		return nil, errors.New("no content in output")
+	}
+	return *wrapped.Content, nil
+}
@@ -0,0 +1,115 @@
+package changes
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiff(t *testing.T) {
+	before := types.TableContent{
+		{"id": "rec1", "Name": "Same"},
+		{"id": "rec2", "Name": "Old name", "Latest report yes?": 0.0, "Notes": "gone"},
+		{"id": "rec3", "Name": "Removed"},
+	}
+	after := types.TableContent{
+		{"id": "rec4", "Name": "Added"},
+		{"id": "rec2", "Name": "New name", "Latest report yes?": 1.0, "Types": []interface{}{"Pharmacy"}},
+		{"id": "rec1", "Name": "Same"},
+	}
+	got, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &Set{
+		Added:   types.TableContent{{"id": "rec4", "Name": "Added"}},
+		Removed: types.TableContent{{"id": "rec3", "Name": "Removed"}},
+		Modified: []Modification{{
+			ID: "rec2",
+			Fields: map[string]FieldChange{
+				"Name":               {Before: "Old name", After: "New name"},
+				"Latest report yes?": {Before: 0.0, After: 1.0},
+				"Notes":              {Before: "gone", After: nil},
+				"Types":              {Before: nil, After: []interface{}{"Pharmacy"}},
+			},
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+
+	same, err := Diff(before, before)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !same.Empty() {
+		t.Errorf("Diff of identical outputs is not empty: %+v", same)
+	}
+
+	if _, err := Diff(before, types.TableContent{{"Name": "No id"}}); err == nil {
+		t.Errorf("Diff of a row without an id: got no error")
+	}
+}
+
+func TestRows(t *testing.T) {
+	tests := []struct {
+		desc    string
+		data    string
+		want    types.TableContent
+		wantErr bool
+	}{
+		{
+			desc: "bare",
+			data: `[{"id":"rec1"}]`,
+			want: types.TableContent{{"id": "rec1"}},
+		},
+		{
+			desc: "wrapped",
+			data: `{"usage":{},"content":[{"id":"rec1"}]}`,
+			want: types.TableContent{{"id": "rec1"}},
+		},
+		{
+			desc:    "no content",
+			data:    `{"usage":{}}`,
+			wantErr: true,
+		},
+		{
+			desc:    "not JSON",
+			data:    `id,Name`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := Rows([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("-want +got:\n%v\n", diff)
+			}
+		})
+	}
+}
+
+func TestRecent(t *testing.T) {
+	var r Recent
+	r.Add(&Set{RunID: "empty"})
+	if len(r.Sets) != 0 {
+		t.Errorf("empty change set was added")
+	}
+	for i := 0; i < Window+5; i++ {
+		r.Add(&Set{RunID: fmt.Sprint(i), Added: types.TableContent{{"id": "rec1"}}})
+	}
+	if len(r.Sets) != Window {
+		t.Fatalf("got %d sets, want %d", len(r.Sets), Window)
+	}
+	if got, want := r.Sets[0].RunID, fmt.Sprint(Window+4); got != want {
+		t.Errorf("most recent set: got %q, want %q", got, want)
+	}
+	if got, want := r.Sets[Window-1].RunID, "5"; got != want {
+		t.Errorf("oldest set: got %q, want %q", got, want)
+	}
+}
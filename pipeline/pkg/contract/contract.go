@@ -0,0 +1,163 @@
+// Package contract records the field contract of every API version, the
+// names and types of each endpoint's fields, in a checked-in lockfile, and
+// checks that changes to EndpointMap keep to it: within a version, fields
+// may be added, but not removed, renamed or changed in type.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/protobuf"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// DefaultFile is the lockfile, relative to the root of the repository.
+const DefaultFile = "pipeline/pkg/endpoints/versions.lock.json"
+
+// Field is a field of an endpoint's output.
+type Field struct {
+	Name string          `json:"name"`
+	Type types.FieldType `json:"type"`
+}
+
+// Endpoint is the contract of an endpoint: its fields, in order, starting
+// with "id".
+type Endpoint struct {
+	Fields []Field `json:"fields"`
+	// Protobuf is set if the endpoint is published as protobuf, whose field
+	// numbers are the fields' positions, so existing fields can't move.
+	Protobuf bool `json:"protobuf,omitempty"`
+}
+
+// Lock is the contract of every version, by version and resource.
+type Lock struct {
+	Versions map[string]map[string]Endpoint `json:"versions"`
+}
+
+// FromEndpoints returns the contract of the given endpoints.
+func FromEndpoints(eps []endpoints.Endpoint) *Lock {
+	l := &Lock{Versions: map[string]map[string]Endpoint{}}
+	for _, ep := range eps {
+		v := string(ep.Version)
+		if l.Versions[v] == nil {
+			l.Versions[v] = map[string]Endpoint{}
+		}
+		ts := ep.Types()
+		e := Endpoint{}
+		for _, c := range ep.Columns() {
+			e.Fields = append(e.Fields, Field{Name: c, Type: ts[c]})
+		}
+		for _, f := range ep.Formats {
+			if f == protobuf.FormatName {
+				e.Protobuf = true
+			}
+		}
+		l.Versions[v][ep.Resource] = e
+	}
+	return l
+}
+
+// Read reads a lockfile.
+func Read(file string) (*Lock, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var l Lock
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return &l, nil
+}
+
+// Encode returns the lockfile's contents.
+func (l *Lock) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Equal returns if two contracts are the same.
+func (l *Lock) Equal(other *Lock) bool {
+	a, errA := l.Encode()
+	b, errB := other.Encode()
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// Incompatibility is a change to a locked version which breaks its
+// contract.
+type Incompatibility struct {
+	// Endpoint is the endpoint, as <version>/<resource>, or just the
+	// version, if the whole version was removed.
+	Endpoint string
+	Message  string
+}
+
+func (i Incompatibility) String() string {
+	return i.Endpoint + ": " + i.Message
+}
+
+// Check returns every way in which current breaks the locked contract,
+// sorted by endpoint.  Versions, endpoints and fields which aren't in the
+// lock are new, so are compatible.
+func Check(locked, current *Lock) []Incompatibility {
+	var out []Incompatibility
+	for v, resources := range locked.Versions {
+		cur, ok := current.Versions[v]
+		if !ok {
+			out = append(out, Incompatibility{Endpoint: v, Message: "version was removed"})
+			continue
+		}
+		for r, want := range resources {
+			name := v + "/" + r
+			got, ok := cur[r]
+			if !ok {
+				out = append(out, Incompatibility{Endpoint: name, Message: "endpoint was removed"})
+				continue
+			}
+			out = append(out, checkEndpoint(name, want, got)...)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Endpoint < out[j].Endpoint
+	})
+	return out
+}
+
+func checkEndpoint(name string, want, got Endpoint) []Incompatibility {
+	var out []Incompatibility
+	positions := make(map[string]int, len(got.Fields))
+	for i, f := range got.Fields {
+		positions[f.Name] = i
+	}
+	for i, f := range want.Fields {
+		j, ok := positions[f.Name]
+		if !ok {
+			out = append(out, Incompatibility{
+				Endpoint: name,
+				Message:  fmt.Sprintf("field %q (%s) was removed or renamed", f.Name, f.Type),
+			})
+			continue
+		}
+		if t := got.Fields[j].Type; t != f.Type {
+			out = append(out, Incompatibility{
+				Endpoint: name,
+				Message:  fmt.Sprintf("field %q changed type from %s to %s", f.Name, f.Type, t),
+			})
+		}
+		if want.Protobuf && got.Protobuf && i != j {
+			out = append(out, Incompatibility{
+				Endpoint: name,
+				Message:  fmt.Sprintf("field %q moved from position %d to %d, which changes its protobuf field number", f.Name, i+1, j+1),
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package contract
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestLockfile fails the build if a change to EndpointMap breaks the
+// contract of an existing version, or adds fields without recording them.
+func TestLockfile(t *testing.T) {
+	locked, err := Read(filepath.Join("..", "endpoints", filepath.Base(DefaultFile)))
+	if err != nil {
+		t.Fatalf("failed to read the lockfile: %v", err)
+	}
+	current := FromEndpoints(endpoints.AllEndpoints())
+	for _, i := range Check(locked, current) {
+		t.Errorf("%s", i)
+	}
+	if !t.Failed() && !locked.Equal(current) {
+		t.Errorf("%s is out of date; run `go run ./pipeline/cmd/contract -update` and check in the diff", DefaultFile)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	f := func(name string, typ types.FieldType) Field { return Field{Name: name, Type: typ} }
+	locked := &Lock{Versions: map[string]map[string]Endpoint{
+		"1": {
+			"locations": {Fields: []Field{f("id", types.String), f("Name", types.String), f("Latitude", types.Number)}, Protobuf: true},
+			"counties":  {Fields: []Field{f("id", types.String), f("County", types.String), f("Notes", types.String)}},
+		},
+		"LEGACY": {
+			"Locations": {Fields: []Field{f("id", types.String)}},
+		},
+	}}
+
+	tests := []struct {
+		desc    string
+		current *Lock
+		want    []string
+	}{
+		{
+			desc:    "unchanged",
+			current: locked,
+		},
+		{
+			desc: "fields, endpoints and versions added",
+			current: &Lock{Versions: map[string]map[string]Endpoint{
+				"1": {
+					"locations": {Fields: []Field{f("id", types.String), f("Name", types.String), f("Latitude", types.Number), f("Longitude", types.Number)}, Protobuf: true},
+					"counties":  {Fields: []Field{f("id", types.String), f("Twitter", types.String), f("County", types.String), f("Notes", types.String)}},
+					"providers": {Fields: []Field{f("id", types.String)}},
+				},
+				"LEGACY": locked.Versions["LEGACY"],
+				"2":      {"locations": {Fields: []Field{f("id", types.String)}}},
+			}},
+		},
+		{
+			desc: "broken",
+			current: &Lock{Versions: map[string]map[string]Endpoint{
+				"1": {
+					"locations": {Fields: []Field{f("id", types.String), f("Latitude", types.String), f("Name", types.String)}, Protobuf: true},
+					"counties":  {Fields: []Field{f("id", types.String), f("County name", types.String), f("Notes", types.StringList)}},
+				},
+			}},
+			want: []string{
+				`1/counties: field "County" (string) was removed or renamed`,
+				`1/counties: field "Notes" changed type from string to string list`,
+				`1/locations: field "Name" moved from position 2 to 3, which changes its protobuf field number`,
+				`1/locations: field "Latitude" changed type from number to string`,
+				`1/locations: field "Latitude" moved from position 3 to 2, which changes its protobuf field number`,
+				`LEGACY: version was removed`,
+			},
+		},
+		{
+			desc: "endpoint removed",
+			current: &Lock{Versions: map[string]map[string]Endpoint{
+				"1":      {"locations": locked.Versions["1"]["locations"]},
+				"LEGACY": locked.Versions["LEGACY"],
+			}},
+			want: []string{`1/counties: endpoint was removed`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var got []string
+			for _, i := range Check(locked, tt.current) {
+				got = append(got, i.String())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected incompatibilities (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
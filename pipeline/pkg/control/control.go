@@ -0,0 +1,172 @@
+// Package control holds the operator controls over publishing, which are
+// kept as files in the deploy's control bucket, which isn't public, so that
+// they apply to every instance of the pipeline and survive restarts.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+)
+
+// Base names of the control files.
+const (
+	pauseName = "pause"
+	pinsName  = "pins"
+)
+
+// ErrPaused is returned by publisher.Run when publishing is paused.
+var ErrPaused = errors.New("publishing is paused")
+
+// Pause records that scheduled publishes are paused, and why.  It is shown
+// by the freshness monitor, so By should be a name, not an email address.
+type Pause struct {
+	Since  time.Time `json:"since"`
+	By     string    `json:"by"`
+	Reason string    `json:"reason"`
+	// RolledBackTo is the run ID of the archived snapshot which was
+	// restored, if the pause is from a rollback.
+	RolledBackTo string `json:"rolledBackTo,omitempty"`
+}
+
+// url returns the upload URL of a control file, without the extension.
+func url(name string) (string, error) {
+	root, err := deploys.GetControlUploadURL()
+	if err != nil {
+		return "", err
+	}
+	return root + "/" + name, nil
+}
+
+// GetPause returns the current pause, or nil if publishing isn't paused.
+func GetPause(ctx context.Context, sr deploys.StorageReader) (*Pause, error) {
+	var p Pause
+	ok, err := read(ctx, sr, pauseName, &p)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SetPause pauses publishing, until ClearPause is called.
+func SetPause(ctx context.Context, sw deploys.StorageWriter, p Pause) error {
+	return write(ctx, sw, pauseName, p)
+}
+
+// ClearPause resumes publishing; it is not an error if it isn't paused.
+func ClearPause(ctx context.Context, sd deploys.StorageDeleter) error {
+	u, err := url(pauseName)
+	if err != nil {
+		return err
+	}
+	return sd(ctx, u+".json")
+}
+
+// Pin records that an endpoint is pinned to its last good output: the
+// pipeline doesn't publish it until it is unpinned.
+type Pin struct {
+	// Endpoint is the pinned endpoint, as "<version>/<resource>", e.g.
+	// "1/providers"; see endpoints.Endpoint.String.
+	Endpoint string    `json:"endpoint"`
+	Since    time.Time `json:"since"`
+	By       string    `json:"by"`
+	Reason   string    `json:"reason"`
+}
+
+// pins is the contents of the pins file.
+type pins struct {
+	Pins []Pin `json:"pins"`
+}
+
+// GetPins returns the pinned endpoints, sorted.
+func GetPins(ctx context.Context, sr deploys.StorageReader) ([]Pin, error) {
+	var ps pins
+	if _, err := read(ctx, sr, pinsName, &ps); err != nil {
+		return nil, err
+	}
+	return ps.Pins, nil
+}
+
+// SetPin pins an endpoint, replacing any existing pin of it.
+func SetPin(ctx context.Context, sr deploys.StorageReader, sw deploys.StorageWriter, p Pin) error {
+	ps, err := GetPins(ctx, sr)
+	if err != nil {
+		return err
+	}
+	ps = append(without(ps, p.Endpoint), p)
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Endpoint < ps[j].Endpoint })
+	return write(ctx, sw, pinsName, pins{Pins: ps})
+}
+
+// RemovePin unpins an endpoint; it is an error if it isn't pinned.
+func RemovePin(ctx context.Context, sr deploys.StorageReader, sw deploys.StorageWriter, endpoint string) error {
+	ps, err := GetPins(ctx, sr)
+	if err != nil {
+		return err
+	}
+	kept := without(ps, endpoint)
+	if len(kept) == len(ps) {
+		return fmt.Errorf("%s is not pinned", endpoint)
+	}
+	return write(ctx, sw, pinsName, pins{Pins: kept})
+}
+
+// Pinned returns the pins keyed by endpoint.
+func Pinned(ps []Pin) map[string]Pin {
+	m := make(map[string]Pin, len(ps))
+	for _, p := range ps {
+		m[p.Endpoint] = p
+	}
+	return m
+}
+
+func without(ps []Pin, endpoint string) []Pin {
+	kept := make([]Pin, 0, len(ps))
+	for _, p := range ps {
+		if p.Endpoint != endpoint {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// read reads and decodes a control file, and returns if there was one.
+func read(ctx context.Context, sr deploys.StorageReader, name string, v interface{}) (bool, error) {
+	u, err := url(name)
+	if err != nil {
+		return false, err
+	}
+	data, err := sr(ctx, u+".json")
+	if errors.Is(err, storage.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// write encodes and writes a control file.
+func write(ctx context.Context, sw deploys.StorageWriter, name string, v interface{}) error {
+	u, err := url(name)
+	if err != nil {
+		return err
+	}
+	enc, err := storage.EncodeJSON(ctx, v)
+	if err != nil {
+		return err
+	}
+	if err := sw(ctx, u, enc); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
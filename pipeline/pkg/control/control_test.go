@@ -0,0 +1,124 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+// memStorage keeps files in memory, as the testing deploy's storage.
+type memStorage map[string][]byte
+
+func (m memStorage) store(_ context.Context, destination string, enc storage.Encoded) error {
+	m[destination+enc.Extension] = enc.Data
+	return nil
+}
+
+func (m memStorage) read(_ context.Context, file string) ([]byte, error) {
+	data, ok := m[file]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", file, storage.ErrNotExist)
+	}
+	return data, nil
+}
+
+func (m memStorage) delete(_ context.Context, file string) error {
+	delete(m, file)
+	return nil
+}
+
+func newMemStorage() memStorage {
+	m := memStorage{}
+	deploys.SetTestingStorage(m.store, "testbucket")
+	deploys.SetTestingReader(m.read)
+	deploys.SetTestingDeleter(m.delete)
+	return m
+}
+
+func TestPause(t *testing.T) {
+	m := newMemStorage()
+	ctx := context.Background()
+
+	p, err := GetPause(ctx, m.read)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p != nil {
+		t.Fatalf("paused before SetPause: %+v", p)
+	}
+
+	want := Pause{
+		Since:        time.Date(2026, 10, 18, 15, 30, 0, 0, time.UTC),
+		By:           "someone",
+		Reason:       "bad data",
+		RolledBackTo: "20261018T150012Z",
+	}
+	if err := SetPause(ctx, m.store, want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := m["gs://testbucket/control/pause.json"]; !ok {
+		t.Errorf("pause not written to the control bucket: %v", m)
+	}
+	p, err = GetPause(ctx, m.read)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(&want, p); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+
+	if err := ClearPause(ctx, m.delete); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err = GetPause(ctx, m.read)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p != nil {
+		t.Errorf("paused after ClearPause: %+v", p)
+	}
+}
+
+func TestPins(t *testing.T) {
+	m := newMemStorage()
+	ctx := context.Background()
+
+	pin := func(endpoint, reason string) Pin {
+		return Pin{Endpoint: endpoint, By: "someone", Reason: reason}
+	}
+	for _, p := range []Pin{pin("1/providers", "bad data"), pin("1/counties", "bad data"), pin("1/providers", "still bad")} {
+		if err := SetPin(ctx, m.read, m.store, p); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	got, err := GetPins(ctx, m.read)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Pin{pin("1/counties", "bad data"), pin("1/providers", "still bad")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+	if _, ok := Pinned(got)["1/providers"]; !ok {
+		t.Errorf("Pinned: 1/providers missing")
+	}
+
+	if err := RemovePin(ctx, m.read, m.store, "1/providers"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := RemovePin(ctx, m.read, m.store, "1/providers"); err == nil {
+		t.Errorf("RemovePin of an endpoint which isn't pinned: got no error")
+	}
+	got, err = GetPins(ctx, m.read)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]Pin{pin("1/counties", "bad data")}, got); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
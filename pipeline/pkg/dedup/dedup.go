@@ -0,0 +1,323 @@
+// Package dedup finds, and optionally merges, Locations rows which refer to
+// the same site.  Volunteers sometimes create the same site twice; rows are
+// considered duplicates if they share a google_places_id, a
+// vaccinefinder_location_id, or a normalized address with near-identical
+// coordinates.
+package dedup
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// Policy determines what Apply does with each group of duplicates.
+type Policy string
+
+const (
+	// PolicyReport only reports duplicates; every row is kept.
+	PolicyReport Policy = "report"
+	// PolicyKeep keeps the most complete row of each group, and drops the rest.
+	PolicyKeep Policy = "keep"
+	// PolicyMerge keeps the most complete row of each group, filling in any
+	// fields it is missing from the other rows, and drops the rest.
+	PolicyMerge Policy = "merge"
+)
+
+// ParsePolicy converts a string (e.g. from a flag) into a Policy.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyReport, PolicyKeep, PolicyMerge:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown dedup policy %q", s)
+}
+
+// MaxDistanceMeters is how far apart two rows with the same normalized
+// address may be, and still be considered the same site.
+const MaxDistanceMeters = 50
+
+// Group is a set of rows which were found to be duplicates of each other.
+type Group struct {
+	// IDs are the record ids of the rows in the group; the row which is kept
+	// is always first.
+	IDs []string `json:"ids"`
+	// Names are the "Name" fields of the rows, in the same order as IDs.
+	Names []string `json:"names"`
+	// Reasons lists the keys the rows were matched on, e.g.
+	// "google_places_id:ChIJ...".
+	Reasons []string `json:"reasons"`
+}
+
+// Find returns every group of duplicate rows in the table, in the order their
+// first row appears.  The input is not modified.
+func Find(table types.TableContent) []Group {
+	groups, _ := find(table)
+	return groups
+}
+
+// Apply finds duplicates in the table and resolves them according to the
+// policy.  It returns the resulting table, and the duplicate groups found
+// (which are the same regardless of policy).  The input table and its rows
+// are not modified.
+func Apply(table types.TableContent, policy Policy) (types.TableContent, []Group, error) {
+	if _, err := ParsePolicy(string(policy)); err != nil {
+		return nil, nil, err
+	}
+	groups, members := find(table)
+	if policy == PolicyReport || len(groups) == 0 {
+		return table, groups, nil
+	}
+
+	// Decide, for every row in a group, whether it's the kept row or dropped.
+	drop := make(map[int]bool)
+	replace := make(map[int]map[string]interface{})
+	for _, m := range members {
+		keep := m[0]
+		for _, i := range m[1:] {
+			drop[i] = true
+		}
+		if policy == PolicyMerge {
+			replace[keep] = merge(table, m)
+		}
+	}
+
+	out := make(types.TableContent, 0, len(table)-len(drop))
+	for i, row := range table {
+		if drop[i] {
+			continue
+		}
+		if r, ok := replace[i]; ok {
+			row = r
+		}
+		out = append(out, row)
+	}
+	return out, groups, nil
+}
+
+// match is a set of rows found to be duplicates for a single reason.
+type match struct {
+	// reason is the key the rows were matched on, e.g.
+	// "google_places_id:ChIJ...".
+	reason string
+	rows   []int
+}
+
+// find does the work of Find; it additionally returns the row indexes of each
+// group, with the row to keep first.
+func find(table types.TableContent) ([]Group, [][]int) {
+	uf := newUnionFind(len(table))
+	var matches []match
+
+	// Exact identifier matches; every row with the same identifier is a
+	// duplicate of every other.
+	byID := make(map[string][]int)
+	for i, row := range table {
+		for _, f := range []string{"google_places_id", "vaccinefinder_location_id"} {
+			if v, ok := row[f].(string); ok && strings.TrimSpace(v) != "" {
+				k := f + ":" + strings.TrimSpace(v)
+				byID[k] = append(byID[k], i)
+			}
+		}
+	}
+	for k, rows := range byID {
+		if len(rows) > 1 {
+			matches = append(matches, match{reason: k, rows: rows})
+		}
+	}
+
+	// Address matches; within each normalized address, only rows whose
+	// coordinates are close together count.  Each close pair is a match of
+	// its own, so that two sites far apart which share an address (e.g.
+	// a campus) aren't grouped together just because each has a duplicate.
+	byAddress := make(map[string][]int)
+	for i, row := range table {
+		if a, ok := row["Address"].(string); ok {
+			if na := NormalizeAddress(a); na != "" {
+				byAddress[na] = append(byAddress[na], i)
+			}
+		}
+	}
+	for na, rows := range byAddress {
+		for x := 0; x < len(rows); x++ {
+			for y := x + 1; y < len(rows); y++ {
+				if near(table[rows[x]], table[rows[y]]) {
+					matches = append(matches, match{reason: "address:" + na, rows: []int{rows[x], rows[y]}})
+				}
+			}
+		}
+	}
+
+	for _, m := range matches {
+		for _, r := range m.rows[1:] {
+			uf.union(m.rows[0], r)
+		}
+	}
+
+	// Collect the members of each set with more than one row.
+	sets := make(map[int][]int)
+	for i := range table {
+		root := uf.find(i)
+		sets[root] = append(sets[root], i)
+	}
+	var members [][]int
+	for _, m := range sets {
+		if len(m) > 1 {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(a, b int) bool { return members[a][0] < members[b][0] })
+
+	groups := make([]Group, len(members))
+	for gi, m := range members {
+		// Put the most complete row first; ties go to the earliest row.
+		sort.SliceStable(m, func(a, b int) bool {
+			return populated(table[m[a]]) > populated(table[m[b]])
+		})
+		g := Group{}
+		for _, i := range m {
+			id, _ := table[i]["id"].(string)
+			name, _ := table[i]["Name"].(string)
+			g.IDs = append(g.IDs, id)
+			g.Names = append(g.Names, name)
+		}
+		root := uf.find(m[0])
+		seen := make(map[string]bool)
+		for _, mt := range matches {
+			if uf.find(mt.rows[0]) == root && !seen[mt.reason] {
+				seen[mt.reason] = true
+				g.Reasons = append(g.Reasons, mt.reason)
+			}
+		}
+		sort.Strings(g.Reasons)
+		groups[gi] = g
+	}
+	return groups, members
+}
+
+// merge returns a copy of the first row listed in m, with any missing or
+// empty fields filled in from the later rows, in order.
+func merge(table types.TableContent, m []int) map[string]interface{} {
+	out := make(map[string]interface{}, len(table[m[0]]))
+	for k, v := range table[m[0]] {
+		out[k] = v
+	}
+	for _, i := range m[1:] {
+		for k, v := range table[i] {
+			if isEmpty(out[k]) && !isEmpty(v) {
+				out[k] = v
+			}
+		}
+	}
+	return out
+}
+
+// populated counts the non-empty fields in a row.
+func populated(row map[string]interface{}) int {
+	n := 0
+	for _, v := range row {
+		if !isEmpty(v) {
+			n++
+		}
+	}
+	return n
+}
+
+func isEmpty(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(t) == ""
+	case []interface{}:
+		return len(t) == 0
+	case []string:
+		return len(t) == 0
+	}
+	return false
+}
+
+// near reports if both rows have coordinates, within MaxDistanceMeters of each
+// other.
+func near(a, b map[string]interface{}) bool {
+	alat, ok1 := a["Latitude"].(float64)
+	alng, ok2 := a["Longitude"].(float64)
+	blat, ok3 := b["Latitude"].(float64)
+	blng, ok4 := b["Longitude"].(float64)
+	if !(ok1 && ok2 && ok3 && ok4) {
+		return false
+	}
+	return distanceMeters(alat, alng, blat, blng) <= MaxDistanceMeters
+}
+
+// distanceMeters returns the great-circle distance between two points, using
+// the haversine formula.
+func distanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371000
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLng := rad(lng2 - lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+var (
+	nonAlnum     = regexp.MustCompile(`[^a-z0-9]+`)
+	abbreviation = map[string]string{
+		"street": "st", "avenue": "ave", "boulevard": "blvd", "road": "rd",
+		"drive": "dr", "lane": "ln", "court": "ct", "place": "pl",
+		"highway": "hwy", "parkway": "pkwy", "suite": "ste", "building": "bldg",
+		"north": "n", "south": "s", "east": "e", "west": "w",
+	}
+)
+
+// NormalizeAddress lower-cases an address, strips punctuation, and
+// abbreviates common words, so that trivially different spellings of the
+// same address compare equal.
+func NormalizeAddress(a string) string {
+	words := strings.Fields(nonAlnum.ReplaceAllString(strings.ToLower(a), " "))
+	for i, w := range words {
+		if abbr, ok := abbreviation[w]; ok {
+			words[i] = abbr
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// unionFind is a minimal disjoint-set structure over row indexes.
+type unionFind struct {
+	parent []int
+}
+
+func newUnionFind(n int) *unionFind {
+	uf := &unionFind{parent: make([]int, n)}
+	for i := range uf.parent {
+		uf.parent[i] = i
+	}
+	return uf
+}
+
+func (uf *unionFind) find(i int) int {
+	for uf.parent[i] != i {
+		uf.parent[i] = uf.parent[uf.parent[i]]
+		i = uf.parent[i]
+	}
+	return i
+}
+
+func (uf *unionFind) union(a, b int) {
+	ra, rb := uf.find(a), uf.find(b)
+	if ra == rb {
+		return
+	}
+	// Always root at the lower index, so group order is stable.
+	if rb < ra {
+		ra, rb = rb, ra
+	}
+	uf.parent[rb] = ra
+}
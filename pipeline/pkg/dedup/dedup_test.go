@@ -0,0 +1,208 @@
+package dedup
+
+import (
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// testData returns a table with three groups of duplicates (matched by each
+// of the supported methods), and one unique row.
+func testData() types.TableContent {
+	return types.TableContent{
+		{
+			"id":               "1",
+			"Name":             "Kaiser #568",
+			"google_places_id": "ChIJ-kaiser",
+		},
+		{
+			"id":                "2",
+			"Name":              "Kaiser Permanente Pharmacy #568",
+			"google_places_id":  "ChIJ-kaiser",
+			"Phone number":      "833-480-4700",
+			"Availability Info": []interface{}{"Yes: walk-ins"},
+		},
+		{
+			"id":                        "3",
+			"Name":                      "Rite Aid",
+			"vaccinefinder_location_id": "vf-1",
+			"Address":                   "1 Main Street, Fresno, CA",
+		},
+		{
+			"id":        "4",
+			"Name":      "Dodger Stadium",
+			"Address":   "1000 Vin Scully Avenue, Los Angeles, CA 90012",
+			"Latitude":  34.073851,
+			"Longitude": -118.239958,
+		},
+		{
+			"id":                        "5",
+			"Name":                      "Rite Aid #2",
+			"vaccinefinder_location_id": "vf-1",
+			"Phone number":              "559-555-0100",
+		},
+		{
+			"id":        "6",
+			"Name":      "Dodger Stadium (dupe)",
+			"Address":   "1000 Vin Scully Ave., Los Angeles CA 90012",
+			"Latitude":  34.07390,
+			"Longitude": -118.23990,
+			"Notes":     "",
+		},
+		{
+			"id":        "7",
+			"Name":      "Same address, but across town",
+			"Address":   "1000 Vin Scully Ave, Los Angeles, CA 90012",
+			"Latitude":  34.1,
+			"Longitude": -118.3,
+		},
+		{
+			"id":               "8",
+			"Name":             "Unique",
+			"google_places_id": "",
+		},
+	}
+}
+
+func TestFind(t *testing.T) {
+	want := []Group{
+		{
+			IDs:     []string{"2", "1"},
+			Names:   []string{"Kaiser Permanente Pharmacy #568", "Kaiser #568"},
+			Reasons: []string{"google_places_id:ChIJ-kaiser"},
+		},
+		{
+			IDs:     []string{"3", "5"},
+			Names:   []string{"Rite Aid", "Rite Aid #2"},
+			Reasons: []string{"vaccinefinder_location_id:vf-1"},
+		},
+		{
+			IDs:     []string{"4", "6"},
+			Names:   []string{"Dodger Stadium", "Dodger Stadium (dupe)"},
+			Reasons: []string{"address:1000 vin scully ave los angeles ca 90012"},
+		},
+	}
+	in := testData()
+	got := Find(in)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+	if diff := cmp.Diff(testData(), in); diff != "" {
+		t.Errorf("expected input unmodified: -want +got:\n%v\n", diff)
+	}
+}
+
+// TestFind_SeparatePairs checks that two pairs of duplicates at the same
+// address, but far from each other, are two groups rather than one.
+func TestFind_SeparatePairs(t *testing.T) {
+	in := types.TableContent{
+		{"id": "1", "Name": "North gate", "Address": "1 Campus Drive", "Latitude": 34.0, "Longitude": -118.0},
+		{"id": "2", "Name": "South gate", "Address": "1 Campus Dr", "Latitude": 34.1, "Longitude": -118.1},
+		{"id": "3", "Name": "North gate (dupe)", "Address": "1 Campus Dr.", "Latitude": 34.0001, "Longitude": -118.0},
+		{"id": "4", "Name": "South gate (dupe)", "Address": "1 campus drive", "Latitude": 34.1, "Longitude": -118.1001},
+	}
+	want := []Group{
+		{
+			IDs:     []string{"1", "3"},
+			Names:   []string{"North gate", "North gate (dupe)"},
+			Reasons: []string{"address:1 campus dr"},
+		},
+		{
+			IDs:     []string{"2", "4"},
+			Names:   []string{"South gate", "South gate (dupe)"},
+			Reasons: []string{"address:1 campus dr"},
+		},
+	}
+	if diff := cmp.Diff(want, Find(in)); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
+
+func TestApply(t *testing.T) {
+	ids := func(tc types.TableContent) []string {
+		var out []string
+		for _, r := range tc {
+			out = append(out, r["id"].(string))
+		}
+		return out
+	}
+
+	tests := []struct {
+		desc    string
+		policy  Policy
+		wantIDs []string
+		wantErr bool
+	}{
+		{desc: "report", policy: PolicyReport, wantIDs: []string{"1", "2", "3", "4", "5", "6", "7", "8"}},
+		{desc: "keep", policy: PolicyKeep, wantIDs: []string{"2", "3", "4", "7", "8"}},
+		{desc: "merge", policy: PolicyMerge, wantIDs: []string{"2", "3", "4", "7", "8"}},
+		{desc: "bogus", policy: Policy("bogus"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			in := testData()
+			got, groups, err := Apply(in, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if len(groups) != 3 {
+				t.Errorf("got %d groups, want 3", len(groups))
+			}
+			if diff := cmp.Diff(tt.wantIDs, ids(got)); diff != "" {
+				t.Errorf("-want +got:\n%v\n", diff)
+			}
+			if diff := cmp.Diff(testData(), in); diff != "" {
+				t.Errorf("expected input unmodified: -want +got:\n%v\n", diff)
+			}
+		})
+	}
+}
+
+func TestApply_Merge(t *testing.T) {
+	got, _, err := Apply(testData(), PolicyMerge)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Rite Aid's rows are equally complete, so the first is kept; it gains the
+	// phone number from its duplicate, but keeps its own name.
+	want := map[string]interface{}{
+		"id":                        "3",
+		"Name":                      "Rite Aid",
+		"vaccinefinder_location_id": "vf-1",
+		"Address":                   "1 Main Street, Fresno, CA",
+		"Phone number":              "559-555-0100",
+	}
+	if diff := cmp.Diff(want, got[1]); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := map[string]string{
+		"1000 Vin Scully Avenue, Los Angeles, CA 90012": "1000 vin scully ave los angeles ca 90012",
+		"1000 Vin Scully Ave.,  Los Angeles CA 90012":   "1000 vin scully ave los angeles ca 90012",
+		"12761 Schabarum Ave Plaza Level RM 1100":       "12761 schabarum ave plaza level rm 1100",
+		"  ": "",
+	}
+	for in, want := range tests {
+		if got := NormalizeAddress(in); got != want {
+			t.Errorf("NormalizeAddress(%q): got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{PolicyReport, PolicyKeep, PolicyMerge} {
+		got, err := ParsePolicy(string(p))
+		if err != nil || got != p {
+			t.Errorf("ParsePolicy(%q): got %q, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePolicy("sometimes"); err == nil {
+		t.Errorf("ParsePolicy(sometimes): want error, got nil")
+	}
+}
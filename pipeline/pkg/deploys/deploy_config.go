@@ -3,12 +3,24 @@ package deploys
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
 )
 
-// A function which can be used to output the transformed data; see pkg/storage/
-type StorageWriter func(ctx context.Context, destinationFile string, transformedData metadata.JSONData) error
+// A function which can be used to output the encoded data; see pkg/storage/.
+// The destination has no file extension; the writer adds the encoding's.
+type StorageWriter func(ctx context.Context, destination string, enc storage.Encoded) error
+
+// StorageReader reads back a file written by the deploy's StorageWriter,
+// given its full path, including the extension.  It returns an error
+// wrapping storage.ErrNotExist if there is no such file.
+type StorageReader func(ctx context.Context, file string) ([]byte, error)
+
+// StorageDeleter deletes a file written by the deploy's StorageWriter,
+// given its full path, including the extension.  It is not an error if
+// there is no such file.
+type StorageDeleter func(ctx context.Context, file string) error
 
 type Bucket struct {
 	Name     string
@@ -41,9 +53,18 @@ func (b *Bucket) GetDownloadURL() string {
 
 // Pair of legacy bucket, and new bucket hooked up to a CDN
 type DeployConfig struct {
+	// Compression is which encodings Storage stores each file in.
+	Compression  storage.Compression
 	Storage      StorageWriter
+	Reader       StorageReader
+	Deleter      StorageDeleter
 	LegacyBucket Bucket
 	APIBucket    Bucket
+	// ControlBucket holds the operator controls over publishing; unlike
+	// the others, it must not be public.
+	ControlBucket Bucket
+	// ArchiveRetention is how long snapshots in the archive are kept.
+	ArchiveRetention time.Duration
 }
 
 // Returns the gs:// URL that files in the bucket can be uploaded to,
@@ -1,8 +1,10 @@
 package deploys
 
 import (
+	"compress/gzip"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
 )
@@ -23,6 +25,26 @@ const (
 	DeployUnknown    DeployType = ""
 )
 
+// servedCompression is how deployed files are stored: gzip'd at their own
+// path, which GCS serves decompressed to clients which don't accept gzip,
+// with a brotli variant alongside.  Brotli's level is below its best, which
+// is many times slower for little gain on files of this size.
+var servedCompression = storage.Compression{
+	Encodings: []storage.Encoding{storage.Gzip, storage.Brotli},
+	Levels: map[storage.Encoding]int{
+		storage.Gzip:   gzip.BestCompression,
+		storage.Brotli: 9,
+	},
+}
+
+// localCompression is how local files are stored: uncompressed at their own
+// path, so that they can be read as they are, with the variants which are
+// served alongside.
+var localCompression = storage.Compression{
+	Encodings: []storage.Encoding{storage.Identity, storage.Gzip, storage.Brotli},
+	Levels:    servedCompression.Levels,
+}
+
 // Describes which deploys go where; in the legacy version, they're in
 // the same bucket but separate directories; in the non-legacy
 // version, they're in the top level of separate buckets, at separate
@@ -31,7 +53,10 @@ var deploys = map[DeployType]*DeployConfig{
 	DeployTesting: {
 		// The bucket name here used for the name of the local
 		// directory to write into.
-		Storage: storage.StoreLocal,
+		Compression: localCompression,
+		Storage:     storage.NewLocalWriter(localCompression),
+		Reader:      storage.ReadLocal,
+		Deleter:     storage.DeleteLocal,
 		LegacyBucket: Bucket{
 			Name: "local",
 			Path: "legacy",
@@ -40,9 +65,17 @@ var deploys = map[DeployType]*DeployConfig{
 			Name: "local",
 			Path: "api",
 		},
+		ControlBucket: Bucket{
+			Name: "local",
+			Path: "control",
+		},
+		ArchiveRetention: 2 * 24 * time.Hour,
 	},
 	DeployStaging: {
-		Storage: storage.UploadToGCS,
+		Compression: servedCompression,
+		Storage:     storage.NewGCSWriter(servedCompression),
+		Reader:      storage.ReadFromGCS,
+		Deleter:     storage.DeleteFromGCS,
 		LegacyBucket: Bucket{
 			Name: "cavaccineinventory-sitedata",
 			Path: "airtable-sync-staging",
@@ -51,9 +84,16 @@ var deploys = map[DeployType]*DeployConfig{
 			Name:     "vaccinateca-api-staging",
 			HostedAt: "staging-api.vaccinateca.com",
 		},
+		ControlBucket: Bucket{
+			Name: "vaccinateca-control-staging",
+		},
+		ArchiveRetention: 7 * 24 * time.Hour,
 	},
 	DeployProduction: {
-		Storage: storage.UploadToGCS,
+		Compression: servedCompression,
+		Storage:     storage.NewGCSWriter(servedCompression),
+		Reader:      storage.ReadFromGCS,
+		Deleter:     storage.DeleteFromGCS,
 		LegacyBucket: Bucket{
 			Name: "cavaccineinventory-sitedata",
 			Path: "airtable-sync",
@@ -62,6 +102,10 @@ var deploys = map[DeployType]*DeployConfig{
 			Name:     "vaccinateca-api",
 			HostedAt: "api.vaccinateca.com",
 		},
+		ControlBucket: Bucket{
+			Name: "vaccinateca-control",
+		},
+		ArchiveRetention: 30 * 24 * time.Hour,
 	},
 }
 
@@ -95,10 +139,68 @@ func GetStorage() (StorageWriter, error) {
 	return config.Storage, nil
 }
 
+// GetReader returns the StorageReader for the deploy's storage.
+func GetReader() (StorageReader, error) {
+	config, err := getDeployConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Reader, nil
+}
+
+// GetDeleter returns the StorageDeleter for the deploy's storage.
+func GetDeleter() (StorageDeleter, error) {
+	config, err := getDeployConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Deleter, nil
+}
+
+// GetArchiveRetention returns how long the deploy keeps snapshots in the
+// archive.
+func GetArchiveRetention() (time.Duration, error) {
+	config, err := getDeployConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.ArchiveRetention, nil
+}
+
+// SetTestingDeleter sets the StorageDeleter of the testing deploy.
+func SetTestingDeleter(sd StorageDeleter) {
+	deploys[DeployTesting].Deleter = sd
+}
+
+// SetTestingReader sets the StorageReader of the testing deploy, to read
+// back what was written with the StorageWriter set by SetTestingStorage.
+func SetTestingReader(sr StorageReader) {
+	deploys[DeployTesting].Reader = sr
+}
+
 func SetTestingStorage(sw StorageWriter, bucketName string) {
 	deploys[DeployTesting].Storage = sw
 	deploys[DeployTesting].LegacyBucket.Name = bucketName
 	deploys[DeployTesting].APIBucket.Name = bucketName
+	deploys[DeployTesting].ControlBucket.Name = bucketName
+}
+
+// UseBucket makes the deploy store every file in the named GCS bucket,
+// instead of its own buckets, e.g. to try out a change without touching
+// the deploy's data.  Each kind of file keeps its path in the bucket; as the
+// controls are in the same bucket, it should not be public.
+func UseBucket(name string) error {
+	config, err := getDeployConfig()
+	if err != nil {
+		return err
+	}
+	config.Storage = storage.NewGCSWriter(config.Compression)
+	config.Reader = storage.ReadFromGCS
+	config.Deleter = storage.DeleteFromGCS
+	config.LegacyBucket = Bucket{Name: name, Path: config.LegacyBucket.Path}
+	config.APIBucket = Bucket{Name: name, Path: config.APIBucket.Path}
+	config.ControlBucket = Bucket{Name: name, Path: "control"}
+	return nil
 }
 
 // Returns the gs:// URL that files in the bucket can be uploaded to,
@@ -120,3 +222,33 @@ func GetDownloadURL(version VersionType) (string, error) {
 	}
 	return config.GetDownloadURL(version), nil
 }
+
+// Returns the gs:// URL of the root of the API bucket, which holds
+// files describing every non-legacy version; never ends with a `/`.
+func GetAPIUploadURL() (string, error) {
+	config, err := getDeployConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.APIBucket.GetUploadURL(), nil
+}
+
+// Returns the https:// URL of the root of the API bucket; never ends
+// with a `/`.
+func GetAPIDownloadURL() (string, error) {
+	config, err := getDeployConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.APIBucket.GetDownloadURL(), nil
+}
+
+// Returns the gs:// URL of the root of the control bucket, which holds the
+// operator controls over publishing; never ends with a `/`.
+func GetControlUploadURL() (string, error) {
+	config, err := getDeployConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.ControlBucket.GetUploadURL(), nil
+}
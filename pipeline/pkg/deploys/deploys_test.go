@@ -97,7 +97,7 @@ func TestGetStorage(t *testing.T) {
 		{
 			desc:    "get testing storage",
 			deploy:  string(DeployTesting),
-			want:    storage.StoreLocal,
+			want:    storage.NewLocalWriter(localCompression),
 			wantErr: false,
 		},
 		{
@@ -147,4 +147,94 @@ func TestSetTestingStorage(t *testing.T) {
 	if deploys[DeployTesting].APIBucket.Name != tbn {
 		t.Errorf("APIBucket.Name: got %v, want %v", deploys[DeployTesting].APIBucket.Name, tbn)
 	}
+	if deploys[DeployTesting].ControlBucket.Name != tbn {
+		t.Errorf("ControlBucket.Name: got %v, want %v", deploys[DeployTesting].ControlBucket.Name, tbn)
+	}
+}
+
+func TestUseBucket(t *testing.T) {
+	t.Cleanup(func() {
+		os.Unsetenv("DEPLOY")
+	})
+	for _, deploy := range []DeployType{DeployTesting, DeployStaging, DeployProduction} {
+		t.Run(string(deploy), func(t *testing.T) {
+			orig := *deploys[deploy]
+			t.Cleanup(func() { *deploys[deploy] = orig })
+			os.Setenv("DEPLOY", string(deploy))
+
+			if err := UseBucket("scratch"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			config := deploys[deploy]
+			for name, b := range map[string]Bucket{"legacy": config.LegacyBucket, "API": config.APIBucket, "control": config.ControlBucket} {
+				if b.Name != "scratch" || b.HostedAt != "" {
+					t.Errorf("%s bucket: got %+v, want it in the scratch bucket", name, b)
+				}
+			}
+			if got, want := config.LegacyBucket.Path, orig.LegacyBucket.Path; got != want {
+				t.Errorf("legacy path: got %q, want %q", got, want)
+			}
+			if got, want := config.APIBucket.GetUploadURL(), "gs://scratch"+strings.TrimPrefix(orig.APIBucket.GetUploadURL(), "gs://"+orig.APIBucket.Name); got != want {
+				t.Errorf("API upload URL: got %q, want %q", got, want)
+			}
+			if got, want := config.ControlBucket.GetUploadURL(), "gs://scratch/control"; got != want {
+				t.Errorf("control upload URL: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAPIRootURLs(t *testing.T) {
+	t.Cleanup(func() {
+		os.Unsetenv("DEPLOY")
+	})
+	tests := map[string]struct {
+		upload, download string
+		wantErr          bool
+	}{
+		"prod":    {upload: "gs://vaccinateca-api", download: "https://api.vaccinateca.com"},
+		"staging": {upload: "gs://vaccinateca-api-staging", download: "https://staging-api.vaccinateca.com"},
+		"error":   {wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			os.Setenv("DEPLOY", name)
+			upload, err := GetAPIUploadURL()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error from GetAPIUploadURL: %v", err)
+			}
+			download, err := GetAPIDownloadURL()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error from GetAPIDownloadURL: %v", err)
+			}
+			if upload != tc.upload || download != tc.download {
+				t.Errorf("got %q, %q; want %q, %q", upload, download, tc.upload, tc.download)
+			}
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for deploy, config := range deploys {
+		if err := config.Compression.Validate(); err != nil {
+			t.Errorf("%s: invalid compression: %v", deploy, err)
+		}
+	}
+	// Local files can be read as they are.
+	if got := deploys[DeployTesting].Compression.Encodings[0]; got != storage.Identity {
+		t.Errorf("testing: primary encoding is %q, want %q", got, storage.Identity)
+	}
+}
+
+func TestControlBucket(t *testing.T) {
+	// The controls name who set them, so must not be in a public bucket.
+	for _, deploy := range []DeployType{DeployStaging, DeployProduction} {
+		c := deploys[deploy].ControlBucket
+		if c.Name == deploys[deploy].APIBucket.Name || c.Name == deploys[deploy].LegacyBucket.Name {
+			t.Errorf("%s: control bucket %q is a public bucket", deploy, c.Name)
+		}
+		if c.HostedAt != "" {
+			t.Errorf("%s: control bucket is hosted at %q", deploy, c.HostedAt)
+		}
+	}
 }
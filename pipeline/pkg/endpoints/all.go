@@ -6,14 +6,38 @@ import (
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/legacy"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/locations"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/providers"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/geojson"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/protobuf"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/schema"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
 )
 
+// tableFormats are the formats every endpoint is published in; the CSV is
+// mostly opened in spreadsheet programs, so has a BOM for Excel.
+var tableFormats = []string{storage.FormatJSON, storage.FormatCSVBOM, schema.FormatName}
+
+// v1Formats are the formats every v1 endpoint is published in: those of
+// every endpoint, and protobuf, with its .proto definition.
+var v1Formats = []string{
+	storage.FormatJSON, storage.FormatCSVBOM, schema.FormatName,
+	protobuf.FormatName, protobuf.DefinitionFormatName,
+}
+
+// Versions holds the settings of each API version which doesn't use the
+// defaults.  Versions which existed before the metadata block don't opt in
+// to it, so as not to change the output under their consumers; new versions
+// should.
+var Versions = map[deploys.VersionType]VersionOptions{}
+
 // EndpointMap is a map of an API version, to all endpoints in that version.
 //
 // You can add new endpoints to a version, add fields to an endpoint
 // in that version, tweak endpoint generation, etc.  IF YOU
 // REMOVE/RENAME A FIELD OR CHANGE PROGRAMMATIC SEMANTICS, YOU NEED TO
-// CREATE A NEW API VERSION.
+// CREATE A NEW API VERSION.  The names and types of every version's
+// fields are recorded in versions.lock.json, and the tests fail if a
+// change breaks them; record new fields with `go run
+// ./pipeline/cmd/contract -update`.
 //
 // When creating an new API version, copy ALL endpoint definitions
 // that should still exist into the new version, even if you are not
@@ -21,14 +45,43 @@ import (
 // versions, if that endpoint is unchanged.  E.G. if changing the
 // locations API in a breaking fashion, keep using the
 // GenerateV1Counties func for v2, v3, etc.
-var EndpointMap = map[deploys.VersionType]map[string]endpointFunc{
+var EndpointMap = map[deploys.VersionType]map[string]Definition{
 	deploys.LegacyVersion: {
-		"Locations": legacy.Locations,
-		"Counties":  legacy.Counties,
+		"Locations": {
+			Transform: legacy.Locations,
+			Table:     "Locations",
+			Fields:    sameNames(legacy.LocationsFields),
+			Formats:   tableFormats,
+		},
+		"Counties": {
+			Transform: legacy.Counties,
+			Table:     "Counties",
+			Fields:    sameNames(legacy.CountiesFields),
+			Formats:   tableFormats,
+		},
 	},
 	deploys.VersionType("1"): {
-		"locations": locations.V1,
-		"counties":  counties.V1,
-		"providers": providers.V1,
+		"locations": {
+			Transform: locations.V1,
+			Table:     "Locations",
+			Fields: append(sameNames(locations.V1LocationFields),
+				linked("Counties", locations.CountyLink, locations.V1CountyFields)...),
+			Formats:  append([]string{geojson.FormatName}, v1Formats...),
+			ByCounty: true,
+			Tiles:    true,
+			Feed:     true,
+		},
+		"counties": {
+			Transform: counties.V1,
+			Table:     "Counties",
+			Fields:    sameNames(legacy.CountiesFields),
+			Formats:   v1Formats,
+		},
+		"providers": {
+			Transform: providers.V1,
+			Table:     "Provider networks",
+			Fields:    sameNames(providers.V1Fields),
+			Formats:   v1Formats,
+		},
 	},
 }
@@ -3,26 +3,179 @@ package endpoints
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/filter"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
 )
 
 type endpointFunc func(context.Context, *airtable.Tables) (types.TableContent, error)
 
+// Field is a single published field of an endpoint.
+type Field struct {
+	// Column is the Airtable column the field's value comes from.
+	Column string
+	// Name is the field's name in the output.
+	Name string
+	// Type is the type of the field's values.
+	Type types.FieldType
+	// Table is set for fields copied from linked records, as with
+	// filter.WithLinkedFields; it is the Airtable table Column is in, and
+	// Link is the column of the endpoint's own table which links to it.
+	Table string
+	Link  string
+}
+
+// Definition describes how an endpoint is generated: the transform, and the
+// Airtable table and columns it publishes.  The table and fields must match
+// what the transform does; they're used to describe the endpoint, e.g. in
+// the lineage report.
+type Definition struct {
+	Transform endpointFunc
+	// Table is the Airtable table the endpoint is generated from.
+	Table string
+	// Fields are the fields the endpoint publishes, in order.  The "id"
+	// field, which every endpoint has, is not included.  New fields must be
+	// added at the end, since a field's position gives its number in the
+	// protobuf format.
+	Fields []Field
+	// Formats are the names of the storage formats the endpoint is
+	// published in; see storage.RegisterFormat.  Endpoints published as
+	// geojson.FormatName must publish "Latitude" and "Longitude".
+	Formats []string
+	// ByCounty is set for endpoints of locations which are also published
+	// split by county, as <resource>/by-county/<slug>.json, with an index
+	// at <resource>/by-county/index.json.
+	ByCounty bool
+	// Tiles is set for the endpoint of locations which is also published
+	// split into map tiles, as tiles/{z}/{x}/{y}.json in the version's
+	// directory, with an index at tiles/index.json; at most one endpoint
+	// per version may set it.  It must publish "Latitude" and "Longitude".
+	Tiles bool
+	// Feed is set for endpoints of locations which are also published as
+	// an Atom feed of the locations whose latest report found vaccine
+	// available, as <resource>.atom, and, if ByCounty is set, per county,
+	// as <resource>/by-county/<slug>.atom.
+	Feed bool
+	// RowFilter, if set, is a filter.RowFilter expression; only the rows
+	// of the transform's output which match it are published.  It may only
+	// refer to the endpoint's Fields, and "id"; see Validate.
+	RowFilter string
+}
+
+// VersionOptions are the settings of an API version as a whole.
+type VersionOptions struct {
+	// Metadata is set for versions whose JSON output carries a metadata
+	// block; see metadata.Metadata.  It has no effect on the legacy
+	// version, which is published as bare lists.
+	Metadata bool
+	// Deprecation, if set, is published in the metadata block of the
+	// version's responses.
+	Deprecation string
+}
+
+// sameNames returns the Fields for a list of columns which are published
+// without being renamed, as with filter.WithFieldSlice.
+func sameNames(columns types.FieldList) []Field {
+	fields := make([]Field, len(columns))
+	for i, c := range columns {
+		fields[i] = Field{Column: c.Name, Name: c.Name, Type: c.Type}
+	}
+	return fields
+}
+
+// linked returns the Fields for a list of columns which are copied, without
+// being renamed, from the table records linked by the link column, as with
+// filter.WithLinkedFields.
+func linked(table, link string, columns types.FieldList) []Field {
+	fields := sameNames(columns)
+	for i := range fields {
+		fields[i].Table = table
+		fields[i].Link = link
+	}
+	return fields
+}
+
 // Endpoint stores the data transform for a given version and resource
 // path.
 type Endpoint struct {
 	Version   deploys.VersionType
 	Resource  string
 	Transform endpointFunc
+	// Table, Fields, Formats, ByCounty, Tiles, Feed and RowFilter are as
+	// in the endpoint's Definition; Transform already applies RowFilter.
+	Table     string
+	Fields    []Field
+	Formats   []string
+	ByCounty  bool
+	Tiles     bool
+	Feed      bool
+	RowFilter string
+	// Options are the settings of the endpoint's version, from Versions.
+	Options VersionOptions
 }
 
 func (ep *Endpoint) String() string {
 	return fmt.Sprintf("%s/%s", ep.Version, ep.Resource)
 }
 
+// Columns returns the names of the endpoint's output fields, in order,
+// starting with "id".
+func (ep *Endpoint) Columns() []string {
+	columns := make([]string, 0, len(ep.Fields)+1)
+	columns = append(columns, "id")
+	for _, f := range ep.Fields {
+		columns = append(columns, f.Name)
+	}
+	return columns
+}
+
+// Types returns the types of the endpoint's output fields, by name,
+// including "id".
+func (ep *Endpoint) Types() map[string]types.FieldType {
+	ts := make(map[string]types.FieldType, len(ep.Fields)+1)
+	ts["id"] = types.String
+	for _, f := range ep.Fields {
+		ts[f.Name] = f.Type
+	}
+	return ts
+}
+
+// Payload returns what the endpoint publishes for the given table, to be
+// encoded in each of its formats.  Legacy endpoints are bare lists;
+// everything newer is wrapped with the usage notice, and, if the version
+// opts in to it, metadata about the run and the table's snapshot of
+// Airtable.
+func (ep *Endpoint) Payload(table types.TableContent, run metadata.Run, snapshot time.Time) storage.Payload {
+	var data metadata.JSONData = table
+	if ep.Version != deploys.LegacyVersion {
+		var md *metadata.Metadata
+		if ep.Options.Metadata {
+			md = &metadata.Metadata{
+				Generated:      run.Generated,
+				SourceSnapshot: snapshot,
+				Commit:         run.Commit,
+				RunID:          run.ID,
+				Records:        len(table),
+				APIVersion:     string(ep.Version),
+				Deprecation:    ep.Options.Deprecation,
+			}
+		}
+		data = metadata.WrapWithMetadata(table, md)
+	}
+	return storage.Payload{
+		Name:    ep.String(),
+		Data:    data,
+		Table:   table,
+		Columns: ep.Columns(),
+		Types:   ep.Types(),
+	}
+}
+
 // The download URL of the endpoint, based on the deploy, version, and resource.
 func (ep *Endpoint) URL() (string, error) {
 	baseURL, err := deploys.GetDownloadURL(ep.Version)
@@ -42,11 +195,22 @@ func AllEndpoints() []Endpoint {
 	endpoints := make([]Endpoint, totalSize)
 	i := 0
 	for version, versionResources := range EndpointMap {
-		for resource, transform := range versionResources {
+		for resource, def := range versionResources {
 			endpoints[i] = Endpoint{
 				Version:   version,
 				Resource:  resource,
-				Transform: transform,
+				Transform: def.Transform,
+				Table:     def.Table,
+				Fields:    def.Fields,
+				Formats:   def.Formats,
+				ByCounty:  def.ByCounty,
+				Tiles:     def.Tiles,
+				Feed:      def.Feed,
+				RowFilter: def.RowFilter,
+				Options:   Versions[version],
+			}
+			if def.RowFilter != "" {
+				endpoints[i].Transform = withRowFilter(def.Transform, def.RowFilter)
 			}
 			i++
 		}
@@ -54,6 +218,42 @@ func AllEndpoints() []Endpoint {
 	return endpoints
 }
 
+// withRowFilter returns a transform which only keeps the rows of the
+// transform's output which match the row filter expression.
+func withRowFilter(transform endpointFunc, expr string) endpointFunc {
+	return func(ctx context.Context, tables *airtable.Tables) (types.TableContent, error) {
+		table, err := transform(ctx, tables)
+		if err != nil {
+			return nil, err
+		}
+		return filter.Transform(table, filter.WithRowFilter(expr))
+	}
+}
+
+// Validate returns an error if the endpoint's definition can't be used:
+// if its RowFilter doesn't parse, or refers to a field which the endpoint
+// doesn't publish.
+func (ep *Endpoint) Validate() error {
+	if ep.RowFilter == "" {
+		return nil
+	}
+	if err := filter.ValidateRowFilter(ep.RowFilter, ep.Columns()); err != nil {
+		return fmt.Errorf("%s: %w", ep, err)
+	}
+	return nil
+}
+
+// Validate checks the definition of every endpoint; it is called at
+// startup, so that mistakes are found before the first publish.
+func Validate() error {
+	for _, ep := range AllEndpoints() {
+		if err := ep.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Maps the URL() method over AllEndpoints.
 func EndpointURLs() ([]string, error) {
 	eps := AllEndpoints()
@@ -4,14 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/atom"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/legacy"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/filter"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/geojson"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,37 +46,46 @@ func (sf *stubFetchFromFile) Download(ctx context.Context, _ string) (types.Tabl
 	return o, nil
 }
 
+// fetcher is the interface of the Tables fetchers used by these tests.
+type fetcher interface {
+	Download(context.Context, string) (types.TableContent, error)
+}
+
 func TestSanitize(t *testing.T) {
 	tests := map[string]struct {
-		endpointFunc endpointFunc
+		def          Definition
 		testDataFile string
+		// linkedFiles hold the tables whose records the endpoint's
+		// table links to, by table name.
+		linkedFiles  map[string]string
 		badKeys      []string // fields no record must have
 		requiredKeys []string // fields every record must have
 	}{
 		"Locations": {
-			endpointFunc: EndpointMap[deploys.LegacyVersion]["Locations"],
+			def:          EndpointMap[deploys.LegacyVersion]["Locations"],
 			testDataFile: "test_data/locations_reduced.json",
 			badKeys:      []string{"Last report author", "Internal notes"},
 			requiredKeys: []string{"Name"},
 		},
 		"Counties": {
-			endpointFunc: EndpointMap[deploys.LegacyVersion]["Counties"],
+			def:          EndpointMap[deploys.LegacyVersion]["Counties"],
 			testDataFile: "test_data/counties.json",
 			badKeys:      []string{"Internal notes"},
 		},
 		"Locations-V1": {
-			endpointFunc: EndpointMap[deploys.VersionType("1")]["locations"],
+			def:          EndpointMap[deploys.VersionType("1")]["locations"],
 			testDataFile: "test_data/locations_reduced.json",
+			linkedFiles:  map[string]string{"Counties": linkedCountiesFile},
 			badKeys:      []string{"Last report author", "Internal notes"},
 			requiredKeys: []string{"Name"},
 		},
 		"Counties-V1": {
-			endpointFunc: EndpointMap[deploys.VersionType("1")]["counties"],
+			def:          EndpointMap[deploys.VersionType("1")]["counties"],
 			testDataFile: "test_data/counties.json",
 			badKeys:      []string{"Internal notes"},
 		},
 		"Providers-V1": {
-			endpointFunc: EndpointMap[deploys.VersionType("1")]["providers"],
+			def:          EndpointMap[deploys.VersionType("1")]["providers"],
 			testDataFile: "test_data/providers.json",
 			badKeys:      []string{"airtable_id"},
 		},
@@ -80,23 +97,41 @@ func TestSanitize(t *testing.T) {
 			// "id" is always required
 			tc.requiredKeys = append(tc.requiredKeys, "id")
 
-			f := &stubFetchFromFile{
+			var f fetcher = &stubFetchFromFile{
 				name:     name,
 				dataFile: tc.testDataFile,
 			}
+			if tc.linkedFiles != nil {
+				// Links are by record ID, so the IDs must be the
+				// saved ones rather than synthetic.
+				files := stubFetchFromFiles{tc.def.Table: tc.testDataFile}
+				for table, file := range tc.linkedFiles {
+					files[table] = file
+				}
+				f = files
+			}
 
 			fakeTables := airtable.NewFakeTables(ctx, f)
-			out, err := tc.endpointFunc(ctx, fakeTables)
+			out, err := tc.def.Transform(ctx, fakeTables)
 			require.NoError(t, err)
 
 			got, err := storage.Serialize(out)
 			require.NoError(t, err)
 
+			// The storage writers would refuse to write this.
+			require.NoError(t, storage.DefaultDenylist.Check(out))
+
 			//  Basic sanity check
 			if bytes.Contains(got.Bytes(), []byte("@gmail.com")) {
 				t.Errorf("result contains @gmail.com")
 			}
 
+			// The definition describes every field.
+			defined := map[string]bool{"id": true}
+			for _, f := range tc.def.Fields {
+				defined[f.Name] = true
+			}
+
 			locs := make(types.TableContent, 0)
 			err = json.Unmarshal(got.Bytes(), &locs)
 			require.NoError(t, err)
@@ -108,6 +143,11 @@ func TestSanitize(t *testing.T) {
 						t.Errorf("bad key %q found in row %d", k, i)
 					}
 				}
+				for k := range l {
+					if !defined[k] {
+						t.Errorf("key %q in row %d is not in the endpoint's definition", k, i)
+					}
+				}
 				// Check for required keys.
 				for _, k := range tc.requiredKeys {
 					if _, ok := l[k]; !ok {
@@ -210,3 +250,150 @@ func TestEndPointAccessors(t *testing.T) {
 		})
 	}
 }
+
+func TestFormats(t *testing.T) {
+	tiled := map[deploys.VersionType]string{}
+	for _, ep := range AllEndpoints() {
+		if len(ep.Formats) == 0 {
+			t.Errorf("%s has no formats", &ep)
+		}
+		names := map[string]bool{}
+		for _, f := range ep.Fields {
+			names[f.Name] = true
+			if f.Type == "" {
+				t.Errorf("%s: field %q has no type", &ep, f.Name)
+			}
+		}
+		for _, format := range ep.Formats {
+			if _, err := storage.LookupFormat(format); err != nil {
+				t.Errorf("%s: %v", &ep, err)
+			}
+			if format == geojson.FormatName && (!names["Latitude"] || !names["Longitude"]) {
+				t.Errorf("%s is published as GeoJSON, but doesn't publish Latitude and Longitude", &ep)
+			}
+		}
+		if ep.Feed {
+			for _, f := range []string{atom.NameField, atom.ReportField, atom.ReportYesField} {
+				if !names[f] {
+					t.Errorf("%s is published as a feed, but doesn't publish %q", &ep, f)
+				}
+			}
+		}
+		if ep.Tiles {
+			if !names["Latitude"] || !names["Longitude"] {
+				t.Errorf("%s is published as tiles, but doesn't publish Latitude and Longitude", &ep)
+			}
+			if other, ok := tiled[ep.Version]; ok {
+				t.Errorf("%s and %s are both published as tiles, to the same path", other, &ep)
+			}
+			tiled[ep.Version] = ep.String()
+		}
+	}
+}
+
+func TestPayloadMetadata(t *testing.T) {
+	table := types.TableContent{{"id": "rec1"}, {"id": "rec2"}}
+	run := metadata.Run{
+		ID:        "20210301T120000Z",
+		Commit:    "abc123",
+		Generated: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	snapshot := time.Date(2021, 3, 1, 11, 59, 30, 0, time.UTC)
+
+	tests := []struct {
+		desc    string
+		ep      Endpoint
+		wantMD  *metadata.Metadata
+		wantRaw bool
+	}{
+		{
+			desc:    "legacy is bare, even if opted in",
+			ep:      Endpoint{Version: deploys.LegacyVersion, Resource: "Locations", Options: VersionOptions{Metadata: true}},
+			wantRaw: true,
+		},
+		{
+			desc: "not opted in",
+			ep:   Endpoint{Version: "1", Resource: "locations"},
+		},
+		{
+			desc: "opted in",
+			ep:   Endpoint{Version: "2", Resource: "locations", Options: VersionOptions{Metadata: true, Deprecation: "Use v3."}},
+			wantMD: &metadata.Metadata{
+				Generated:      run.Generated,
+				SourceSnapshot: snapshot,
+				Commit:         "abc123",
+				RunID:          "20210301T120000Z",
+				Records:        2,
+				APIVersion:     "2",
+				Deprecation:    "Use v3.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			p := tt.ep.Payload(table, run, snapshot)
+			if tt.wantRaw {
+				if _, ok := p.Data.(types.TableContent); !ok {
+					t.Fatalf("got %T, want a bare table", p.Data)
+				}
+				return
+			}
+			resp, ok := p.Data.(metadata.APIResponse)
+			if !ok {
+				t.Fatalf("got %T, want metadata.APIResponse", p.Data)
+			}
+			if diff := cmp.Diff(tt.wantMD, resp.Metadata); diff != "" {
+				t.Errorf("-want +got:\n%v\n", diff)
+			}
+		})
+	}
+}
+
+func TestVersionOptions(t *testing.T) {
+	for version := range Versions {
+		if _, ok := EndpointMap[version]; !ok {
+			t.Errorf("Versions has settings for %q, which isn't in EndpointMap", version)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate())
+
+	ep := Endpoint{
+		Version:  "1",
+		Resource: "pharmacies",
+		Fields:   sameNames(legacy.LocationsFields),
+	}
+	tests := []struct {
+		filter  string
+		wantErr error
+	}{
+		{filter: ""},
+		{filter: `"Location Type" == "Pharmacy" && "id" != null`},
+		{filter: `"Location Typo" == "Pharmacy"`, wantErr: filter.ErrUnknownField},
+		// Only published fields may be filtered on.
+		{filter: `"Internal notes" == null`, wantErr: filter.ErrUnknownField},
+		{filter: `"Location Type" = "Pharmacy"`, wantErr: filter.ErrSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			ep.RowFilter = tt.filter
+			err := ep.Validate()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithRowFilter(t *testing.T) {
+	ctx := context.Background()
+	transform := withRowFilter(legacy.Locations, `"County" == "San Diego County"`)
+	table, err := transform(ctx, fixtureTables(ctx))
+	require.NoError(t, err)
+	require.Len(t, table, 3)
+	for _, row := range table {
+		require.Equal(t, "San Diego County", row["County"])
+	}
+}
@@ -0,0 +1,97 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/protobuf"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/schema"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// goldenDir holds the expected output of every endpoint, in every format,
+// for the saved test data.
+var goldenDir = filepath.Join("test_data", "golden")
+
+// notGolden are the formats which aren't compared by TestGolden: schemas and
+// .proto definitions have their own checked-in copies, and the protobuf
+// output is binary, and checked against the JSON by TestProtobuf.
+var notGolden = map[string]bool{
+	schema.FormatName:             true,
+	protobuf.DefinitionFormatName: true,
+	protobuf.FormatName:           true,
+}
+
+// TestGolden runs every endpoint against the saved test data, and compares
+// its output in each of its formats to the checked-in copies under
+// test_data/golden, so that any change in what is published, e.g. from a
+// change to a transform or a field list, shows up in review.  JSON is
+// indented, so that diffs are readable.
+func TestGolden(t *testing.T) {
+	ctx := context.Background()
+	generated := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	run := metadata.Run{ID: "20210401T120000Z", Commit: "golden", Generated: generated}
+
+	// The golden files are listed from the endpoints' definitions, rather
+	// than from the subtests which ran, so that running only some of them
+	// with -run doesn't remove or report the rest.
+	golden := map[string]bool{}
+	for _, ep := range AllEndpoints() {
+		for _, format := range ep.Formats {
+			if notGolden[format] {
+				continue
+			}
+			f, err := storage.LookupFormat(format)
+			require.NoError(t, err)
+			golden[filepath.Join(goldenDir, string(ep.Version), ep.Resource+f.Extension)] = true
+		}
+	}
+
+	for _, ep := range AllEndpoints() {
+		t.Run(ep.String(), func(t *testing.T) {
+			table, err := ep.Transform(ctx, endpointTables(ctx, ep))
+			require.NoError(t, err)
+			payload := ep.Payload(table, run, generated.Add(-30*time.Second))
+
+			for _, format := range ep.Formats {
+				if notGolden[format] {
+					continue
+				}
+				enc, err := storage.Encode(ctx, format, payload)
+				require.NoError(t, err, format)
+				data := enc.Data
+				if strings.Contains(enc.ContentType, "json") {
+					indented := &bytes.Buffer{}
+					require.NoError(t, json.Indent(indented, data, "", "  "))
+					indented.WriteByte('\n')
+					data = indented.Bytes()
+				}
+				file := filepath.Join(goldenDir, string(ep.Version), ep.Resource+enc.Extension)
+				checkFile(t, file, data)
+			}
+		})
+	}
+
+	// Files for endpoints or formats which no longer exist are removed.
+	err := filepath.Walk(goldenDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || golden[path] {
+			return err
+		}
+		if *update {
+			return os.Remove(path)
+		}
+		t.Errorf("%s is not the output of any endpoint; run `go test ./pipeline/pkg/endpoints -update` to remove it", path)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("failed to list %s: %v", goldenDir, err)
+	}
+}
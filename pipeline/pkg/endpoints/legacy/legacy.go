@@ -31,6 +31,26 @@ Okay, for those doing exporter stuff, for the following fields: "Name", "Address
  - perhaps we should give some of these better names and stick that in Locations-v2.json
 */
 
+// LocationsFields are the Locations columns published by Locations.
+var LocationsFields = types.FieldList{
+	{Name: "Address", Type: types.String},
+	{Name: "Affiliation", Type: types.String},
+	{Name: "Appointment scheduling instructions", Type: types.String},
+	{Name: "Availability Info", Type: types.StringList},
+	{Name: "County", Type: types.String},
+	{Name: "Has Report", Type: types.Number},
+	{Name: "Latest report", Type: types.String},
+	{Name: "Latest report notes", Type: types.String},
+	{Name: "Latest report yes?", Type: types.Number},
+	{Name: "Latitude", Type: types.Number},
+	{Name: "Location Type", Type: types.String},
+	{Name: "Longitude", Type: types.Number},
+	{Name: "Name", Type: types.String},
+	{Name: "vaccinefinder_location_id", Type: types.String},
+	{Name: "vaccinespotter_location_id", Type: types.String},
+	{Name: "google_places_id", Type: types.String},
+}
+
 func Locations(ctx context.Context, tables *airtable.Tables) (types.TableContent, error) {
 	ctx, span := beeline.StartSpan(ctx, "endpoints.legacy.Locations")
 	defer span.Send()
@@ -39,26 +59,8 @@ func Locations(ctx context.Context, tables *airtable.Tables) (types.TableContent
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch Locations table: %w", err)
 	}
-	fields := []string{
-		"Address",
-		"Affiliation",
-		"Appointment scheduling instructions",
-		"Availability Info",
-		"County",
-		"Has Report",
-		"Latest report",
-		"Latest report notes",
-		"Latest report yes?",
-		"Latitude",
-		"Location Type",
-		"Longitude",
-		"Name",
-		"vaccinefinder_location_id",
-		"vaccinespotter_location_id",
-		"google_places_id",
-	}
 
-	filteredTable, err := filter.Transform(rawTable, filter.WithFieldSlice(fields))
+	filteredTable, err := filter.Transform(rawTable, filter.WithFieldSlice(LocationsFields.Names()))
 	if err != nil {
 		return nil, fmt.Errorf("Transform: %w", err)
 	}
@@ -66,6 +68,21 @@ func Locations(ctx context.Context, tables *airtable.Tables) (types.TableContent
 	return filteredTable, nil
 }
 
+// CountiesFields are the Counties columns published by Counties.
+var CountiesFields = types.FieldList{
+	{Name: "County", Type: types.String},
+	{Name: "County vaccination reservations URL", Type: types.String},
+	{Name: "Facebook Page", Type: types.String},
+	{Name: "Notes", Type: types.String},
+	{Name: "Official volunteering opportunities", Type: types.String},
+	{Name: "Total reports", Type: types.Number},
+	{Name: "Twitter Page", Type: types.String},
+	{Name: "Vaccine info URL", Type: types.String},
+	{Name: "Vaccine locations URL", Type: types.String},
+	{Name: "Yeses", Type: types.Number},
+	{Name: "age_floor_without_restrictions", Type: types.Number},
+}
+
 func Counties(ctx context.Context, tables *airtable.Tables) (types.TableContent, error) {
 	ctx, span := beeline.StartSpan(ctx, "endpoints.legacy.Counties")
 	defer span.Send()
@@ -74,21 +91,8 @@ func Counties(ctx context.Context, tables *airtable.Tables) (types.TableContent,
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch Counties table: %w", err)
 	}
-	fields := []string{
-		"County",
-		"County vaccination reservations URL",
-		"Facebook Page",
-		"Notes",
-		"Official volunteering opportunities",
-		"Total reports",
-		"Twitter Page",
-		"Vaccine info URL",
-		"Vaccine locations URL",
-		"Yeses",
-		"age_floor_without_restrictions",
-	}
 
-	filteredTable, err := filter.Transform(rawTable, filter.WithFieldSlice(fields))
+	filteredTable, err := filter.Transform(rawTable, filter.WithFieldSlice(CountiesFields.Names()))
 	if err != nil {
 		return nil, fmt.Errorf("Transform: %w", err)
 	}
@@ -2,16 +2,74 @@ package locations
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
-	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/legacy"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/filter"
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
 	"github.com/honeycombio/beeline-go"
 )
 
+// CountyLink is the Locations column holding the Airtable record ID of each
+// location's county.
+const CountyLink = "County link"
+
+// V1CountyFields are the Counties columns which V1 copies onto each location
+// from its linked county.
+var V1CountyFields = types.FieldList{
+	{Name: "County vaccination reservations URL", Type: types.String},
+}
+
+// V1LocationFields are the Locations columns published by V1.  They started
+// as a copy of legacy.LocationsFields, but are kept separately, since V1's
+// protobuf field numbers follow their order; adding a field to the legacy
+// endpoint mustn't renumber V1's.
+var V1LocationFields = types.FieldList{
+	{Name: "Address", Type: types.String},
+	{Name: "Affiliation", Type: types.String},
+	{Name: "Appointment scheduling instructions", Type: types.String},
+	{Name: "Availability Info", Type: types.StringList},
+	{Name: "County", Type: types.String},
+	{Name: "Has Report", Type: types.Number},
+	{Name: "Latest report", Type: types.String},
+	{Name: "Latest report notes", Type: types.String},
+	{Name: "Latest report yes?", Type: types.Number},
+	{Name: "Latitude", Type: types.Number},
+	{Name: "Location Type", Type: types.String},
+	{Name: "Longitude", Type: types.Number},
+	{Name: "Name", Type: types.String},
+	{Name: "vaccinefinder_location_id", Type: types.String},
+	{Name: "vaccinespotter_location_id", Type: types.String},
+	{Name: "google_places_id", Type: types.String},
+}
+
+// V1Fields are the fields published by V1: V1LocationFields, then
+// V1CountyFields.
+var V1Fields = append(append(types.FieldList{}, V1LocationFields...), V1CountyFields...)
+
 func V1(ctx context.Context, tables *airtable.Tables) (types.TableContent, error) {
 	ctx, span := beeline.StartSpan(ctx, "endpoints.locations.V1")
 	defer span.Send()
 
-	return legacy.Locations(ctx, tables)
+	rawTable, err := tables.GetLocations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Locations table: %w", err)
+	}
+	counties, err := tables.GetCounties(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Counties table: %w", err)
+	}
+
+	countyFields := make(map[string]string, len(V1CountyFields))
+	for _, f := range V1CountyFields {
+		countyFields[f.Name] = f.Name
+	}
+	filteredTable, err := filter.Transform(rawTable,
+		filter.WithLinkedFields(CountyLink, counties, countyFields),
+		filter.WithFieldSlice(V1Fields.Names()))
+	if err != nil {
+		return nil, fmt.Errorf("Transform: %w", err)
+	}
+
+	return filteredTable, nil
 }
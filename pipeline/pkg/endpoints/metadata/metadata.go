@@ -1,6 +1,10 @@
 package metadata
 
-import "github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+import (
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
 
 // Arbitrary data that may be JSON marshalled.
 type JSONData interface{}
@@ -16,10 +20,38 @@ type Contact struct {
 	PartnersEmail string `json:"partnersEmail"`
 }
 
+// Metadata describes how and when a response was generated.
+type Metadata struct {
+	// Generated is when the pipeline generated the response.
+	Generated time.Time `json:"generated"`
+	// SourceSnapshot is when the Airtable table the response is generated
+	// from was fetched.
+	SourceSnapshot time.Time `json:"sourceSnapshot"`
+	// Commit is the git commit of the pipeline.
+	Commit string `json:"commit"`
+	// RunID identifies the pipeline run.
+	RunID string `json:"runId"`
+	// Records is the number of rows in the content.
+	Records    int    `json:"records"`
+	APIVersion string `json:"apiVersion"`
+	// Deprecation, if set, is a notice that the API version is deprecated,
+	// and what to use instead.
+	Deprecation string `json:"deprecation,omitempty"`
+}
+
+// Run is the part of a response's Metadata which is the same for every
+// response of a pipeline run.
+type Run struct {
+	ID        string
+	Commit    string
+	Generated time.Time
+}
+
 // APIResponse is the API content.
-// TODO: add a metadata struct if and when we start including more metadata-like fields.
 type APIResponse struct {
 	Usage Usage `json:"usage"`
+	// Metadata is only included in versions which opt in to it.
+	Metadata *Metadata `json:"metadata,omitempty"`
 	// Content is the actual data. It's currently limited to a table-type response (list of KV maps), but it doesn't need to be.
 	Content types.TableContent `json:"content"`
 }
@@ -28,6 +60,12 @@ var defaultNoticeText = "Please contact VaccinateCA and let us know if you plan
 
 // Wraps a list of tabular data with the default usage stanza.
 func Wrap(table types.TableContent) JSONData {
+	return WrapWithMetadata(table, nil)
+}
+
+// WrapWithMetadata wraps a list of tabular data with the default usage
+// stanza and the given metadata, which is left out if nil.
+func WrapWithMetadata(table types.TableContent, md *Metadata) JSONData {
 	return APIResponse{
 		Usage: Usage{
 			Notice: defaultNoticeText,
@@ -35,6 +73,7 @@ func Wrap(table types.TableContent) JSONData {
 				PartnersEmail: "api@vaccinateca.com",
 			},
 		},
-		Content: table,
+		Metadata: md,
+		Content:  table,
 	}
 }
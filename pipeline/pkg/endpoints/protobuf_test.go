@@ -0,0 +1,72 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/protobuf"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// TestProtobuf checks that every endpoint's protobuf output carries the same
+// data as its JSON, by reading it with the descriptor of its definition and
+// converting it to JSON, and that the definitions match the checked-in
+// copies under test_data/proto, so changes to them show up in review.
+func TestProtobuf(t *testing.T) {
+	ctx := context.Background()
+	run := metadata.Run{ID: "20210401T120000Z", Commit: "abc123", Generated: time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)}
+	for _, ep := range AllEndpoints() {
+		if !contains(ep.Formats, protobuf.FormatName) {
+			continue
+		}
+		t.Run(ep.String(), func(t *testing.T) {
+			table, err := ep.Transform(ctx, endpointTables(ctx, ep))
+			require.NoError(t, err)
+			payload := ep.Payload(table, run, run.Generated)
+
+			pb, err := storage.Encode(ctx, protobuf.FormatName, payload)
+			require.NoError(t, err)
+			js, err := storage.Encode(ctx, storage.FormatJSON, payload)
+			require.NoError(t, err)
+
+			f, err := protobuf.ForPayload(payload)
+			require.NoError(t, err)
+			fd, err := protodesc.NewFile(f.Descriptor(), nil)
+			require.NoError(t, err)
+			msg := dynamicpb.NewMessage(fd.Messages().ByName("Response"))
+			require.NoError(t, proto.Unmarshal(pb.Data, msg))
+			fromPB, err := protojson.Marshal(msg)
+			require.NoError(t, err)
+
+			var got, want interface{}
+			require.NoError(t, json.Unmarshal(fromPB, &got))
+			require.NoError(t, json.Unmarshal(js.Data, &want))
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("protobuf doesn't match JSON (-json +protobuf):\n%s", diff)
+			}
+
+			def, err := storage.Encode(ctx, protobuf.DefinitionFormatName, payload)
+			require.NoError(t, err)
+			checkFile(t, filepath.Join("test_data", "proto", string(ep.Version), ep.Resource+def.Extension), def.Data)
+		})
+	}
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -11,6 +11,18 @@ import (
 	"github.com/honeycombio/beeline-go"
 )
 
+// V1Fields are the Provider networks columns published by V1.
+var V1Fields = types.FieldList{
+	{Name: "Appointments URL", Type: types.String},
+	{Name: "Last Updated", Type: types.String},
+	{Name: "Phase", Type: types.StringList},
+	{Name: "Provider", Type: types.String},
+	{Name: "Public Notes", Type: types.String},
+	{Name: "Provider network type", Type: types.String},
+	{Name: "Vaccine info URL", Type: types.String},
+	{Name: "Vaccine locations URL", Type: types.String},
+}
+
 func V1(ctx context.Context, tables *airtable.Tables) (types.TableContent, error) {
 	ctx, span := beeline.StartSpan(ctx, "endpoints.providers.V1")
 	defer span.Send()
@@ -20,17 +32,7 @@ func V1(ctx context.Context, tables *airtable.Tables) (types.TableContent, error
 		return nil, fmt.Errorf("failed to fetch Providers table: %w", err)
 	}
 
-	fields := []string{
-		"Appointments URL",
-		"Last Updated",
-		"Phase",
-		"Provider",
-		"Public Notes",
-		"Provider network type",
-		"Vaccine info URL",
-		"Vaccine locations URL",
-	}
-	filteredTable, err := filter.Transform(rawTable, filter.WithFieldSlice(fields))
+	filteredTable, err := filter.Transform(rawTable, filter.WithFieldSlice(V1Fields.Names()))
 	if err != nil {
 		return nil, fmt.Errorf("Transform: %w", err)
 	}
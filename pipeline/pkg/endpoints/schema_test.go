@@ -0,0 +1,125 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/schema"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the checked-in files under test_data with the current output")
+
+// stubFetchFromFiles is a fetcher which reads each table from a file of
+// saved test data.
+type stubFetchFromFiles map[string]string
+
+func (sf stubFetchFromFiles) Download(ctx context.Context, table string) (types.TableContent, error) {
+	f, ok := sf[table]
+	if !ok {
+		return nil, fmt.Errorf("table %q data not specified in test", table)
+	}
+	o, err := airtable.ObjectFromFile(ctx, table, f)
+	if err != nil {
+		return nil, err
+	}
+	// The saved test data is from the airtable-export tool, which stores
+	// the record id in a different field.
+	for _, r := range o {
+		r["id"] = r["airtable_id"]
+	}
+	return o, nil
+}
+
+// linkedCountiesFile is the saved Counties data, with the fields which are
+// copied onto locations from their linked county filled in.  It is kept
+// apart from test_data/counties.json, so that the Counties endpoints'
+// expected output doesn't change with it.
+const linkedCountiesFile = "test_data/counties_linked.json"
+
+// fixtureTables returns Tables which read every table from the saved test
+// data.
+func fixtureTables(ctx context.Context) *airtable.Tables {
+	return airtable.NewFakeTables(ctx, fixtureFiles())
+}
+
+// endpointTables returns Tables which read every table from the saved test
+// data, for the endpoint; endpoints which copy fields from linked records
+// read the Counties from linkedCountiesFile.
+func endpointTables(ctx context.Context, ep Endpoint) *airtable.Tables {
+	files := fixtureFiles()
+	for _, f := range ep.Fields {
+		if f.Link != "" && f.Table == "Counties" {
+			files["Counties"] = linkedCountiesFile
+		}
+	}
+	return airtable.NewFakeTables(ctx, files)
+}
+
+func fixtureFiles() stubFetchFromFiles {
+	return stubFetchFromFiles{
+		"Locations":         "test_data/locations_reduced.json",
+		"Counties":          "test_data/counties.json",
+		"Provider networks": "test_data/providers.json",
+	}
+}
+
+// checkFile compares got to the checked-in file, or overwrites the file
+// if -update is set.
+func checkFile(t *testing.T, file string, got []byte) {
+	t.Helper()
+	if *update {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, ioutil.WriteFile(file, got, 0644))
+		return
+	}
+	want, err := ioutil.ReadFile(file)
+	require.NoError(t, err, "run `go test ./pipeline/pkg/endpoints -update` to create it")
+	if diff := cmp.Diff(string(want), string(got)); diff != "" {
+		t.Errorf("%s is out of date; if this change is intended, run `go test ./pipeline/pkg/endpoints -update` and check in the diff.  -want +got:\n%v\n", file, diff)
+	}
+}
+
+// TestSchemas checks that the schemas generated from every endpoint's
+// declared fields match the checked-in copies under test_data/schemas, so
+// changes to them show up in review, and that the endpoint's output is valid
+// against the checked-in copy.
+func TestSchemas(t *testing.T) {
+	ctx := context.Background()
+	for _, ep := range AllEndpoints() {
+		t.Run(ep.String(), func(t *testing.T) {
+			table, err := ep.Transform(ctx, endpointTables(ctx, ep))
+			require.NoError(t, err)
+			payload := ep.Payload(table, metadata.Run{}, time.Time{})
+
+			enc, err := storage.Encode(ctx, schema.FormatName, payload)
+			require.NoError(t, err)
+			indented := &bytes.Buffer{}
+			require.NoError(t, json.Indent(indented, enc.Data, "", "  "))
+			indented.WriteByte('\n')
+			file := filepath.Join("test_data", "schemas", string(ep.Version), ep.Resource+enc.Extension)
+			checkFile(t, file, indented.Bytes())
+
+			checkedIn, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+			var s schema.Schema
+			require.NoError(t, json.Unmarshal(checkedIn, &s))
+			out, err := storage.Encode(ctx, storage.FormatJSON, payload)
+			require.NoError(t, err)
+			require.NoError(t, s.Validate(out.Data))
+		})
+	}
+}
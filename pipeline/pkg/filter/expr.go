@@ -0,0 +1,491 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnknownField represents the case when a row filter refers to a field
+// which isn't one of the known fields.
+var ErrUnknownField = errors.New("unknown field")
+
+// ErrSyntax represents a row filter expression which could not be parsed.
+var ErrSyntax = errors.New("syntax error")
+
+// RowFilter is a parsed row filter expression, which matches rows based on
+// their field values.  Expressions compare a quoted field name on the left
+// with a literal on the right, and combine comparisons with boolean
+// operators:
+//
+//	"Location Type" == "Pharmacy" && ("Has Report" == 1 || !("County" == "Glenn County"))
+//
+// Supported comparisons are ==, !=, <, <=, > and >=.  Literals may be quoted
+// strings, numbers, true, false or null; a missing field compares equal to
+// null.  If a field holds a list, == and != test if any element is equal.
+type RowFilter struct {
+	expr   string
+	root   exprNode
+	fields []string
+}
+
+// ParseRowFilter parses a row filter expression.  Errors wrap ErrSyntax.
+func ParseRowFilter(expr string) (*RowFilter, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w in row filter %q: %v", ErrSyntax, expr, err)
+	}
+	p := &parser{toks: toks, fields: map[string]struct{}{}}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %s at offset %d", p.peek(), p.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w in row filter %q: %v", ErrSyntax, expr, err)
+	}
+
+	rf := &RowFilter{expr: expr, root: root}
+	for f := range p.fields {
+		rf.fields = append(rf.fields, f)
+	}
+	sort.Strings(rf.fields)
+	return rf, nil
+}
+
+// String returns the expression the filter was parsed from.
+func (rf *RowFilter) String() string {
+	return rf.expr
+}
+
+// Fields returns the names of every field the filter refers to, sorted.
+func (rf *RowFilter) Fields() []string {
+	return rf.fields
+}
+
+// Match reports if the row matches the filter.
+func (rf *RowFilter) Match(row map[string]interface{}) bool {
+	return rf.root.eval(row)
+}
+
+// CheckFields returns an error wrapping ErrUnknownField if the filter refers
+// to any field not in known.
+func (rf *RowFilter) CheckFields(known []string) error {
+	k := make(map[string]struct{}, len(known))
+	for _, f := range known {
+		k[f] = struct{}{}
+	}
+	for _, f := range rf.fields {
+		if _, ok := k[f]; !ok {
+			return fmt.Errorf("%w: %q in row filter %q", ErrUnknownField, f, rf.expr)
+		}
+	}
+	return nil
+}
+
+// ValidateRowFilter checks that an expression parses, and only refers to
+// known fields.  It is intended to be called at startup, so that mistakes in
+// configured filters are found before the first publish.
+func ValidateRowFilter(expr string, known []string) error {
+	rf, err := parseRowFilterCached(expr)
+	if err != nil {
+		return err
+	}
+	return rf.CheckFields(known)
+}
+
+// parsedFilters caches the result of parsing each expression, since endpoints
+// construct their XformOpts on every publish.
+var parsedFilters sync.Map // map[string]parseResult
+
+type parseResult struct {
+	rf  *RowFilter
+	err error
+}
+
+func parseRowFilterCached(expr string) (*RowFilter, error) {
+	if r, ok := parsedFilters.Load(expr); ok {
+		return r.(parseResult).rf, r.(parseResult).err
+	}
+	rf, err := ParseRowFilter(expr)
+	parsedFilters.Store(expr, parseResult{rf: rf, err: err})
+	return rf, err
+}
+
+// WithRowFilter configures the transformer to drop rows which do not match
+// the expression; see RowFilter for the syntax.  It is applied in order with
+// any mungers.  If the expression does not parse, Transform returns an error
+// wrapping ErrSyntax.  Fields which a row doesn't have compare equal to null,
+// as Airtable omits empty fields, so a misspelled field can't be told apart
+// from one which is empty in every row; check expressions against the
+// fields they may refer to with ValidateRowFilter at startup.
+func WithRowFilter(expr string) XformOpt {
+	rf, err := parseRowFilterCached(expr)
+	return func(cfg *xformCfg) {
+		if err != nil {
+			cfg.errs = append(cfg.errs, err)
+			return
+		}
+		cfg.mungers = append(cfg.mungers, mungerEntry{m: rf.munge, copyOnWrite: true})
+	}
+}
+
+// munge is a copy-on-write Munger which drops rows that don't match.
+func (rf *RowFilter) munge(row map[string]interface{}) (map[string]interface{}, error) {
+	if !rf.Match(row) {
+		return nil, nil
+	}
+	return row, nil
+}
+
+// exprNode is a node in a parsed filter expression.
+type exprNode interface {
+	eval(row map[string]interface{}) bool
+}
+
+type andNode struct{ l, r exprNode }
+
+func (n andNode) eval(row map[string]interface{}) bool { return n.l.eval(row) && n.r.eval(row) }
+
+type orNode struct{ l, r exprNode }
+
+func (n orNode) eval(row map[string]interface{}) bool { return n.l.eval(row) || n.r.eval(row) }
+
+type notNode struct{ n exprNode }
+
+func (n notNode) eval(row map[string]interface{}) bool { return !n.n.eval(row) }
+
+type cmpNode struct {
+	field string
+	op    string
+	value interface{} // string, float64, bool, or nil
+}
+
+func (n cmpNode) eval(row map[string]interface{}) bool {
+	v := row[n.field]
+	if n.op == "==" || n.op == "!=" {
+		eq := equalValue(v, n.value)
+		if n.op == "==" {
+			return eq
+		}
+		return !eq
+	}
+
+	c, ok := compareValues(v, n.value)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// equalValue compares a row value with a literal; lists are equal if any of
+// their elements are.
+func equalValue(v, lit interface{}) bool {
+	switch l := v.(type) {
+	case []interface{}:
+		for _, e := range l {
+			if equalValue(e, lit) {
+				return true
+			}
+		}
+		return false
+	case []string:
+		for _, e := range l {
+			if equalValue(e, lit) {
+				return true
+			}
+		}
+		return false
+	}
+	if v == nil || lit == nil {
+		return v == nil && lit == nil
+	}
+	c, ok := compareValues(v, lit)
+	if ok {
+		return c == 0
+	}
+	b1, ok1 := v.(bool)
+	b2, ok2 := lit.(bool)
+	return ok1 && ok2 && b1 == b2
+}
+
+// compareValues orders a row value against a literal, if they are both
+// numbers or both strings.
+func compareValues(v, lit interface{}) (int, bool) {
+	if f1, ok := toFloat(v); ok {
+		if f2, ok := toFloat(lit); ok {
+			switch {
+			case f1 < f2:
+				return -1, true
+			case f1 > f2:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	s1, ok1 := v.(string)
+	s2, ok2 := lit.(string)
+	if ok1 && ok2 {
+		return strings.Compare(s1, s2), true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokString
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string // for strings, the unquoted value
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex splits an expression into tokens.  Offsets are in bytes.
+func lex(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case c == utf8.RuneError && size == 1:
+			return nil, fmt.Errorf("invalid UTF-8 at offset %d", i)
+		case unicode.IsSpace(c):
+			i += size
+		case c == '"':
+			// Find the closing quote, skipping escaped characters.
+			j, closed := i+size, false
+			for j < len(s) && !closed {
+				r, n := utf8.DecodeRuneInString(s[j:])
+				if r == utf8.RuneError && n == 1 {
+					return nil, fmt.Errorf("invalid UTF-8 at offset %d", j)
+				}
+				j += n
+				switch r {
+				case '"':
+					closed = true
+				case '\\':
+					_, n = utf8.DecodeRuneInString(s[j:])
+					j += n
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			str, err := strconv.Unquote(s[i:j])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %v", i, err)
+			}
+			toks = append(toks, token{kind: tokString, text: str, pos: i})
+			i = j
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			// A sign is only part of a number at its start, or
+			// straight after its exponent's "e".
+			var prev rune
+			j := scan(s, i+size, func(r rune) bool {
+				ok := r == '.' || r == 'e' || r == 'E' || unicode.IsDigit(r) ||
+					((r == '-' || r == '+') && (prev == 'e' || prev == 'E'))
+				prev = r
+				return ok
+			})
+			toks = append(toks, token{kind: tokNumber, text: s[i:j], pos: i})
+			i = j
+		case unicode.IsLetter(c):
+			j := scan(s, i+size, unicode.IsLetter)
+			toks = append(toks, token{kind: tokIdent, text: s[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+// scan returns the offset of the first rune in s, starting at offset i,
+// which doesn't satisfy ok, or len(s) if they all do.
+func scan(s string, i int, ok func(rune) bool) int {
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if !ok(r) {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+// parser is a recursive-descent parser over a list of tokens.
+type parser struct {
+	toks   []token
+	i      int
+	fields map[string]struct{}
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *parser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (exprNode, error) {
+	switch {
+	case p.isOp("!"):
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case p.isOp("("):
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, fmt.Errorf("expected \")\" at offset %d, got %s", p.peek().pos, p.peek())
+		}
+		p.next()
+		return n, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (exprNode, error) {
+	f := p.next()
+	if f.kind != tokString {
+		return nil, fmt.Errorf("expected quoted field name at offset %d, got %s", f.pos, f)
+	}
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, fmt.Errorf("expected comparison operator at offset %d, got %s", op.pos, op)
+	}
+	switch op.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("expected comparison operator at offset %d, got %s", op.pos, op)
+	}
+
+	lit := p.next()
+	var v interface{}
+	switch lit.kind {
+	case tokString:
+		v = lit.text
+	case tokNumber:
+		n, err := strconv.ParseFloat(lit.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at offset %d", lit, lit.pos)
+		}
+		v = n
+	case tokIdent:
+		switch lit.text {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			return nil, fmt.Errorf("unknown literal %s at offset %d", lit, lit.pos)
+		}
+	default:
+		return nil, fmt.Errorf("expected literal at offset %d, got %s", lit.pos, lit)
+	}
+
+	p.fields[f.text] = struct{}{}
+	return cmpNode{field: f.text, op: op.text, value: v}, nil
+}
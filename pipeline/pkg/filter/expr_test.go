@@ -0,0 +1,195 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRowFilterMatch(t *testing.T) {
+	row := map[string]interface{}{
+		"Name":              "Kaiser Permanente Pharmacy #568",
+		"Location Type":     "Pharmacy",
+		"Has Report":        1.0,
+		"Latitude":          34.081292,
+		"is_soft_deleted":   false,
+		"Availability Info": []interface{}{"Yes: walk-ins", "Yes: appointment required"},
+		"County":            "Santa Bárbara County",
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`"Location Type" == "Pharmacy"`, true},
+		{`"Location Type" != "Pharmacy"`, false},
+		{`"Location Type" == "Pharmacy" && "Has Report" == 1`, true},
+		{`"Location Type" == "Hospital" || "Has Report" == 1`, true},
+		{`"Location Type" == "Hospital" || "Has Report" == 0`, false},
+		{`!("Location Type" == "Hospital")`, true},
+		{`!"Location Type" == "Hospital"`, true},
+		{`"Latitude" > 34 && "Latitude" <= 34.081292`, true},
+		{`"Latitude" < 34`, false},
+		{`"Latitude" > 3.4e1 && "Latitude" > 3.4E+1 && "Latitude" < 3.5e+01`, true},
+		{`"Latitude" > 1e-5 && "Latitude" > -1E-5`, true},
+		{`"Has Report" == 1e0 && "Has Report" > 1e-1`, true},
+		{`"Latitude" >= "34"`, false}, // mismatched types never order
+		{`"Name" < "L"`, true},
+		{`"is_soft_deleted" == false`, true},
+		{`"is_soft_deleted" == true`, false},
+		{`"Missing" == null`, true},
+		{`"Missing" != null`, false},
+		{`"Name" == null`, false},
+		{`"Missing" == "x"`, false},
+		{`"Availability Info" == "Yes: walk-ins"`, true},
+		{`"Availability Info" != "No"`, true},
+		{`"Has Report" == 1 && ("Location Type" == "Hospital" || "Latitude" > 30)`, true},
+		{`"Has Report" == 1 && "Location Type" == "Hospital" || "Latitude" > 30`, true},
+		{`"Has Report" == 1 && "Location Type" == "Hospital" || "Latitude" > 40`, false},
+		{`"Name" == "Kaiser Permanente Pharmacy #568"`, true},
+		{`"County" == "Santa Bárbara County"`, true},
+		{`"County" == "Santa B\u00e1rbara County"`, true},
+		{`"County" < "Santa C"`, true},
+		{"\"Has Report\"\u00a0==\u00a01", true}, // non-breaking spaces
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			rf, err := ParseRowFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := rf.Match(row); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRowFilter_Errors(t *testing.T) {
+	tests := []string{
+		``,
+		`"Location Type"`,
+		`"Location Type" = "Pharmacy"`,
+		`"Location Type" == `,
+		`"Location Type" == Pharmacy`,
+		`Location == "Pharmacy"`,
+		`"Location Type" == "Pharmacy`,
+		`("Has Report" == 1`,
+		`"Has Report" == 1)`,
+		`"Has Report" == 1 &&`,
+		`"Has Report" == 1 "Name" == "x"`,
+		`"Has Report" == 1.2.3`,
+		`"Has Report" == 1e--1`,
+		`"Has Report" == 1-1`,
+		`"Has Report" == 1 # comment`,
+		`"Has Report" == 1 ∧ "Name" == "x"`,
+		`"Has Report" == vrái`,
+		"\"Name\" == \"\xff\"",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseRowFilter(expr)
+			if !errors.Is(err, ErrSyntax) {
+				t.Errorf("got error %v, want %v", err, ErrSyntax)
+			}
+		})
+	}
+}
+
+func TestRowFilterFields(t *testing.T) {
+	rf, err := ParseRowFilter(`"b" == 1 && ("a" == 2 || "b" == 3)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, rf.Fields()); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+	if err := rf.CheckFields([]string{"a", "b", "c"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := rf.CheckFields([]string{"a"}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownField)
+	}
+}
+
+func TestValidateRowFilter(t *testing.T) {
+	known := []string{"Location Type", "Has Report"}
+	tests := []struct {
+		expr    string
+		wantErr error
+	}{
+		{`"Location Type" == "Pharmacy" && "Has Report" == 1`, nil},
+		{`"Location Typo" == "Pharmacy"`, ErrUnknownField},
+		{`"Location Type" === "Pharmacy"`, ErrSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			err := ValidateRowFilter(tt.expr, known)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransformRowFilter(t *testing.T) {
+	tests := []struct {
+		desc    string
+		in      types.TableContent
+		opts    []XformOpt
+		want    types.TableContent
+		wantErr error
+	}{
+		{
+			desc: "keep matching",
+			in:   testData(),
+			opts: []XformOpt{WithRowFilter(`"Name" == "Pfizer"`)},
+			want: types.TableContent{
+				{"id": "2", "Name": "Pfizer", "Other": "lower"},
+			},
+		},
+		{
+			desc: "applied in order with mungers",
+			in:   testData(),
+			opts: []XformOpt{WithMunger(ucName), WithRowFilter(`"Name" == "MODERNA"`)},
+			want: types.TableContent{
+				{"id": "1", "Name": "MODERNA", "Other": "MiXeD"},
+			},
+		},
+		{
+			desc: "filter on a field which isn't published",
+			in:   testData(),
+			opts: []XformOpt{WithRowFilter(`"Other" == "lower"`), WithFieldSlice([]string{"Name"})},
+			want: types.TableContent{
+				{"id": "2", "Name": "Pfizer"},
+			},
+		},
+		{
+			desc:    "syntax error",
+			in:      testData(),
+			opts:    []XformOpt{WithRowFilter(`"Name" = "Pfizer"`)},
+			wantErr: ErrSyntax,
+		},
+		{
+			// Only ValidateRowFilter can tell a misspelled field
+			// from one which no row has a value for.
+			desc: "missing field is null",
+			in:   testData(),
+			opts: []XformOpt{WithRowFilter(`"Nmae" == "Pfizer"`)},
+			want: types.TableContent{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := Transform(tt.in, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("-want +got:\n %v\n", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package filter
+
+import (
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// WithLinkedFields configures the transformer to copy fields from the rows of
+// another table, joined by the Airtable record IDs stored in linkField (e.g.
+// "County link").  fields maps the linked table's column names to the names
+// they are copied into the row as.  If a row links to more than one record,
+// each field is taken from the first linked record which has it.  Fields which
+// already exist on the row are overwritten.  Values are deep-copied, so the
+// output never shares lists or objects with the linked table; rows with no
+// resolvable links aren't copied at all.
+func WithLinkedFields(linkField string, linked types.TableContent, fields map[string]string) XformOpt {
+	index := indexByID(linked)
+	return WithCopyOnWriteMunger(func(row map[string]interface{}) (map[string]interface{}, error) {
+		recs := linkedRows(row[linkField], index)
+		if len(recs) == 0 {
+			return row, nil
+		}
+		row = types.CloneRow(row)
+		for from, to := range fields {
+			for _, rec := range recs {
+				if v, ok := rec[from]; ok {
+					row[to] = types.CloneValue(v)
+					break
+				}
+			}
+		}
+		return row, nil
+	})
+}
+
+// WithEmbeddedLinks configures the transformer to embed the linked rows of
+// another table, joined by the Airtable record IDs stored in linkField, as a
+// list of objects under outField.  Each embedded object contains the "id" of
+// the linked record and deep copies of the listed fields, where present.
+// Rows with no resolvable links get an empty list.
+func WithEmbeddedLinks(linkField, outField string, linked types.TableContent, fields []string) XformOpt {
+	index := indexByID(linked)
+	return WithMunger(func(row map[string]interface{}) (map[string]interface{}, error) {
+		recs := linkedRows(row[linkField], index)
+		embedded := make([]interface{}, 0, len(recs))
+		for _, rec := range recs {
+			e := map[string]interface{}{"id": rec["id"]}
+			for _, f := range fields {
+				if v, ok := rec[f]; ok {
+					e[f] = types.CloneValue(v)
+				}
+			}
+			embedded = append(embedded, e)
+		}
+		row[outField] = embedded
+		return row, nil
+	})
+}
+
+// indexByID builds a lookup table of rows, keyed by their Airtable record ID.
+func indexByID(table types.TableContent) map[string]map[string]interface{} {
+	index := make(map[string]map[string]interface{}, len(table))
+	for _, row := range table {
+		if id, ok := row["id"].(string); ok {
+			index[id] = row
+		}
+	}
+	return index
+}
+
+// linkedRows resolves the value of an Airtable link field to the rows it
+// refers to, in link order.  Links which don't resolve are skipped.
+func linkedRows(link interface{}, index map[string]map[string]interface{}) []map[string]interface{} {
+	var ids []string
+	switch l := link.(type) {
+	case string:
+		ids = []string{l}
+	case []string:
+		ids = l
+	case []interface{}:
+		for _, v := range l {
+			if id, ok := v.(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	var out []map[string]interface{}
+	for _, id := range ids {
+		if rec, ok := index[id]; ok {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+// linkedTestData is the table that rows in linkTestData link to.
+func linkedTestData() types.TableContent {
+	return types.TableContent{
+		{
+			"id":               "recA",
+			"Provider":         "Kaiser",
+			"Appointments URL": "https://kaiser.example/",
+		},
+		{
+			"id":       "recB",
+			"Provider": "Rite Aid",
+		},
+	}
+}
+
+func linkTestData() types.TableContent {
+	return types.TableContent{
+		{
+			"id":               "1",
+			"Provider network": []interface{}{"recA"},
+		},
+		{
+			"id":               "2",
+			"Provider network": []interface{}{"recB", "recA"},
+		},
+		{
+			"id":               "3",
+			"Provider network": []interface{}{"recDoesNotExist"},
+		},
+		{
+			"id": "4",
+		},
+	}
+}
+
+func TestWithLinkedFields(t *testing.T) {
+	in := linkTestData()
+	got, err := Transform(in, WithLinkedFields("Provider network", linkedTestData(), map[string]string{
+		"Provider":         "Provider name",
+		"Appointments URL": "Appointments URL",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error from Transform: %v", err)
+	}
+
+	want := types.TableContent{
+		{
+			"id":               "1",
+			"Provider network": []interface{}{"recA"},
+			"Provider name":    "Kaiser",
+			"Appointments URL": "https://kaiser.example/",
+		},
+		{
+			// Provider comes from the first link, Appointments URL from the
+			// first link which has it.
+			"id":               "2",
+			"Provider network": []interface{}{"recB", "recA"},
+			"Provider name":    "Rite Aid",
+			"Appointments URL": "https://kaiser.example/",
+		},
+		{
+			"id":               "3",
+			"Provider network": []interface{}{"recDoesNotExist"},
+		},
+		{
+			"id": "4",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n %v\n", diff)
+	}
+	if diff := cmp.Diff(linkTestData(), in); diff != "" {
+		t.Errorf("expected input unmodified: -want +got:\n %v\n", diff)
+	}
+}
+
+func TestWithEmbeddedLinks(t *testing.T) {
+	in := linkTestData()
+	got, err := Transform(in,
+		WithEmbeddedLinks("Provider network", "providers", linkedTestData(), []string{"Provider", "Appointments URL"}),
+		WithFieldSlice([]string{"providers"}))
+	if err != nil {
+		t.Fatalf("unexpected error from Transform: %v", err)
+	}
+
+	want := types.TableContent{
+		{
+			"id": "1",
+			"providers": []interface{}{
+				map[string]interface{}{"id": "recA", "Provider": "Kaiser", "Appointments URL": "https://kaiser.example/"},
+			},
+		},
+		{
+			"id": "2",
+			"providers": []interface{}{
+				map[string]interface{}{"id": "recB", "Provider": "Rite Aid"},
+				map[string]interface{}{"id": "recA", "Provider": "Kaiser", "Appointments URL": "https://kaiser.example/"},
+			},
+		},
+		{
+			"id":        "3",
+			"providers": []interface{}{},
+		},
+		{
+			"id":        "4",
+			"providers": []interface{}{},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n %v\n", diff)
+	}
+	if diff := cmp.Diff(linkTestData(), in); diff != "" {
+		t.Errorf("expected input unmodified: -want +got:\n %v\n", diff)
+	}
+}
+
+func TestLinkedValuesAreCopied(t *testing.T) {
+	linked := types.TableContent{
+		{"id": "recA", "Phase": []interface{}{"1a"}},
+	}
+	in := types.TableContent{
+		{"id": "1", "Provider network": []interface{}{"recA"}},
+	}
+	copied, err := Transform(in, WithLinkedFields("Provider network", linked, map[string]string{"Phase": "Phase"}))
+	if err != nil {
+		t.Fatalf("unexpected error from Transform: %v", err)
+	}
+	embedded, err := Transform(in, WithEmbeddedLinks("Provider network", "providers", linked, []string{"Phase"}))
+	if err != nil {
+		t.Fatalf("unexpected error from Transform: %v", err)
+	}
+
+	// Modifying the output must not modify the linked table, which is
+	// shared with every other transform of it.
+	copied[0]["Phase"].([]interface{})[0] = "changed"
+	embedded[0]["providers"].([]interface{})[0].(map[string]interface{})["Phase"].([]interface{})[0] = "changed"
+	want := types.TableContent{
+		{"id": "recA", "Phase": []interface{}{"1a"}},
+	}
+	if diff := cmp.Diff(want, linked); diff != "" {
+		t.Errorf("expected linked table unmodified: -want +got:\n %v\n", diff)
+	}
+}
+
+func TestLinkedRows(t *testing.T) {
+	index := indexByID(linkedTestData())
+	tests := []struct {
+		desc    string
+		link    interface{}
+		wantIDs []string
+	}{
+		{desc: "json list", link: []interface{}{"recA", "recB"}, wantIDs: []string{"recA", "recB"}},
+		{desc: "string list", link: []string{"recB"}, wantIDs: []string{"recB"}},
+		{desc: "bare string", link: "recA", wantIDs: []string{"recA"}},
+		{desc: "unknown ids skipped", link: []interface{}{"recX", "recA", 12}, wantIDs: []string{"recA"}},
+		{desc: "missing", link: nil},
+		{desc: "wrong type", link: 42.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var gotIDs []string
+			for _, r := range linkedRows(tt.link, index) {
+				gotIDs = append(gotIDs, r["id"].(string))
+			}
+			if diff := cmp.Diff(tt.wantIDs, gotIDs); diff != "" {
+				t.Errorf("-want +got:\n %v\n", diff)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package filter
 
 import (
 	"fmt"
+	"reflect"
 
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
 )
@@ -17,51 +18,91 @@ type xformCfg struct {
 	// expected that there will be a small number of them compared to fields, so
 	// it's (maybe) cheaper to just iterate through all the mungers instead of
 	// checking if every field has one.
-	mungers []Munger
+	mungers []mungerEntry
+	// tableMungers run on the whole table, after every row has been munged.
+	tableMungers []TableMunger
+	// errs are configuration errors from options, which Transform returns
+	// before doing any work.
+	errs []error
+}
+
+// mungerEntry is a Munger, and whether it follows the copy-on-write contract.
+type mungerEntry struct {
+	m Munger
+	// copyOnWrite is set for mungers which never modify the row they are
+	// given, and return a modified copy instead if they need to change it.
+	copyOnWrite bool
 }
 
 // Transform transforms a row based on the provided XformOpts.
+//
+// The input is never modified.  To avoid copying the whole table, rows are
+// only copied when a munger needs to change them: a row is deep-copied just
+// before the first WithMunger munger runs on it, and copy-on-write mungers
+// copy rows themselves.  Rows which no munger changed, and values selected by
+// a field map, are shared between the input and the output; callers must
+// treat both as read-only.
 func Transform(in types.TableContent, opts ...XformOpt) (types.TableContent, error) {
-	var out []map[string]interface{}
+	var out types.TableContent
 	var err error
 
 	var cfg xformCfg
 	for _, f := range opts {
 		f(&cfg)
 	}
+	if len(cfg.errs) > 0 {
+		return nil, cfg.errs[0]
+	}
 
-	// Make a copy of the source table so we don't modify the original data,
-	// which could cause havoc.
-	in = in.Clone()
+	if len(in) > 0 {
+		out = make(types.TableContent, 0, len(in))
+	}
 
 ROWS:
 	for i := range in { // For every row in the input
 		row := in[i]
-		for _, f := range cfg.mungers {
-			row, err = f(row)
+		// owned tracks if row is a copy that belongs to this Transform, and
+		// so may be modified; until then, it's shared with the input.
+		owned := false
+		for _, me := range cfg.mungers {
+			if !me.copyOnWrite && !owned {
+				row = types.CloneRow(row)
+				owned = true
+			}
+			prev := row
+			row, err = me.m(row)
 			if err != nil {
 				return nil, fmt.Errorf("error munging row %v: %v", i, err)
 			}
 			if row == nil {
 				continue ROWS
 			}
+			if !owned && !sameRow(prev, row) {
+				// A copy-on-write munger made a copy.
+				owned = true
+			}
 		}
 
-		new := map[string]interface{}{} // create an empty output row.
-
 		if len(cfg.fields) > 1 {
 			// If a field map is specified, use it to rename and filter.
-			// (There's always one entry for "id")
+			// (There's always one entry for "id")  Otherwise, keep
+			// everything.
+			new := make(map[string]interface{}, len(cfg.fields))
 			for k, v := range row {
 				if nk, ok := cfg.fields[k]; ok {
 					new[nk] = v
 				}
 			}
-		} else {
-			// Otherwise, keep everything.
-			new = row
+			row = new
+		}
+		out = append(out, row)
+	}
+
+	for _, f := range cfg.tableMungers {
+		out, err = f(out)
+		if err != nil {
+			return nil, fmt.Errorf("error munging table: %v", err)
 		}
-		out = append(out, new)
 	}
 
 	return out, checkFields(out, cfg.fields)
@@ -86,15 +127,50 @@ func WithFieldSlice(allowedKeys []string) XformOpt {
 	return WithFieldMap(keys)
 }
 
-// A Munger function accepts a single row, potentially copies it, modifies it,
-// and returns the row.  Because a row is a pointer to shared structure, be sure
-// to copy if you don't want to copy the input.
+// A Munger function accepts a single row, potentially modifies it, and
+// returns the row, or nil to drop the row from the output.  Mungers added with
+// WithMunger are given a private copy of the row, which they may modify
+// freely; mungers added with WithCopyOnWriteMunger must not modify the row
+// they are given, and instead return a modified copy (see types.CloneRow) if
+// they need to change it.
 // munge, verb: to manipulate or transform data (https://www.google.com/search?q=define+munge)
 type Munger func(in map[string]interface{}) (map[string]interface{}, error)
 
 // WithMunger configures the transformer to include a specific munge function.
+// Every row is copied before the munger is called on it, so it may modify the
+// row in place.
 func WithMunger(m Munger) XformOpt {
 	return func(cfg *xformCfg) {
-		cfg.mungers = append(cfg.mungers, m)
+		cfg.mungers = append(cfg.mungers, mungerEntry{m: m})
+	}
+}
+
+// WithCopyOnWriteMunger configures the transformer to include a munge
+// function which never modifies the row it's given; see Munger.  This avoids
+// copying rows which the munger doesn't change, such as for mungers which
+// only drop rows.
+func WithCopyOnWriteMunger(m Munger) XformOpt {
+	return func(cfg *xformCfg) {
+		cfg.mungers = append(cfg.mungers, mungerEntry{m: m, copyOnWrite: true})
+	}
+}
+
+// sameRow reports if a and b are the same map, rather than equal maps.
+func sameRow(a, b map[string]interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+// A TableMunger function accepts a whole table, after every row has been
+// munged and had its fields selected, and returns a potentially modified
+// table.  It is for transforms which need to look at more than one row at
+// once, like de-duplication.  Rows may be shared with Transform's input, so
+// must not be modified in place.
+type TableMunger func(in types.TableContent) (types.TableContent, error)
+
+// WithTableMunger configures the transformer to include a specific table munge
+// function.
+func WithTableMunger(m TableMunger) XformOpt {
+	return func(cfg *xformCfg) {
+		cfg.tableMungers = append(cfg.tableMungers, m)
 	}
 }
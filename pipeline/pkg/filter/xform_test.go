@@ -2,6 +2,7 @@ package filter
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -210,3 +211,162 @@ func TestTransformFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestTransformTableMunger(t *testing.T) {
+	// reverse is a test table munger which needs to see every row.
+	reverse := func(in types.TableContent) (types.TableContent, error) {
+		out := make(types.TableContent, 0, len(in))
+		for i := len(in) - 1; i >= 0; i-- {
+			out = append(out, in[i])
+		}
+		return out, nil
+	}
+	fail := func(in types.TableContent) (types.TableContent, error) {
+		return nil, errors.New("fail")
+	}
+
+	got, err := Transform(testData(), WithMunger(ucName), WithTableMunger(reverse), WithFieldSlice([]string{"Name"}))
+	if err != nil {
+		t.Fatalf("unexpected error from Transform: %v", err)
+	}
+	want := types.TableContent{
+		{"id": "2", "Name": "PFIZER"},
+		{"id": "1", "Name": "MODERNA"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n %v\n", diff)
+	}
+
+	if _, err := Transform(testData(), WithTableMunger(fail)); err == nil {
+		t.Errorf("want error from failing table munger, got nil")
+	}
+}
+
+func TestTransformCopyOnWrite(t *testing.T) {
+	in := types.TableContent{
+		{"id": "1", "Name": "Moderna", "List": []interface{}{"a"}},
+		{"id": "2", "Name": "Pfizer", "List": []interface{}{"b"}},
+	}
+	orig := in.Clone()
+
+	// keepAll is a copy-on-write munger which never changes anything.
+	keepAll := func(row map[string]interface{}) (map[string]interface{}, error) {
+		return row, nil
+	}
+	// ucCopy is a copy-on-write version of ucName, which changes only Pfizer.
+	ucCopy := func(row map[string]interface{}) (map[string]interface{}, error) {
+		if row["Name"] != "Pfizer" {
+			return row, nil
+		}
+		row = types.CloneRow(row)
+		row["Name"] = "PFIZER"
+		return row, nil
+	}
+	// appendList modifies a nested value in place.
+	appendList := func(row map[string]interface{}) (map[string]interface{}, error) {
+		l := row["List"].([]interface{})
+		l[0] = "changed"
+		return row, nil
+	}
+
+	got, err := Transform(in, WithCopyOnWriteMunger(keepAll), WithCopyOnWriteMunger(ucCopy))
+	if err != nil {
+		t.Fatalf("unexpected error from Transform: %v", err)
+	}
+	if !sameRow(in[0], got[0]) {
+		t.Errorf("unchanged row was copied")
+	}
+	if sameRow(in[1], got[1]) || got[1]["Name"] != "PFIZER" {
+		t.Errorf("changed row was not copied: %v", got[1])
+	}
+
+	// Once a copy-on-write munger has copied a row, later mungers don't need
+	// to copy it again, but must still not affect the input.
+	got, err = Transform(in, WithCopyOnWriteMunger(ucCopy), WithMunger(appendList))
+	if err != nil {
+		t.Fatalf("unexpected error from Transform: %v", err)
+	}
+	if got[1]["List"].([]interface{})[0] != "changed" {
+		t.Errorf("munger change lost: %v", got[1])
+	}
+
+	if diff := cmp.Diff(orig, in); diff != "" {
+		t.Errorf("expected input unmodified: -want +got:\n %v\n", diff)
+	}
+}
+
+// benchTable is a table shaped roughly like Locations, for benchmarks.
+func benchTable() types.TableContent {
+	tc := make(types.TableContent, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		row := map[string]interface{}{
+			"id":                  fmt.Sprintf("rec%d", i),
+			"Latest report yes?":  float64(i % 2),
+			"Latest report notes": []interface{}{"note"},
+		}
+		for f := 0; f < 40; f++ {
+			row[fmt.Sprintf("field %d", f)] = fmt.Sprintf("value %d", f)
+		}
+		tc = append(tc, row)
+	}
+	return tc
+}
+
+// BenchmarkTransformFieldSlice measures the common endpoint case, of only
+// selecting a few fields.
+func BenchmarkTransformFieldSlice(b *testing.B) {
+	tc := benchTable()
+	fields := []string{"field 1", "field 2", "field 3", "field 4", "Latest report notes"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Transform(tc, WithFieldSlice(fields)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchMungers returns a munger which drops nothing, and one which changes
+// half of the rows; they follow the copy-on-write contract.
+func benchMungers() (Munger, Munger) {
+	keep := func(row map[string]interface{}) (map[string]interface{}, error) {
+		return row, nil
+	}
+	hide := func(row map[string]interface{}) (map[string]interface{}, error) {
+		if row["Latest report yes?"] == 1.0 {
+			return row, nil
+		}
+		row = types.CloneRow(row)
+		row["Latest report notes"] = ""
+		return row, nil
+	}
+	return keep, hide
+}
+
+// BenchmarkTransformMungers measures mungers which are given a copy of every
+// row.
+func BenchmarkTransformMungers(b *testing.B) {
+	tc := benchTable()
+	keep, hide := benchMungers()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Transform(tc, WithMunger(keep), WithMunger(hide)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTransformCopyOnWriteMungers measures the same mungers as
+// BenchmarkTransformMungers, copying only the rows they change.
+func BenchmarkTransformCopyOnWriteMungers(b *testing.B) {
+	tc := benchTable()
+	keep, hide := benchMungers()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Transform(tc, WithCopyOnWriteMunger(keep), WithCopyOnWriteMunger(hide)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package geojson converts tables of locations into GeoJSON (RFC 7946)
+// FeatureCollections, so map clients don't have to do it themselves.
+package geojson
+
+import (
+	"context"
+	"log"
+	"math"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// FormatName is the name GeoJSON is registered under as a storage format.
+const FormatName = "geojson"
+
+// ContentType is the registered media type of GeoJSON.
+const ContentType = "application/geo+json"
+
+// The fields coordinates are read from.
+const (
+	LatitudeField  = "Latitude"
+	LongitudeField = "Longitude"
+)
+
+// FeatureCollection is a GeoJSON FeatureCollection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON Feature, with a Point geometry.
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id,omitempty"`
+	Geometry   Point                  `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON Point geometry.  Coordinates are longitude, then
+// latitude.
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// FromTable returns a FeatureCollection with a Point feature for every row
+// with valid coordinates, and the number of rows which were skipped because
+// they didn't have them.  Each feature's properties are all of the row's
+// fields other than "id", which is the feature's id.
+func FromTable(table types.TableContent) (*FeatureCollection, int) {
+	fc := &FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]Feature, 0, len(table)),
+	}
+	skipped := 0
+	for _, row := range table {
+		lat, lng, ok := Coordinates(row)
+		if !ok {
+			skipped++
+			continue
+		}
+		props := make(map[string]interface{}, len(row))
+		for k, v := range row {
+			if k != "id" {
+				props[k] = v
+			}
+		}
+		fc.Features = append(fc.Features, Feature{
+			Type: "Feature",
+			ID:   row["id"],
+			Geometry: Point{
+				Type:        "Point",
+				Coordinates: [2]float64{lng, lat},
+			},
+			Properties: props,
+		})
+	}
+	return fc, skipped
+}
+
+// Coordinates returns the latitude and longitude of a row, and whether they
+// are valid.  Missing, out of range, and (0, 0) coordinates are not valid;
+// the latter is what an unset location in Airtable usually turns into.
+func Coordinates(row map[string]interface{}) (float64, float64, bool) {
+	lat, ok := row[LatitudeField].(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	lng, ok := row[LongitudeField].(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	if math.IsNaN(lat) || math.IsNaN(lng) || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return 0, 0, false
+	}
+	if lat == 0 && lng == 0 {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+func init() {
+	storage.RegisterFormat(&storage.Format{
+		Name:        FormatName,
+		Extension:   ".geojson",
+		ContentType: ContentType,
+		Encode:      encode,
+	})
+}
+
+// encode is the storage format's encoder.  Rows without valid coordinates
+// are left out, and counted in the run report.
+func encode(ctx context.Context, p storage.Payload) ([]byte, error) {
+	fc, skipped := FromTable(p.Table)
+	report.FromContext(ctx).Add("geojson_skipped."+p.Name, skipped)
+	if skipped > 0 {
+		log.Printf("[%s] Left %d rows without valid coordinates out of the GeoJSON\n", p.Name, skipped)
+	}
+	buf, err := storage.Serialize(fc)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,61 @@
+package geojson
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFromTable(t *testing.T) {
+	in := types.TableContent{
+		{"id": "rec1", "Name": "Ok", "Latitude": 37.77, "Longitude": -122.42},
+		{"id": "rec2", "Name": "Missing", "Latitude": 37.77},
+		{"id": "rec3", "Name": "Zero", "Latitude": 0.0, "Longitude": 0.0},
+		{"id": "rec4", "Name": "Out of range", "Latitude": 137.77, "Longitude": -122.42},
+		{"id": "rec5", "Name": "Not a number", "Latitude": "37.77", "Longitude": "-122.42"},
+		{"id": "rec6", "Name": "NaN", "Latitude": math.NaN(), "Longitude": -122.42},
+	}
+
+	rep := report.New()
+	ctx := report.NewContext(context.Background(), rep)
+	enc, err := storage.Encode(ctx, FormatName, storage.Payload{Name: "1/test", Table: in})
+	if err != nil {
+		t.Fatalf("unexpected error from Encode: %v", err)
+	}
+	if got := rep.Count("geojson_skipped.1/test"); got != 5 {
+		t.Errorf("got %d skipped, want 5", got)
+	}
+	if enc.ContentType != "application/geo+json" || enc.Extension != ".geojson" {
+		t.Errorf("got content type %q, extension %q", enc.ContentType, enc.Extension)
+	}
+	got := enc.Data
+	want := `{"type":"FeatureCollection","features":[{"type":"Feature","id":"rec1",` +
+		`"geometry":{"type":"Point","coordinates":[-122.42,37.77]},` +
+		`"properties":{"Latitude":37.77,"Longitude":-122.42,"Name":"Ok"}}]}`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+
+	// The input isn't modified.
+	if _, ok := in[0]["id"]; !ok {
+		t.Errorf("id was removed from the input row")
+	}
+}
+
+func TestFromTableEmpty(t *testing.T) {
+	fc, skipped := FromTable(nil)
+	b, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// An empty collection still has a features list.
+	if string(b) != `{"type":"FeatureCollection","features":[]}` || skipped != 0 {
+		t.Errorf("got %s, %d skipped", b, skipped)
+	}
+}
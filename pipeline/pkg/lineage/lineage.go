@@ -0,0 +1,142 @@
+// Package lineage describes where each published field comes from: the
+// Airtable table and column, and the mungers and transforms which are applied
+// to it on the way.  It answers "where does this field come from?" without
+// reading the endpoint and airtable code by hand.
+package lineage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+)
+
+// recordID is the source column of the "id" field, which every endpoint has.
+const recordID = "(Airtable record ID)"
+
+// Transform is a munger or other transform applied on the way to the output.
+type Transform struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Field is the lineage of a single output field.
+type Field struct {
+	// Name is the field's name in the output.
+	Name string `json:"name"`
+	// Table and Column are where the field's value comes from in Airtable.
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	// Transforms are applied to the field's value, in order.
+	Transforms []Transform `json:"transforms"`
+}
+
+// Lineage is the lineage of every field of an endpoint.
+type Lineage struct {
+	Endpoint string `json:"endpoint"`
+	Table    string `json:"table"`
+	// RowTransforms apply to whole rows, e.g. by dropping them; they affect
+	// every field.
+	RowTransforms []Transform `json:"rowTransforms"`
+	Fields        []Field     `json:"fields"`
+}
+
+// For returns the lineage of an endpoint.
+func For(ep endpoints.Endpoint) Lineage {
+	l := Lineage{
+		Endpoint:      ep.String(),
+		Table:         ep.Table,
+		RowTransforms: []Transform{},
+	}
+
+	fieldMungers := map[string][]Transform{}
+	for _, m := range airtable.TableMungers(ep.Table) {
+		t := Transform{Name: m.Name, Description: m.Description}
+		if len(m.Fields) == 0 {
+			l.RowTransforms = append(l.RowTransforms, t)
+			continue
+		}
+		for _, f := range m.Fields {
+			fieldMungers[f] = append(fieldMungers[f], t)
+		}
+	}
+
+	l.Fields = append(l.Fields, Field{
+		Name:       "id",
+		Table:      ep.Table,
+		Column:     recordID,
+		Transforms: []Transform{},
+	})
+	for _, f := range ep.Fields {
+		table := ep.Table
+		var ts []Transform
+		if f.Table != "" {
+			// The mungers of the endpoint's table don't apply to
+			// fields from linked records; those of the linked table
+			// already have.
+			table = f.Table
+			ts = append(ts, Transform{
+				Name:        "link",
+				Description: fmt.Sprintf("Copied from the %s record linked by %q.", f.Table, f.Link),
+			})
+		} else {
+			ts = append([]Transform{}, fieldMungers[f.Column]...)
+		}
+		if f.Name != f.Column {
+			ts = append(ts, Transform{
+				Name:        "rename",
+				Description: fmt.Sprintf("Renamed from %q.", f.Column),
+			})
+		}
+		l.Fields = append(l.Fields, Field{
+			Name:       f.Name,
+			Table:      table,
+			Column:     f.Column,
+			Transforms: ts,
+		})
+	}
+	return l
+}
+
+// MarkdownContentType is the content type of the Markdown lineage.
+const MarkdownContentType = "text/markdown; charset=utf-8"
+
+// Markdown renders the lineage as a Markdown document, with a table of every
+// field, ready to be given to a StorageWriter.
+func (l Lineage) Markdown() storage.Encoded {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# Field lineage for %s\n\n", l.Endpoint)
+	fmt.Fprintf(b, "Generated from the Airtable table **%s**.\n\n", l.Table)
+
+	if len(l.RowTransforms) > 0 {
+		b.WriteString("Every row is first processed by:\n\n")
+		for _, t := range l.RowTransforms {
+			fmt.Fprintf(b, "1. `%s`: %s\n", t.Name, t.Description)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("| Field | Airtable table | Airtable column | Transforms |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, f := range l.Fields {
+		ts := make([]string, len(f.Transforms))
+		for i, t := range f.Transforms {
+			ts[i] = fmt.Sprintf("`%s`: %s", t.Name, t.Description)
+		}
+		fmt.Fprintf(b, "| %s | %s | %s | %s |\n",
+			cell(f.Name), cell(f.Table), cell(f.Column), cell(strings.Join(ts, "<br>")))
+	}
+	return storage.Encoded{
+		Data:        b.Bytes(),
+		ContentType: MarkdownContentType,
+		Extension:   ".md",
+	}
+}
+
+// cell escapes a value for use in a Markdown table cell.
+func cell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
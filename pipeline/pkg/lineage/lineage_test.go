@@ -0,0 +1,101 @@
+package lineage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFor(t *testing.T) {
+	ep := endpoints.Endpoint{
+		Version:  "1",
+		Resource: "locations",
+		Table:    "Locations",
+		Fields: []endpoints.Field{
+			{Column: "Name", Name: "name"},
+			{Column: "Latest report notes", Name: "Latest report notes"},
+			{Column: "Notes", Name: "County notes", Table: "Counties", Link: "County link"},
+		},
+	}
+	got := For(ep)
+
+	if got.Endpoint != "1/locations" || got.Table != "Locations" {
+		t.Errorf("got endpoint %q table %q", got.Endpoint, got.Table)
+	}
+	var rowNames []string
+	for _, r := range got.RowTransforms {
+		rowNames = append(rowNames, r.Name)
+	}
+	if diff := cmp.Diff([]string{"dropEmpty", "dropSoftDeleted", "dedupLocations"}, rowNames); diff != "" {
+		t.Errorf("row transforms: -want +got:\n%v\n", diff)
+	}
+
+	fieldTransforms := map[string][]string{}
+	for _, f := range got.Fields {
+		wantTable := "Locations"
+		if f.Name == "County notes" {
+			wantTable = "Counties"
+		}
+		if f.Table != wantTable {
+			t.Errorf("field %q: got table %q, want %q", f.Name, f.Table, wantTable)
+		}
+		names := []string{}
+		for _, t := range f.Transforms {
+			names = append(names, t.Name)
+		}
+		fieldTransforms[f.Name+" <- "+f.Column] = names
+	}
+	want := map[string][]string{
+		"id <- " + recordID: {},
+		"name <- Name":      {"rename"},
+		"Latest report notes <- Latest report notes": {"hideNotes", "notesScrubber"},
+		"County notes <- Notes":                      {"link", "rename"},
+	}
+	if diff := cmp.Diff(want, fieldTransforms); diff != "" {
+		t.Errorf("fields: -want +got:\n%v\n", diff)
+	}
+}
+
+func TestForAllEndpoints(t *testing.T) {
+	for _, ep := range endpoints.AllEndpoints() {
+		t.Run(ep.String(), func(t *testing.T) {
+			l := For(ep)
+			// Every endpoint is described, with at least "id" and one other
+			// field.
+			if len(l.Fields) < 2 {
+				t.Errorf("got %d fields, want at least 2", len(l.Fields))
+			}
+			if len(l.RowTransforms) == 0 {
+				t.Errorf("no row transforms for table %q; is it missing from airtable.TableMungers?", l.Table)
+			}
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	ep := endpoints.EndpointMap[deploys.LegacyVersion]["Locations"]
+	l := For(endpoints.Endpoint{
+		Version:  deploys.LegacyVersion,
+		Resource: "Locations",
+		Table:    ep.Table,
+		Fields:   ep.Fields,
+	})
+	enc := l.Markdown()
+	md := string(enc.Data)
+
+	for _, want := range []string{
+		"# Field lineage for LEGACY/Locations\n",
+		"1. `dropSoftDeleted`: ",
+		"| Appointment scheduling instructions | Locations | Appointment scheduling instructions | `useCountyURL`: ",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown does not contain %q:\n%s", want, md)
+		}
+	}
+	if !strings.HasPrefix(enc.ContentType, "text/markdown") || enc.Extension != ".md" {
+		t.Errorf("got content type %q, extension %q", enc.ContentType, enc.Extension)
+	}
+}
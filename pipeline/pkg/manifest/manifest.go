@@ -0,0 +1,47 @@
+// Package manifest describes the index file published for each API version,
+// which lists every resource with enough information for clients, and the
+// freshness monitor, to decide whether to fetch it again.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Name is the base name of the index file, in each version's directory.
+const Name = "index"
+
+// Index lists every resource of an API version.
+type Index struct {
+	Version string `json:"version"`
+	// Generated is when the run which wrote the index started.
+	Generated time.Time `json:"generated"`
+	// Commit is the git commit of the pipeline which wrote the index.
+	Commit    string     `json:"commit"`
+	Resources []Resource `json:"resources"`
+}
+
+// Resource describes the JSON output of one resource.
+type Resource struct {
+	Resource string `json:"resource"`
+	URL      string `json:"url"`
+	// Failed is set if the resource failed to publish in this run, in
+	// which case the file is left over from an earlier run, and the fields
+	// below are not set.
+	Failed bool `json:"failed,omitempty"`
+	// Pinned is set if the resource is pinned to an earlier run's output
+	// by an operator, in which case the fields below are not set either.
+	Pinned    bool       `json:"pinned,omitempty"`
+	Rows      int        `json:"rows,omitempty"`
+	Bytes     int        `json:"bytes,omitempty"`
+	SHA256    string     `json:"sha256,omitempty"`
+	Generated *time.Time `json:"generated,omitempty"`
+	Commit    string     `json:"commit,omitempty"`
+}
+
+// SHA256 returns the hex-encoded SHA-256 of the data, as used in Resource.
+func SHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
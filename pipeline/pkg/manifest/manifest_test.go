@@ -0,0 +1,27 @@
+package manifest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSHA256(t *testing.T) {
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", SHA256(nil))
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", SHA256([]byte("hello")))
+}
+
+func TestFailedResource(t *testing.T) {
+	// A failed resource carries nothing but its name and URL.
+	b, err := json.Marshal(Resource{Resource: "locations", URL: "https://example.com/locations.json", Failed: true})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"resource":"locations","url":"https://example.com/locations.json","failed":true}`, string(b))
+
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	b, err = json.Marshal(Resource{Resource: "locations", Rows: 2, Generated: &now})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"resource":"locations","url":"","rows":2,"generated":"2021-03-01T12:00:00Z"}`, string(b))
+}
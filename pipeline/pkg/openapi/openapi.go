@@ -0,0 +1,216 @@
+// Package openapi generates an OpenAPI description of the static API, from
+// every endpoint in endpoints.EndpointMap and the schemas of their output.
+package openapi
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/config"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/schema"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+)
+
+// Version is the version of the OpenAPI specification generated documents
+// follow; 3.1 is the first which uses JSON Schema 2020-12 for schemas.
+const Version = "3.1.0"
+
+// ContentType is the media type of OpenAPI documents.
+const ContentType = "application/vnd.oai.openapi+json"
+
+// Document is an OpenAPI document.  Only the parts which describe a static,
+// read-only API are included.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []Server             `json:"servers"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info is the API's metadata.
+type Info struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Version     string  `json:"version"`
+	Contact     Contact `json:"contact"`
+}
+
+// Contact is who to contact about the API.
+type Contact struct {
+	Email string `json:"email"`
+}
+
+// Server is a base URL that paths are relative to.
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem describes a single file.  Files which aren't relative to the
+// document's servers have their own.
+type PathItem struct {
+	Servers []Server   `json:"servers,omitempty"`
+	Get     *Operation `json:"get"`
+}
+
+// Operation describes fetching a file.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Tags        []string             `json:"tags"`
+	Deprecated  bool                 `json:"deprecated,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Response describes the content of a file.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// MediaType describes one encoding of a response.
+type MediaType struct {
+	Schema *Ref `json:"schema,omitempty"`
+}
+
+// Ref refers to a schema in the document's components.
+type Ref struct {
+	Ref string `json:"$ref"`
+}
+
+// Components holds the schemas of every endpoint.
+type Components struct {
+	Schemas map[string]*schema.Schema `json:"schemas"`
+}
+
+// Build returns the description of every endpoint, as published in the
+// current deploy.  Each endpoint's JSON response is described by its entry
+// in schemas, keyed by the endpoint's String(); endpoints without one, e.g.
+// because they failed to generate, are described without a schema.
+func Build(eps []endpoints.Endpoint, schemas map[string]*schema.Schema) (*Document, error) {
+	root, err := deploys.GetAPIDownloadURL()
+	if err != nil {
+		return nil, err
+	}
+	rootServer, err := serverOf(root)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       "VaccinateCA API",
+			Description: "Static files describing COVID-19 vaccine availability in California, regenerated every few minutes.  Each file is described as a GET of its path.",
+			Version:     config.GitCommit,
+			Contact:     Contact{Email: "api@vaccinateca.com"},
+		},
+		Servers:    []Server{{URL: rootServer}},
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*schema.Schema{}},
+	}
+
+	// Sort, so the document is the same every time.
+	sorted := append([]endpoints.Endpoint{}, eps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+	for _, ep := range sorted {
+		base, err := deploys.GetDownloadURL(ep.Version)
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(base + "/" + ep.Resource)
+		if err != nil {
+			return nil, err
+		}
+		server := u.Scheme + "://" + u.Host
+
+		var ref *Ref
+		if s, ok := schemas[ep.String()]; ok {
+			name := componentName(ep)
+			// The components share the document's dialect, so don't
+			// repeat it.
+			c := *s
+			c.Schema = ""
+			doc.Components.Schemas[name] = &c
+			ref = &Ref{Ref: "#/components/schemas/" + name}
+		}
+
+		for _, format := range ep.Formats {
+			f, err := storage.LookupFormat(format)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", &ep, err)
+			}
+			mt := MediaType{}
+			if format == storage.FormatJSON {
+				mt.Schema = ref
+			}
+			item := &PathItem{
+				Get: &Operation{
+					OperationID: componentName(ep) + "." + format,
+					Summary:     fmt.Sprintf("%s, as %s", ep.Resource, format),
+					Tags:        []string{versionTag(ep.Version)},
+					Deprecated:  ep.Version == deploys.LegacyVersion,
+					Responses: map[string]*Response{
+						"200": {
+							Description: fmt.Sprintf("Every row of %s.", ep.Resource),
+							Content:     map[string]MediaType{mediaType(f.ContentType): mt},
+						},
+					},
+				},
+			}
+			if server != rootServer {
+				item.Servers = []Server{{URL: server}}
+			}
+			doc.Paths[u.Path+f.Extension] = item
+		}
+	}
+	return doc, nil
+}
+
+// serverOf returns the scheme and host of a URL.
+func serverOf(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// componentName returns the name of an endpoint's schema in the document's
+// components, which may only contain letters, digits, ".", "-" and "_".
+func componentName(ep endpoints.Endpoint) string {
+	return versionTag(ep.Version) + "." + ep.Resource
+}
+
+// versionTag returns the name of a version, as used in the document.
+func versionTag(v deploys.VersionType) string {
+	if v == deploys.LegacyVersion {
+		return "legacy"
+	}
+	return "v" + string(v)
+}
+
+// mediaType strips any parameters from a content type.
+func mediaType(contentType string) string {
+	return strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+}
+
+// Encode returns the document, ready to be given to a StorageWriter.
+func (d *Document) Encode() (storage.Encoded, error) {
+	buf, err := storage.Serialize(d)
+	if err != nil {
+		return storage.Encoded{}, err
+	}
+	return storage.Encoded{
+		Data:        buf.Bytes(),
+		ContentType: ContentType,
+		Extension:   ".json",
+	}, nil
+}
@@ -0,0 +1,107 @@
+package openapi
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/schema"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	os.Setenv("DEPLOY", "prod")
+	t.Cleanup(func() {
+		os.Unsetenv("DEPLOY")
+	})
+
+	eps := []endpoints.Endpoint{
+		{
+			Version:  "1",
+			Resource: "locations",
+			Fields:   []endpoints.Field{{Column: "Name", Name: "Name"}},
+			Formats:  []string{storage.FormatJSON, storage.FormatCSV},
+		},
+		{
+			Version:  deploys.LegacyVersion,
+			Resource: "Locations",
+			Fields:   []endpoints.Field{{Column: "Name", Name: "Name"}},
+			Formats:  []string{storage.FormatJSON},
+		},
+	}
+	table := types.TableContent{{"id": "rec1", "Name": "A"}}
+	schemas := map[string]*schema.Schema{
+		"1/locations": schema.ForPayload(eps[0].Payload(table, metadata.Run{}, time.Time{})),
+		// LEGACY/Locations failed, so has no schema.
+	}
+
+	doc, err := Build(eps, schemas)
+	require.NoError(t, err)
+
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+	assert.Equal(t, []Server{{URL: "https://api.vaccinateca.com"}}, doc.Servers)
+
+	var paths []string
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	assert.ElementsMatch(t, []string{
+		"/v1/locations.json",
+		"/v1/locations.csv",
+		"/cavaccineinventory-sitedata/airtable-sync/Locations.json",
+	}, paths)
+
+	v1 := doc.Paths["/v1/locations.json"]
+	assert.Empty(t, v1.Servers)
+	assert.Equal(t, &Ref{Ref: "#/components/schemas/v1.locations"}, v1.Get.Responses["200"].Content["application/json"].Schema)
+	assert.False(t, v1.Get.Deprecated)
+
+	csv := doc.Paths["/v1/locations.csv"]
+	assert.Contains(t, csv.Get.Responses["200"].Content, "text/csv")
+
+	legacy := doc.Paths["/cavaccineinventory-sitedata/airtable-sync/Locations.json"]
+	assert.Equal(t, []Server{{URL: "https://storage.googleapis.com"}}, legacy.Servers)
+	assert.Nil(t, legacy.Get.Responses["200"].Content["application/json"].Schema)
+	assert.True(t, legacy.Get.Deprecated)
+
+	// The component is the endpoint's schema, including the envelope,
+	// without repeating the dialect.
+	s := doc.Components.Schemas["v1.locations"]
+	require.NotNil(t, s)
+	assert.Empty(t, s.Schema)
+	assert.Contains(t, s.Properties, "usage")
+	assert.Equal(t, schema.Draft, schemas["1/locations"].Schema, "the input schema was modified")
+}
+
+func TestBuildAllEndpoints(t *testing.T) {
+	doc, err := Build(endpoints.AllEndpoints(), nil)
+	require.NoError(t, err)
+	enc, err := doc.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.oai.openapi+json", enc.ContentType)
+
+	// Every endpoint's JSON is described.
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(enc.Data, &got))
+	for _, ep := range endpoints.AllEndpoints() {
+		u, err := ep.URL()
+		require.NoError(t, err)
+		found := false
+		for p := range doc.Paths {
+			if strings.HasSuffix(u, p) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s (%s) is not described", &ep, u)
+		}
+	}
+}
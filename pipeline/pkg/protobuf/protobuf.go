@@ -0,0 +1,463 @@
+// Package protobuf publishes endpoints as Protocol Buffers, for clients which
+// want compact binary payloads, with a .proto definition of each endpoint
+// generated from its typed fields.
+//
+// Each endpoint's output is a Response message, in a package of its own, of
+// the usage notice and metadata, if its JSON has them, and a Record for
+// every row.  Fields which are unset in Airtable are left out of a Record,
+// as they are from the JSON.
+package protobuf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// FormatName is the name protobuf is registered under as a storage format.
+const FormatName = "protobuf"
+
+// ContentType is the media type of protobuf output.
+const ContentType = "application/x-protobuf"
+
+// DefinitionFormatName is the name the .proto definitions are registered
+// under as a storage format.
+const DefinitionFormatName = "proto"
+
+// DefinitionContentType is the media type of .proto definitions.
+const DefinitionContentType = "text/plain; charset=utf-8"
+
+// Field numbers of the messages which every endpoint shares.
+const (
+	responseUsage    = 1
+	responseMetadata = 2
+	responseContent  = 3
+
+	usageNotice  = 1
+	usageContact = 2
+
+	contactPartnersEmail = 1
+
+	metadataGenerated      = 1
+	metadataSourceSnapshot = 2
+	metadataCommit         = 3
+	metadataRunID          = 4
+	metadataRecords        = 5
+	metadataAPIVersion     = 6
+	metadataDeprecation    = 7
+)
+
+// Field is a field of a Record.
+type Field struct {
+	Number protowire.Number
+	// Name is the field's name in the .proto definition.
+	Name string
+	// Column is the field's name in the JSON output.
+	Column string
+	Type   types.FieldType
+}
+
+// File describes the .proto definition of an endpoint.
+type File struct {
+	// Title is the endpoint the definition is of.
+	Title   string
+	Package string
+	// Wrapped is set if the Response has the usage notice.
+	Wrapped bool
+	// Metadata is set if the Response has metadata.
+	Metadata bool
+	// Fields are the fields of a Record, in order.
+	Fields []Field
+}
+
+// ForPayload returns the definition of an endpoint's output.  A Record's
+// fields are numbered in order of the endpoint's columns, starting with
+// "id", so new columns must only be added at the end.
+func ForPayload(p storage.Payload) (*File, error) {
+	resp, wrapped := p.Data.(metadata.APIResponse)
+	f := &File{
+		Title:    p.Name,
+		Package:  packageName(p.Name),
+		Wrapped:  wrapped,
+		Metadata: wrapped && resp.Metadata != nil,
+		Fields:   make([]Field, len(p.Columns)),
+	}
+	names := map[string]string{}
+	for i, c := range p.Columns {
+		t, ok := p.Types[c]
+		if !ok {
+			return nil, fmt.Errorf("column %q has no type", c)
+		}
+		name := fieldName(c)
+		if name == "" {
+			return nil, fmt.Errorf("column %q has no usable field name", c)
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("columns %q and %q both have field name %q", other, c, name)
+		}
+		names[name] = c
+		f.Fields[i] = Field{Number: protowire.Number(i + 1), Name: name, Column: c, Type: t}
+	}
+	return f, nil
+}
+
+var nonIdentifier = regexp.MustCompile(`[^a-z0-9]+`)
+
+// fieldName returns the snake_case field name of a column, e.g.
+// "latest_report_yes" for "Latest report yes?".
+func fieldName(column string) string {
+	name := strings.Trim(nonIdentifier.ReplaceAllString(strings.ToLower(column), "_"), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "f_" + name
+	}
+	return name
+}
+
+// packageName returns the package of an endpoint, given its name, e.g.
+// "vaccinateca.v1.locations" for "1/locations".
+func packageName(endpoint string) string {
+	version, resource := "", endpoint
+	if i := strings.Index(endpoint, "/"); i >= 0 {
+		version, resource = strings.ToLower(endpoint[:i]), endpoint[i+1:]
+	}
+	if version != "legacy" {
+		version = "v" + version
+	}
+	return "vaccinateca." + fieldName(version) + "." + fieldName(resource)
+}
+
+// Definition returns the .proto definition.
+func (f *File) Definition() []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "// Code generated from the fields of the %s endpoint.  DO NOT EDIT.\n\n", f.Title)
+	b.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(b, "package %s;\n\n", f.Package)
+
+	b.WriteString("// Response is the whole of the endpoint's output.\n")
+	b.WriteString("message Response {\n")
+	if f.Wrapped {
+		fmt.Fprintf(b, "  Usage usage = %d;\n", responseUsage)
+	}
+	if f.Metadata {
+		fmt.Fprintf(b, "  Metadata metadata = %d;\n", responseMetadata)
+	}
+	fmt.Fprintf(b, "  repeated Record content = %d;\n", responseContent)
+	b.WriteString("}\n\n")
+
+	if f.Wrapped {
+		b.WriteString("// Usage is the notice of how the data may be used, and who to contact\n")
+		b.WriteString("// about using it.\n")
+		b.WriteString("message Usage {\n")
+		fmt.Fprintf(b, "  string notice = %d;\n", usageNotice)
+		fmt.Fprintf(b, "  Contact contact = %d;\n", usageContact)
+		b.WriteString("}\n\n")
+		b.WriteString("// Contact is who to contact about using the data.\n")
+		b.WriteString("message Contact {\n")
+		fmt.Fprintf(b, "  string partners_email = %d;\n", contactPartnersEmail)
+		b.WriteString("}\n\n")
+	}
+	if f.Metadata {
+		b.WriteString("// Metadata describes how and when the response was generated.  Times\n")
+		b.WriteString("// are in RFC 3339 format.\n")
+		b.WriteString("message Metadata {\n")
+		fmt.Fprintf(b, "  string generated = %d;\n", metadataGenerated)
+		fmt.Fprintf(b, "  string source_snapshot = %d;\n", metadataSourceSnapshot)
+		fmt.Fprintf(b, "  string commit = %d;\n", metadataCommit)
+		fmt.Fprintf(b, "  string run_id = %d;\n", metadataRunID)
+		fmt.Fprintf(b, "  int32 records = %d;\n", metadataRecords)
+		fmt.Fprintf(b, "  string api_version = %d;\n", metadataAPIVersion)
+		fmt.Fprintf(b, "  string deprecation = %d;\n", metadataDeprecation)
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// Record is a row of the content.  Fields which are unset in Airtable are\n")
+	b.WriteString("// left out.  Their JSON names are those of the JSON output.\n")
+	b.WriteString("message Record {\n")
+	for _, fd := range f.Fields {
+		fmt.Fprintf(b, "  %s %s = %d [json_name = %q];\n", fieldType(fd), fd.Name, fd.Number, fd.Column)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// Descriptor returns the descriptor of the .proto definition, for reading
+// the output with the protobuf reflection packages.
+func (f *File) Descriptor() *descriptorpb.FileDescriptorProto {
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		str      = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		double   = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+		int32_   = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		message  = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	field := func(name string, num protowire.Number, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(int32(num)),
+			Label:  label.Enum(),
+			Type:   typ.Enum(),
+		}
+		if typeName != "" {
+			fd.TypeName = proto.String("." + f.Package + "." + typeName)
+		}
+		return fd
+	}
+
+	response := &descriptorpb.DescriptorProto{Name: proto.String("Response")}
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(strings.ReplaceAll(f.Package, ".", "/") + ".proto"),
+		Package: proto.String(f.Package),
+		Syntax:  proto.String("proto3"),
+	}
+	fdp.MessageType = append(fdp.MessageType, response)
+	if f.Wrapped {
+		response.Field = append(response.Field, field("usage", responseUsage, optional, message, "Usage"))
+		fdp.MessageType = append(fdp.MessageType,
+			&descriptorpb.DescriptorProto{
+				Name: proto.String("Usage"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("notice", usageNotice, optional, str, ""),
+					field("contact", usageContact, optional, message, "Contact"),
+				},
+			},
+			&descriptorpb.DescriptorProto{
+				Name: proto.String("Contact"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("partners_email", contactPartnersEmail, optional, str, ""),
+				},
+			})
+	}
+	if f.Metadata {
+		response.Field = append(response.Field, field("metadata", responseMetadata, optional, message, "Metadata"))
+		fdp.MessageType = append(fdp.MessageType, &descriptorpb.DescriptorProto{
+			Name: proto.String("Metadata"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("generated", metadataGenerated, optional, str, ""),
+				field("source_snapshot", metadataSourceSnapshot, optional, str, ""),
+				field("commit", metadataCommit, optional, str, ""),
+				field("run_id", metadataRunID, optional, str, ""),
+				field("records", metadataRecords, optional, int32_, ""),
+				field("api_version", metadataAPIVersion, optional, str, ""),
+				field("deprecation", metadataDeprecation, optional, str, ""),
+			},
+		})
+	}
+	response.Field = append(response.Field, field("content", responseContent, repeated, message, "Record"))
+
+	// Optional fields are each in a synthetic oneof, as protoc does.
+	record := &descriptorpb.DescriptorProto{Name: proto.String("Record")}
+	for _, c := range f.Fields {
+		var fd *descriptorpb.FieldDescriptorProto
+		switch t := fieldType(c); t {
+		case "repeated string":
+			fd = field(c.Name, c.Number, repeated, str, "")
+		case "string":
+			fd = field(c.Name, c.Number, optional, str, "")
+		default:
+			typ := str
+			if t == "optional double" {
+				typ = double
+			}
+			fd = field(c.Name, c.Number, optional, typ, "")
+			fd.Proto3Optional = proto.Bool(true)
+			fd.OneofIndex = proto.Int32(int32(len(record.OneofDecl)))
+			record.OneofDecl = append(record.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + c.Name)})
+		}
+		fd.JsonName = proto.String(c.Column)
+		record.Field = append(record.Field, fd)
+	}
+	fdp.MessageType = append(fdp.MessageType, record)
+	return fdp
+}
+
+// fieldType returns the type of a Record field, with its label.  The id is
+// always set, so isn't optional.
+func fieldType(fd Field) string {
+	switch {
+	case fd.Type == types.StringList:
+		return "repeated string"
+	case fd.Column == "id":
+		return "string"
+	case fd.Type == types.Number:
+		return "optional double"
+	default:
+		return "optional string"
+	}
+}
+
+// Marshal returns the payload encoded as a Response.
+func (f *File) Marshal(p storage.Payload) ([]byte, error) {
+	var b []byte
+	if resp, ok := p.Data.(metadata.APIResponse); ok && f.Wrapped {
+		var contact, usage []byte
+		contact = appendString(contact, contactPartnersEmail, resp.Usage.Contact.PartnersEmail)
+		usage = appendString(usage, usageNotice, resp.Usage.Notice)
+		usage = appendMessage(usage, usageContact, contact)
+		b = appendMessage(b, responseUsage, usage)
+
+		if md := resp.Metadata; md != nil && f.Metadata {
+			var m []byte
+			m = appendString(m, metadataGenerated, md.Generated.Format(time.RFC3339Nano))
+			m = appendString(m, metadataSourceSnapshot, md.SourceSnapshot.Format(time.RFC3339Nano))
+			m = appendString(m, metadataCommit, md.Commit)
+			m = appendString(m, metadataRunID, md.RunID)
+			if md.Records != 0 {
+				m = protowire.AppendTag(m, metadataRecords, protowire.VarintType)
+				m = protowire.AppendVarint(m, uint64(md.Records))
+			}
+			m = appendString(m, metadataAPIVersion, md.APIVersion)
+			m = appendString(m, metadataDeprecation, md.Deprecation)
+			b = appendMessage(b, responseMetadata, m)
+		}
+	}
+
+	for i, row := range p.Table {
+		r, err := f.appendRecord(nil, row)
+		if err != nil {
+			return nil, fmt.Errorf("row %d (%v): %w", i, row["id"], err)
+		}
+		b = appendMessage(b, responseContent, r)
+	}
+	return b, nil
+}
+
+// appendRecord appends a row, encoded as a Record.
+func (f *File) appendRecord(b []byte, row map[string]interface{}) ([]byte, error) {
+	seen := 0
+	for _, fd := range f.Fields {
+		v, ok := row[fd.Column]
+		if !ok {
+			continue
+		}
+		seen++
+		if v == nil {
+			return nil, fmt.Errorf("field %q is null", fd.Column)
+		}
+		switch fd.Type {
+		case types.String:
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("field %q is a %T, not a string", fd.Column, v)
+			}
+			b = protowire.AppendTag(b, fd.Number, protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		case types.Number:
+			n, ok := number(v)
+			if !ok {
+				return nil, fmt.Errorf("field %q is a %T, not a number", fd.Column, v)
+			}
+			b = protowire.AppendTag(b, fd.Number, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(n))
+		case types.StringList:
+			l, ok := stringList(v)
+			if !ok {
+				return nil, fmt.Errorf("field %q is a %T, not a list of strings", fd.Column, v)
+			}
+			for _, s := range l {
+				b = protowire.AppendTag(b, fd.Number, protowire.BytesType)
+				b = protowire.AppendString(b, s)
+			}
+		default:
+			return nil, fmt.Errorf("field %q has unknown type %q", fd.Column, fd.Type)
+		}
+	}
+	if seen != len(row) {
+		return nil, fmt.Errorf("row has %d fields which aren't in the definition", len(row)-seen)
+	}
+	return b, nil
+}
+
+// number returns a numeric value as a float64, as it is in JSON.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// stringList returns a list value as a list of strings.
+func stringList(v interface{}) ([]string, bool) {
+	switch l := v.(type) {
+	case []string:
+		return l, true
+	case []interface{}:
+		out := make([]string, len(l))
+		for i, e := range l {
+			s, ok := e.(string)
+			if !ok {
+				return nil, false
+			}
+			out[i] = s
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// appendString appends a string field, unless it is empty, which proto3
+// doesn't distinguish from unset.
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendMessage appends an embedded message field.
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func init() {
+	storage.RegisterFormat(&storage.Format{
+		Name:        FormatName,
+		Extension:   ".pb",
+		ContentType: ContentType,
+		Encode:      encode,
+	})
+	storage.RegisterFormat(&storage.Format{
+		Name:        DefinitionFormatName,
+		Extension:   ".proto",
+		ContentType: DefinitionContentType,
+		Encode:      encodeDefinition,
+	})
+}
+
+// encode is the protobuf storage format's encoder.
+func encode(ctx context.Context, p storage.Payload) ([]byte, error) {
+	f, err := ForPayload(p)
+	if err != nil {
+		return nil, err
+	}
+	return f.Marshal(p)
+}
+
+// encodeDefinition is the .proto storage format's encoder.
+func encodeDefinition(ctx context.Context, p storage.Payload) ([]byte, error) {
+	f, err := ForPayload(p)
+	if err != nil {
+		return nil, err
+	}
+	return f.Definition(), nil
+}
@@ -0,0 +1,142 @@
+package protobuf
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestNames(t *testing.T) {
+	for in, want := range map[string]string{
+		"id":                        "id",
+		"Latest report yes?":        "latest_report_yes",
+		"Vaccine info URL":          "vaccine_info_url",
+		"vaccinefinder_location_id": "vaccinefinder_location_id",
+		"  County -- vaccination  ": "county_vaccination",
+		"2nd dose":                  "f_2nd_dose",
+		"???":                       "",
+	} {
+		if got := fieldName(in); got != want {
+			t.Errorf("fieldName(%q) = %q, want %q", in, got, want)
+		}
+	}
+	for in, want := range map[string]string{
+		"1/locations":      "vaccinateca.v1.locations",
+		"LEGACY/Locations": "vaccinateca.legacy.locations",
+	} {
+		if got := packageName(in); got != want {
+			t.Errorf("packageName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func testPayload(table types.TableContent, md *metadata.Metadata) storage.Payload {
+	return storage.Payload{
+		Name:    "1/test",
+		Data:    metadata.WrapWithMetadata(table, md),
+		Table:   table,
+		Columns: []string{"id", "Name", "Count", "Tags"},
+		Types: map[string]types.FieldType{
+			"id":    types.String,
+			"Name":  types.String,
+			"Count": types.Number,
+			"Tags":  types.StringList,
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	table := types.TableContent{
+		{"id": "rec1", "Name": "Somewhere", "Count": 0.0, "Tags": []interface{}{"a", "b"}},
+		{"id": "rec2", "Count": 2.5},
+		{"id": "rec3", "Name": "", "Tags": []string{"c"}},
+	}
+	md := &metadata.Metadata{
+		Generated:      time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC),
+		SourceSnapshot: time.Date(2021, 4, 1, 11, 59, 30, 500, time.UTC),
+		Commit:         "abc123",
+		RunID:          "20210401T120000Z",
+		Records:        3,
+		APIVersion:     "1",
+		Deprecation:    "Use v2.",
+	}
+	p := testPayload(table, md)
+	ctx := context.Background()
+	pb, err := storage.Encode(ctx, FormatName, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	js, err := storage.Encode(ctx, storage.FormatJSON, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := ForPayload(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fd, err := protodesc.NewFile(f.Descriptor(), nil)
+	if err != nil {
+		t.Fatalf("invalid descriptor: %v", err)
+	}
+	msg := dynamicpb.NewMessage(fd.Messages().ByName("Response"))
+	if err := proto.Unmarshal(pb.Data, msg); err != nil {
+		t.Fatalf("unexpected error unmarshaling: %v", err)
+	}
+	fromPB, err := protojson.Marshal(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got, want interface{}
+	if err := json.Unmarshal(fromPB, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(js.Data, &want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("protobuf doesn't match JSON (-json +protobuf):\n%s", diff)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := map[string]types.TableContent{
+		"wrong type":    {{"id": "rec1", "Count": "2"}},
+		"list of ints":  {{"id": "rec1", "Tags": []interface{}{1.0}}},
+		"null":          {{"id": "rec1", "Name": nil}},
+		"unknown field": {{"id": "rec1", "Other": "x"}},
+	}
+	for name, table := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := encode(context.Background(), testPayload(table, nil)); err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
+
+func TestForPayloadErrors(t *testing.T) {
+	p := testPayload(nil, nil)
+	p.Columns = append(p.Columns, "Untyped")
+	if _, err := ForPayload(p); err == nil {
+		t.Errorf("got no error for an untyped column")
+	}
+
+	p = testPayload(nil, nil)
+	p.Columns = append(p.Columns, "name")
+	p.Types["name"] = types.String
+	if _, err := ForPayload(p); err == nil {
+		t.Errorf("got no error for columns with the same field name")
+	}
+}
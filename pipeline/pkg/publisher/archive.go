@@ -0,0 +1,326 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/archive"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	beeline "github.com/honeycombio/beeline-go"
+)
+
+// archiver writes snapshots to the archive, and maintains its indexes.
+type archiver struct {
+	// root and downloadRoot are the upload and download URLs of the
+	// archive directory.
+	root, downloadRoot string
+	retention          time.Duration
+	sr                 deploys.StorageReader
+	sw                 deploys.StorageWriter
+	sd                 deploys.StorageDeleter
+}
+
+func newArchiver(sr deploys.StorageReader, sw deploys.StorageWriter) (*archiver, error) {
+	root, err := deploys.GetAPIUploadURL()
+	if err != nil {
+		return nil, err
+	}
+	downloadRoot, err := deploys.GetAPIDownloadURL()
+	if err != nil {
+		return nil, err
+	}
+	retention, err := deploys.GetArchiveRetention()
+	if err != nil {
+		return nil, err
+	}
+	sd, err := deploys.GetDeleter()
+	if err != nil {
+		return nil, err
+	}
+	return &archiver{
+		root:         root + "/" + archive.Dir,
+		downloadRoot: downloadRoot + "/" + archive.Dir,
+		retention:    retention,
+		sr:           sr,
+		sw:           sw,
+		sd:           sd,
+	}, nil
+}
+
+// publishArchive writes the outputs of every endpoint which was published to
+// the archive, adds the snapshot to the archive's indexes, and deletes
+// expired snapshots.
+func publishArchive(ctx context.Context, results map[string]result, run metadata.Run, sr deploys.StorageReader, sw deploys.StorageWriter) error {
+	ctx, span := beeline.StartSpan(ctx, "publisher.publishArchive")
+	defer span.Send()
+
+	a, err := newArchiver(sr, sw)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	// Index whatever was written, even if some files failed, so that it is
+	// pruned in time.
+	snapshot, writeErr := a.write(ctx, results, run)
+	if err := a.index(ctx, snapshot, run.Generated); err != nil {
+		err = fmt.Errorf("failed to update the archive index: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	if writeErr != nil {
+		beeline.AddField(ctx, "error", writeErr)
+	}
+	return writeErr
+}
+
+// archiveWorkers is how many files are archived or restored at once.
+const archiveWorkers = 8
+
+// write archives every format of each endpoint which was published, and
+// the outputs derived from its table, and returns the snapshot.
+func (a *archiver) write(ctx context.Context, results map[string]result, run metadata.Run) (archive.Snapshot, error) {
+	snapshot := archive.Snapshot{
+		RunID:     run.ID,
+		Generated: run.Generated,
+		Files:     map[string][]string{},
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+		sem  = make(chan struct{}, archiveWorkers)
+	)
+	for _, r := range results {
+		dir := archive.VersionDir(r.ep.Version)
+		files := make(map[string]storage.Encoded, len(r.encoded)+len(r.derived))
+		for _, enc := range r.encoded {
+			files[r.ep.Resource+enc.Extension] = enc
+		}
+		for file, enc := range r.derived {
+			files[file] = enc
+		}
+		for file, enc := range files {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(dir, file string, enc storage.Encoded) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				destination := a.root + "/" + dir + "/" + snapshot.Stamp() + "/" + strings.TrimSuffix(file, enc.Extension)
+				err := a.sw(ctx, destination, enc)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s/%s: %v", dir, file, err))
+					return
+				}
+				snapshot.Files[dir] = append(snapshot.Files[dir], file)
+			}(dir, file, enc)
+		}
+	}
+	wg.Wait()
+	for _, files := range snapshot.Files {
+		sort.Strings(files)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return snapshot, fmt.Errorf("failed to archive %d files: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return snapshot, nil
+}
+
+// index adds the snapshot to the index of its day and the top-level index,
+// and deletes expired snapshots.
+func (a *archiver) index(ctx context.Context, snapshot archive.Snapshot, now time.Time) error {
+	var idx archive.Index
+	if err := a.read(ctx, a.root+"/"+archive.IndexName, &idx); err != nil {
+		return err
+	}
+	idx.Retention = a.retention.String()
+
+	day := archive.Day(snapshot.Generated)
+	d := archive.DayIndex{Day: day}
+	if err := a.read(ctx, a.dayIndex(day), &d); err != nil {
+		return err
+	}
+	d.Add(snapshot)
+	if err := a.writeDay(ctx, &idx, &d); err != nil {
+		return err
+	}
+
+	pruneErr := a.prune(ctx, &idx, now)
+	// Write the index even if pruning failed part-way, so that it lists
+	// what is left.
+	enc, err := storage.EncodeJSON(ctx, idx)
+	if err != nil {
+		return err
+	}
+	if err := a.sw(ctx, a.root+"/"+archive.IndexName, enc); err != nil {
+		return err
+	}
+	return pruneErr
+}
+
+// prune deletes expired snapshots, oldest first, until it has deleted at
+// least archive.PruneLimit files, and the indexes of days with no snapshots
+// left.  A snapshot whose files couldn't all be deleted stays in its day's
+// index, so that the next run tries again.
+func (a *archiver) prune(ctx context.Context, idx *archive.Index, now time.Time) error {
+	var (
+		days    []*archive.DayIndex
+		expired []archive.Snapshot
+		files   int
+	)
+	for i := len(idx.Days) - 1; i >= 0 && files < archive.PruneLimit; i-- {
+		d := &archive.DayIndex{Day: idx.Days[i].Day}
+		if err := a.read(ctx, a.dayIndex(d.Day), d); err != nil {
+			return err
+		}
+		n := len(d.Snapshots)
+		for len(d.Snapshots) > 0 && files < archive.PruneLimit {
+			oldest := d.Snapshots[len(d.Snapshots)-1]
+			if !oldest.Expired(now, a.retention) {
+				break
+			}
+			expired = append(expired, oldest)
+			files += len(oldest.Paths())
+			d.Snapshots = d.Snapshots[:len(d.Snapshots)-1]
+		}
+		if len(d.Snapshots) == n {
+			// Later days only have later snapshots.
+			break
+		}
+		days = append(days, d)
+	}
+
+	kept, deleteErr := a.delete(ctx, expired)
+	for _, d := range days {
+		for _, s := range kept {
+			if archive.Day(s.Generated) == d.Day {
+				d.Add(s)
+			}
+		}
+		if len(d.Snapshots) == 0 {
+			if err := a.sd(ctx, a.dayIndex(d.Day)+".json"); err != nil {
+				return err
+			}
+			idx.RemoveDay(d.Day)
+			continue
+		}
+		if err := a.writeDay(ctx, idx, d); err != nil {
+			return err
+		}
+	}
+	return deleteErr
+}
+
+// delete deletes the files of the snapshots, archiveWorkers at a time, and
+// returns the snapshots which weren't completely deleted.
+func (a *archiver) delete(ctx context.Context, snapshots []archive.Snapshot) ([]archive.Snapshot, error) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []string
+		failed = map[int]bool{}
+		sem    = make(chan struct{}, archiveWorkers)
+	)
+	for i, s := range snapshots {
+		for _, p := range s.Paths() {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, p string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				err := a.sd(ctx, a.root+"/"+p)
+				if err == nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, fmt.Sprintf("%s: %v", p, err))
+				failed[i] = true
+			}(i, p)
+		}
+	}
+	wg.Wait()
+	if len(errs) == 0 {
+		return nil, nil
+	}
+	var kept []archive.Snapshot
+	for i, s := range snapshots {
+		if failed[i] {
+			kept = append(kept, s)
+		}
+	}
+	sort.Strings(errs)
+	return kept, fmt.Errorf("failed to delete %d expired files: %s", len(errs), strings.Join(errs, "; "))
+}
+
+// dayIndex returns the upload URL of a day's index, without the extension.
+func (a *archiver) dayIndex(day string) string {
+	return a.root + "/" + day + "/" + archive.IndexName
+}
+
+// writeDay writes a day's index, and updates its entry in the top-level
+// index.
+func (a *archiver) writeDay(ctx context.Context, idx *archive.Index, d *archive.DayIndex) error {
+	enc, err := storage.EncodeJSON(ctx, d)
+	if err != nil {
+		return err
+	}
+	if err := a.sw(ctx, a.dayIndex(d.Day), enc); err != nil {
+		return err
+	}
+	idx.SetDay(archive.DayEntry{
+		Day:       d.Day,
+		URL:       a.downloadRoot + "/" + d.Day + "/" + archive.IndexName + enc.Extension,
+		Snapshots: len(d.Snapshots),
+	})
+	return nil
+}
+
+// recorder is a StorageWriter which keeps what it stores under a directory,
+// by path relative to it, to be archived.
+type recorder struct {
+	dir string
+	sw  deploys.StorageWriter
+
+	mu    sync.Mutex
+	files map[string]storage.Encoded
+}
+
+func newRecorder(dir string, sw deploys.StorageWriter) *recorder {
+	return &recorder{dir: dir, sw: sw, files: map[string]storage.Encoded{}}
+}
+
+func (r *recorder) store(ctx context.Context, destination string, enc storage.Encoded) error {
+	if err := r.sw(ctx, destination, enc); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[strings.TrimPrefix(destination+enc.Extension, r.dir+"/")] = enc
+	return nil
+}
+
+// read reads and decodes an index, leaving v as it is if there is none.
+func (a *archiver) read(ctx context.Context, file string, v interface{}) error {
+	data, err := a.sr(ctx, file+".json")
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return nil
+}
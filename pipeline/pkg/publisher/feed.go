@@ -0,0 +1,52 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/atom"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// feedTitle is the title of the statewide feed; the title of each county's
+// feed adds the county.
+const feedTitle = "VaccinateCA: vaccine reported available"
+
+// publishFeed adds an entry for each of the reported rows which found
+// vaccine available to the Atom feed at destination, and returns how many
+// of them had a report time which couldn't be parsed.  reported are the
+// rows whose latest report changed on this run; see atom.Reported.  name
+// identifies the feed, and self is the URL it is downloaded from.
+func publishFeed(ctx context.Context, reported types.TableContent, name, title, self, destination string, sr deploys.StorageReader, sw deploys.StorageWriter) (int, error) {
+	var previous *atom.Feed
+	data, err := sr(ctx, destination+atom.Extension)
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+	case err != nil:
+		// Rather than drop its entries, leave the feed as it is.
+		return 0, fmt.Errorf("failed to read previous feed: %w", err)
+	default:
+		if previous, err = atom.Parse(data); err != nil {
+			log.Printf("[%s] Failed to parse previous feed; starting it afresh: %v\n", name, err)
+		}
+	}
+	feed, invalid := atom.Build(previous, reported, atom.Options{Name: name, Title: title, Self: self})
+	return invalid, writeFeed(ctx, feed, destination, sw)
+}
+
+// writeFeed writes an Atom feed to destination.
+func writeFeed(ctx context.Context, feed *atom.Feed, destination string, sw deploys.StorageWriter) error {
+	data, err := feed.Encode()
+	if err != nil {
+		return err
+	}
+	return sw(ctx, destination, storage.Encoded{
+		Data:        data,
+		ContentType: atom.ContentType,
+		Extension:   atom.Extension,
+	})
+}
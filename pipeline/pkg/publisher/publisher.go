@@ -0,0 +1,660 @@
+// Package publisher runs a single publish pass: it generates every endpoint
+// from one fetch of the Airtable data, and writes the results out using the
+// deploy's StorageWriter.
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/atom"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/bycounty"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/changes"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/config"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/lineage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/manifest"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/openapi"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/schema"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	beeline "github.com/honeycombio/beeline-go"
+)
+
+// Run generates and stores every endpoint in endpoints.EndpointMap, using
+// tables as the source of data, and then the files which describe the whole
+// API.  Every output is attempted, even if some fail; the returned error
+// describes all of the failures.  The run report is logged once everything
+// is complete, and returned.  Nothing is published while publishing is
+// paused, e.g. after a Rollback; the error then wraps control.ErrPaused.
+func Run(ctx context.Context, tables *airtable.Tables) (*report.Report, error) {
+	ctx, span := beeline.StartSpan(ctx, "publisher.Run")
+	defer span.Send()
+
+	started := time.Now().UTC()
+	run := metadata.Run{
+		ID:        RunID(started),
+		Commit:    config.GitCommit,
+		Generated: started,
+	}
+	beeline.AddField(ctx, "run_id", run.ID)
+	rep := report.New()
+	ctx = report.NewContext(ctx, rep)
+
+	sw, err := deploys.GetStorage()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return rep, err
+	}
+	sr, err := deploys.GetReader()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return rep, err
+	}
+
+	if err := checkPause(ctx, sr); err != nil {
+		log.Printf("Not publishing: %v\n", err)
+		beeline.AddField(ctx, "error", err)
+		return rep, err
+	}
+	pins, err := control.GetPins(ctx, sr)
+	if err != nil {
+		err = fmt.Errorf("failed to check for pins: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return rep, err
+	}
+	pinned := control.Pinned(pins)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failed  []string
+		results = map[string]result{}
+	)
+	eps := endpoints.AllEndpoints()
+	for _, ep := range eps {
+		if p, ok := pinned[ep.String()]; ok {
+			log.Printf("[%s] Pinned since %s by %s (%s); not publishing\n", &ep, p.Since.Format(time.RFC3339), p.By, p.Reason)
+			rep.Add("pinned."+ep.String(), 1)
+			continue
+		}
+		wg.Add(1)
+		go func(ep endpoints.Endpoint) {
+			defer wg.Done()
+			r, err := publishEndpoint(ctx, ep, tables, run, sr, sw)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("[%s] Failed to publish: %v\n", &ep, err)
+				failed = append(failed, fmt.Sprintf("%s: %v", &ep, err))
+			}
+			// The endpoint's JSON was published, even if some of its
+			// other outputs weren't.
+			if _, ok := r.encoded[storage.FormatJSON]; ok {
+				results[ep.String()] = r
+			}
+		}(ep)
+	}
+	wg.Wait()
+
+	// A rollback may have started while the endpoints were published;
+	// don't describe or archive what it is replacing.
+	if err := checkPause(ctx, sr); err != nil {
+		log.Printf("Not publishing the API's indexes or archive: %v\n", err)
+		logReport(rep)
+		beeline.AddField(ctx, "error", err)
+		return rep, err
+	}
+
+	if err := publishOpenAPI(ctx, eps, results, sw); err != nil {
+		log.Printf("Failed to publish OpenAPI description: %v\n", err)
+		failed = append(failed, fmt.Sprintf("openapi: %v", err))
+	}
+	if err := publishIndexes(ctx, eps, results, pinned, started, sw); err != nil {
+		log.Printf("Failed to publish indexes: %v\n", err)
+		failed = append(failed, fmt.Sprintf("index: %v", err))
+	}
+	if err := publishArchive(ctx, results, run, sr, sw); err != nil {
+		log.Printf("Failed to archive: %v\n", err)
+		failed = append(failed, fmt.Sprintf("archive: %v", err))
+	}
+
+	logReport(rep)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		err = fmt.Errorf("%d outputs failed to publish: %s", len(failed), strings.Join(failed, "; "))
+		beeline.AddField(ctx, "error", err)
+		return rep, err
+	}
+	return rep, nil
+}
+
+// Timeout is how long a publish pass may take; see "Latencies" in the
+// README.
+const Timeout = 2 * time.Minute
+
+// checkPause returns an error wrapping control.ErrPaused if publishing is
+// paused.  If the pause can't be read, that is an error too, so as not to
+// risk overwriting a rollback.
+func checkPause(ctx context.Context, sr deploys.StorageReader) error {
+	pause, err := control.GetPause(ctx, sr)
+	if err != nil {
+		return fmt.Errorf("failed to check for a pause: %w", err)
+	}
+	if pause != nil {
+		return fmt.Errorf("%w since %s by %s: %s", control.ErrPaused, pause.Since.Format(time.RFC3339), pause.By, pause.Reason)
+	}
+	return nil
+}
+
+// runIDFormat is the time layout of run IDs.
+const runIDFormat = "20060102T150405Z"
+
+// RunID returns the ID of the run which started at the given time.  Runs
+// don't overlap, so it is unique.
+func RunID(started time.Time) string {
+	return started.UTC().Format(runIDFormat)
+}
+
+// result is the output of a successfully published endpoint, for the files
+// which describe the whole API.
+type result struct {
+	ep      endpoints.Endpoint
+	payload storage.Payload
+	// encoded is what was written for each of the endpoint's formats.
+	encoded map[string]storage.Encoded
+	// derived is everything else written from the endpoint's table, like
+	// its by-county files, feeds and tiles, by path relative to its
+	// version's directory.
+	derived map[string]storage.Encoded
+	// generated is when the endpoint finished publishing.
+	generated time.Time
+}
+
+// publishEndpoint generates a single endpoint, writes it out, and returns
+// what it published.  If its JSON can't be published, nothing else is;
+// otherwise every other output is attempted, and the error describes those
+// which failed, alongside what was published.
+func publishEndpoint(ctx context.Context, ep endpoints.Endpoint, tables *airtable.Tables, run metadata.Run, sr deploys.StorageReader, sw deploys.StorageWriter) (result, error) {
+	ctx, span := beeline.StartSpan(ctx, "publisher.publishEndpoint")
+	defer span.Send()
+	beeline.AddField(ctx, "endpoint", ep.String())
+
+	table, err := ep.Transform(ctx, tables)
+	if err != nil {
+		err = fmt.Errorf("failed to transform: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return result{}, err
+	}
+	beeline.AddField(ctx, "rows", len(table))
+	report.FromContext(ctx).Add("rows."+ep.String(), len(table))
+
+	baseURL, err := deploys.GetUploadURL(ep.Version)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return result{}, err
+	}
+	destination := baseURL + "/" + ep.Resource
+
+	// Read what was published last time before overwriting it, to
+	// publish the changes since.
+	previous := readPrevious(ctx, ep, sr, destination)
+
+	// Fetching and transforming takes most of a run, so check again that
+	// a rollback hasn't started since the run did.
+	if err := checkPause(ctx, sr); err != nil {
+		beeline.AddField(ctx, "error", err)
+		return result{}, err
+	}
+
+	snapshot := tables.FetchedAt(ep.Table)
+	payload := ep.Payload(table, run, snapshot)
+	encoded := make(map[string]storage.Encoded, len(ep.Formats))
+	// Only the JSON is needed for the rest; a failure of any other output
+	// is reported once everything else is published, so that one bad
+	// format doesn't hold the rest back.
+	var errs []string
+	failed := func(output string, err error) {
+		log.Printf("[%s] Failed to publish %s: %v\n", &ep, output, err)
+		report.FromContext(ctx).Add("failed_outputs."+ep.String(), 1)
+		errs = append(errs, fmt.Sprintf("%s: %v", output, err))
+	}
+	for _, format := range ep.Formats {
+		enc, err := storage.Encode(ctx, format, payload)
+		if err == nil {
+			err = sw(ctx, destination, enc)
+			if err != nil {
+				err = fmt.Errorf("failed to store %s: %w", format, err)
+			}
+		}
+		if err != nil && format == storage.FormatJSON {
+			beeline.AddField(ctx, "error", err)
+			return result{}, err
+		}
+		if err != nil {
+			failed(format, err)
+			continue
+		}
+		encoded[format] = enc
+	}
+
+	// Without a change set, nothing is added to the feeds.
+	var set *changes.Set
+	if previous != nil {
+		if set, err = publishChanges(ctx, ep, previous, encoded[storage.FormatJSON].Data, run, destination, sr, sw); err != nil {
+			failed("changes", err)
+		}
+	}
+	var reported types.TableContent
+	if ep.Feed {
+		reported = atom.Reported(table, set)
+	}
+
+	// The outputs derived from the table are recorded, to be archived with
+	// it.
+	rec := newRecorder(baseURL, sw)
+	if ep.ByCounty {
+		if err := publishByCounty(ctx, ep, table, reported, tables, run, destination, sr, rec.store); err != nil {
+			failed("by-county", err)
+		}
+	}
+	if ep.Feed {
+		self := baseURL + "/" + ep.Resource + atom.Extension
+		invalid, err := publishFeed(ctx, reported, ep.String(), feedTitle, self, destination, sr, rec.store)
+		if err != nil {
+			failed("feed", err)
+		}
+		report.FromContext(ctx).Add("feed_invalid_reports."+ep.String(), invalid)
+		if invalid > 0 {
+			log.Printf("[%s] %d rows reported vaccine available at a time which isn't RFC 3339, so aren't in the feed\n", &ep, invalid)
+		}
+	}
+	if ep.Tiles {
+		if err := publishTiles(ctx, ep, table, run, snapshot, baseURL+"/tiles", sr, rec.store); err != nil {
+			failed("tiles", err)
+		}
+	}
+
+	// The lineage report is published next to the data, as both JSON and
+	// Markdown.
+	l := lineage.For(ep)
+	lineageJSON, err := storage.EncodeJSON(ctx, l)
+	if err != nil {
+		failed("lineage", err)
+	} else {
+		for _, enc := range []storage.Encoded{lineageJSON, l.Markdown()} {
+			if err := sw(ctx, destination+".lineage", enc); err != nil {
+				failed("lineage", err)
+				break
+			}
+		}
+	}
+
+	r := result{
+		ep:        ep,
+		payload:   payload,
+		encoded:   encoded,
+		derived:   rec.files,
+		generated: time.Now().UTC(),
+	}
+	if len(errs) > 0 {
+		err := fmt.Errorf("failed to publish %d outputs: %s", len(errs), strings.Join(errs, "; "))
+		beeline.AddField(ctx, "error", err)
+		return r, err
+	}
+	return r, nil
+}
+
+// readPrevious returns the endpoint's previously published JSON, or nil if
+// there is none, or it can't be read, in which case there is no change feed
+// for this run.
+func readPrevious(ctx context.Context, ep endpoints.Endpoint, sr deploys.StorageReader, destination string) []byte {
+	f, err := storage.LookupFormat(storage.FormatJSON)
+	if err != nil {
+		return nil
+	}
+	data, err := sr(ctx, destination+f.Extension)
+	if errors.Is(err, storage.ErrNotExist) {
+		log.Printf("[%s] No previous output; not publishing changes\n", &ep)
+		return nil
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to read previous output; not publishing changes: %v\n", &ep, err)
+		report.FromContext(ctx).Add("changes_unavailable."+ep.String(), 1)
+		return nil
+	}
+	return data
+}
+
+// publishChanges writes the changes between the endpoint's previous and
+// current JSON outputs, adds them to its recent change sets, and returns
+// them, even if they couldn't be written.
+func publishChanges(ctx context.Context, ep endpoints.Endpoint, previous, current []byte, run metadata.Run, destination string, sr deploys.StorageReader, sw deploys.StorageWriter) (*changes.Set, error) {
+	ctx, span := beeline.StartSpan(ctx, "publisher.publishChanges")
+	defer span.Send()
+
+	before, err := changes.Rows(previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse previous output: %w", err)
+	}
+	after, err := changes.Rows(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+	set, err := changes.Diff(before, after)
+	if err != nil {
+		return nil, err
+	}
+	set.Generated = run.Generated
+	set.RunID = run.ID
+	changed := len(set.Added) + len(set.Removed) + len(set.Modified)
+	beeline.AddField(ctx, "changed", changed)
+	report.FromContext(ctx).Add("changed."+ep.String(), changed)
+
+	enc, err := storage.EncodeJSON(ctx, set)
+	if err != nil {
+		return set, err
+	}
+	if err := sw(ctx, destination+"."+changes.Name, enc); err != nil {
+		return set, err
+	}
+
+	var recent changes.Recent
+	data, err := sr(ctx, destination+"."+changes.RecentName+enc.Extension)
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+	case err != nil:
+		return set, fmt.Errorf("failed to read recent changes: %w", err)
+	default:
+		if err := json.Unmarshal(data, &recent); err != nil {
+			return set, fmt.Errorf("failed to parse recent changes: %w", err)
+		}
+	}
+	recent.Add(set)
+	enc, err = storage.EncodeJSON(ctx, recent)
+	if err != nil {
+		return set, err
+	}
+	if err := sw(ctx, destination+"."+changes.RecentName, enc); err != nil {
+		return set, err
+	}
+	return set, nil
+}
+
+// byCountyWorkers is how many county files are written at once.
+const byCountyWorkers = 8
+
+// publishByCounty writes the endpoint's table split by county, using the
+// counties in the Counties table, and an index of the files.  Counties which
+// were in the previous index, but are no longer in the Counties table, are
+// emptied.  Rows which aren't in any county are counted in the run report.
+// reported are the rows to add to the counties' feeds, if the endpoint has
+// them.
+func publishByCounty(ctx context.Context, ep endpoints.Endpoint, table, reported types.TableContent, tables *airtable.Tables, run metadata.Run, destination string, sr deploys.StorageReader, sw deploys.StorageWriter) error {
+	ctx, span := beeline.StartSpan(ctx, "publisher.publishByCounty")
+	defer span.Send()
+
+	counties, err := tables.GetCounties(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to fetch Counties table: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	snapshot := tables.FetchedAt(ep.Table)
+	split, unmatched := bycounty.Split(table, counties)
+	beeline.AddField(ctx, "counties", len(split))
+	report.FromContext(ctx).Add("by_county_unmatched."+ep.String(), unmatched)
+	if unmatched > 0 {
+		log.Printf("[%s] %d rows are not in any county in the Counties table\n", &ep, unmatched)
+	}
+
+	baseURL, err := deploys.GetDownloadURL(ep.Version)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	dir := "/" + ep.Resource + "/by-county/"
+
+	var previous bycounty.Index
+	data, err := sr(ctx, destination+"/by-county/index.json")
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+	case err != nil:
+		log.Printf("[%s] Failed to read previous county index; not emptying old counties: %v\n", &ep, err)
+	default:
+		if err := json.Unmarshal(data, &previous); err != nil {
+			log.Printf("[%s] Failed to parse previous county index; not emptying old counties: %v\n", &ep, err)
+		}
+	}
+	current := map[string]bool{}
+	for _, c := range split {
+		current[c.Slug] = true
+	}
+	writes := append([]*bycounty.County{}, split...)
+	dropped := map[string]bool{}
+	for _, e := range previous.Counties {
+		if !current[e.Slug] {
+			current[e.Slug] = true
+			dropped[e.Slug] = true
+			writes = append(writes, &bycounty.County{Name: e.County, Slug: e.Slug, Rows: types.TableContent{}})
+		}
+	}
+	reportedIn := map[string]types.TableContent{}
+	if ep.Feed {
+		split, _ := bycounty.Split(reported, counties)
+		for _, c := range split {
+			reportedIn[c.Slug] = c.Rows
+		}
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+		sem  = make(chan struct{}, byCountyWorkers)
+	)
+	index := bycounty.Index{Counties: make([]bycounty.IndexEntry, len(split))}
+	for i, c := range split {
+		index.Counties[i] = bycounty.IndexEntry{
+			County: c.Name,
+			Slug:   c.Slug,
+			URL:    baseURL + dir + c.Slug + ".json",
+			Rows:   len(c.Rows),
+		}
+		if ep.Feed {
+			index.Counties[i].Feed = baseURL + dir + c.Slug + atom.Extension
+		}
+	}
+	for _, c := range writes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c *bycounty.County) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := publishCounty(ctx, ep, c, reportedIn[c.Slug], dropped[c.Slug], run, snapshot, destination+"/by-county/"+c.Slug, baseURL+dir+c.Slug, sr, sw)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", c.Slug, err))
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		err := fmt.Errorf("failed to store %d counties: %s", len(errs), strings.Join(errs, "; "))
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+
+	enc, err := storage.EncodeJSON(ctx, index)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	if err := sw(ctx, destination+"/by-county/index", enc); err != nil {
+		err = fmt.Errorf("failed to store county index: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	return nil
+}
+
+// publishCounty writes a single county's rows, in the same shape as the
+// endpoint's JSON, and its feed, if the endpoint has one, adding the
+// county's reported rows; the feed of a county which was dropped from the
+// Counties table is emptied.  url is where the county's files are
+// downloaded from, without an extension.
+func publishCounty(ctx context.Context, ep endpoints.Endpoint, c *bycounty.County, reported types.TableContent, dropped bool, run metadata.Run, snapshot time.Time, destination, url string, sr deploys.StorageReader, sw deploys.StorageWriter) error {
+	enc, err := storage.Encode(ctx, storage.FormatJSON, ep.Payload(c.Rows, run, snapshot))
+	if err != nil {
+		return err
+	}
+	if err := sw(ctx, destination, enc); err != nil {
+		return err
+	}
+	if !ep.Feed {
+		return nil
+	}
+	opts := atom.Options{
+		Name:  ep.String() + "/by-county/" + c.Slug,
+		Title: feedTitle + " in " + c.Name,
+		Self:  url + atom.Extension,
+	}
+	if dropped {
+		feed, _ := atom.Build(nil, nil, opts)
+		return writeFeed(ctx, feed, destination, sw)
+	}
+	_, err = publishFeed(ctx, reported, opts.Name, opts.Title, opts.Self, destination, sr, sw)
+	return err
+}
+
+// publishOpenAPI writes the OpenAPI description of every endpoint to the root
+// of the API bucket.  Endpoints which failed to publish are still described,
+// but without a schema.
+func publishOpenAPI(ctx context.Context, eps []endpoints.Endpoint, results map[string]result, sw deploys.StorageWriter) error {
+	ctx, span := beeline.StartSpan(ctx, "publisher.publishOpenAPI")
+	defer span.Send()
+
+	schemas := make(map[string]*schema.Schema, len(results))
+	for name, r := range results {
+		schemas[name] = schema.ForPayload(r.payload)
+	}
+	doc, err := openapi.Build(eps, schemas)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	enc, err := doc.Encode()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	root, err := deploys.GetAPIUploadURL()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	if err := sw(ctx, root+"/openapi", enc); err != nil {
+		err = fmt.Errorf("failed to store: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	return nil
+}
+
+// publishIndexes writes the index of each version, listing every resource
+// in it, to the version's directory.
+func publishIndexes(ctx context.Context, eps []endpoints.Endpoint, results map[string]result, pinned map[string]control.Pin, started time.Time, sw deploys.StorageWriter) error {
+	ctx, span := beeline.StartSpan(ctx, "publisher.publishIndexes")
+	defer span.Send()
+
+	indexes := map[deploys.VersionType]*manifest.Index{}
+	for _, ep := range eps {
+		idx, ok := indexes[ep.Version]
+		if !ok {
+			idx = &manifest.Index{
+				Version:   string(ep.Version),
+				Generated: started,
+				Commit:    config.GitCommit,
+				Resources: []manifest.Resource{},
+			}
+			indexes[ep.Version] = idx
+		}
+		url, err := ep.URL()
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			return err
+		}
+		res := manifest.Resource{Resource: ep.Resource, URL: url, Failed: true}
+		if _, ok := pinned[ep.String()]; ok {
+			res = manifest.Resource{Resource: ep.Resource, URL: url, Pinned: true}
+		}
+		if r, ok := results[ep.String()]; ok {
+			data := r.encoded[storage.FormatJSON].Data
+			generated := r.generated
+			res = manifest.Resource{
+				Resource:  ep.Resource,
+				URL:       url,
+				Rows:      len(r.payload.Table),
+				Bytes:     len(data),
+				SHA256:    manifest.SHA256(data),
+				Generated: &generated,
+				Commit:    config.GitCommit,
+			}
+		}
+		idx.Resources = append(idx.Resources, res)
+	}
+
+	var errs []string
+	for version, idx := range indexes {
+		sort.Slice(idx.Resources, func(i, j int) bool {
+			return idx.Resources[i].Resource < idx.Resources[j].Resource
+		})
+		if err := publishIndex(ctx, version, idx, sw); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", version, err))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		err := errors.New(strings.Join(errs, "; "))
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	return nil
+}
+
+// publishIndex writes a single version's index.
+func publishIndex(ctx context.Context, version deploys.VersionType, idx *manifest.Index, sw deploys.StorageWriter) error {
+	enc, err := storage.EncodeJSON(ctx, idx)
+	if err != nil {
+		return err
+	}
+	baseURL, err := deploys.GetUploadURL(version)
+	if err != nil {
+		return err
+	}
+	return sw(ctx, baseURL+"/"+manifest.Name, enc)
+}
+
+// logReport writes the run report to the log, as a single line of JSON.
+func logReport(rep *report.Report) {
+	b, err := json.Marshal(rep)
+	if err != nil {
+		log.Printf("Failed to serialize run report: %v\n", err)
+		return
+	}
+	log.Printf("Run report: %s\n", b)
+}
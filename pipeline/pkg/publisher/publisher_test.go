@@ -0,0 +1,759 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/airtable"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/archive"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/atom"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/bycounty"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/changes"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/geojson"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/manifest"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/openapi"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/tiles"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubFetchFromFiles is a fetcher which reads each table from a file of
+// saved test data.
+type stubFetchFromFiles map[string]string
+
+func (sf stubFetchFromFiles) Download(ctx context.Context, table string) (types.TableContent, error) {
+	f, ok := sf[table]
+	if !ok {
+		return nil, fmt.Errorf("table %q data not specified in test", table)
+	}
+	o, err := airtable.ObjectFromFile(ctx, table, f)
+	if err != nil {
+		return nil, err
+	}
+	// The saved test data is from the airtable-export tool, which stores
+	// the record id in a different field.
+	for _, r := range o {
+		r["id"] = r["airtable_id"]
+	}
+	return o, nil
+}
+
+// testFetcher reads every table from the endpoints' saved test data; the
+// Counties have the fields which v1 locations copy from them filled in.
+func testFetcher() stubFetchFromFiles {
+	return stubFetchFromFiles{
+		"Locations":         "../endpoints/test_data/locations_reduced.json",
+		"Counties":          "../endpoints/test_data/counties_linked.json",
+		"Provider networks": "../endpoints/test_data/providers.json",
+	}
+}
+
+// captureStorage is a StorageWriter which keeps everything written to it.
+type captureStorage struct {
+	mu      sync.Mutex
+	written map[string]storage.Encoded
+}
+
+func (c *captureStorage) delete(_ context.Context, file string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.written, file)
+	return nil
+}
+
+func (c *captureStorage) store(_ context.Context, destination string, enc storage.Encoded) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written[destination+enc.Extension] = enc
+	return nil
+}
+
+// read is a StorageReader which reads back what was stored.
+func (c *captureStorage) read(_ context.Context, file string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	enc, ok := c.written[file]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", file, storage.ErrNotExist)
+	}
+	return enc.Data, nil
+}
+
+// newCaptureStorage returns an empty captureStorage, set as the testing
+// deploy's storage.
+func newCaptureStorage() *captureStorage {
+	cs := &captureStorage{written: map[string]storage.Encoded{}}
+	deploys.SetTestingStorage(cs.store, "testbucket")
+	deploys.SetTestingReader(cs.read)
+	deploys.SetTestingDeleter(cs.delete)
+	return cs
+}
+
+func TestRun(t *testing.T) {
+	cs := newCaptureStorage()
+
+	ctx := context.Background()
+	tables := airtable.NewFakeTables(ctx, testFetcher())
+	rep, err := Run(ctx, tables)
+	require.NoError(t, err)
+
+	var want []string
+	for _, base := range []string{
+		"gs://testbucket/legacy/Locations",
+		"gs://testbucket/legacy/Counties",
+		"gs://testbucket/api/v1/locations",
+		"gs://testbucket/api/v1/counties",
+		"gs://testbucket/api/v1/providers",
+	} {
+		want = append(want, base+".json", base+".csv", base+".schema.json", base+".lineage.json", base+".lineage.md")
+	}
+	for _, base := range []string{
+		"gs://testbucket/api/v1/locations",
+		"gs://testbucket/api/v1/counties",
+		"gs://testbucket/api/v1/providers",
+	} {
+		want = append(want, base+".pb", base+".proto")
+	}
+	want = append(want,
+		"gs://testbucket/api/v1/locations.geojson",
+		"gs://testbucket/api/v1/locations.atom",
+		"gs://testbucket/api/openapi.json",
+		"gs://testbucket/legacy/index.json",
+		"gs://testbucket/api/v1/index.json",
+	)
+	for _, w := range want {
+		assert.Contains(t, cs.written, w)
+	}
+	byCounty, archived, tiled := 0, 0, 0
+	for f := range cs.written {
+		switch {
+		case strings.HasPrefix(f, "gs://testbucket/api/v1/locations/by-county/"):
+			byCounty++
+		case strings.HasPrefix(f, "gs://testbucket/api/archive/"):
+			archived++
+		case strings.HasPrefix(f, "gs://testbucket/api/v1/tiles/"):
+			tiled++
+		}
+	}
+	assert.Equal(t, len(want), len(cs.written)-byCounty-archived-tiled)
+	// Every format of every endpoint, the by-county files, feed and tiles
+	// derived from them, and the top-level and daily indexes.
+	assert.Equal(t, 24+byCounty+1+tiled, archived)
+
+	// There's a file and a feed for each of the 58 counties in the
+	// Counties table, and the index.
+	assert.Equal(t, 117, byCounty)
+	var index bycounty.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations/by-county/index.json"].Data, &index))
+	require.Len(t, index.Counties, 58)
+	total := 0
+	for _, c := range index.Counties {
+		total += c.Rows
+	}
+	assert.Equal(t, 10, total+rep.Count("by_county_unmatched.1/locations"))
+	var la metadata.APIResponse
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations/by-county/los_angeles.json"].Data, &la))
+	assert.Len(t, la.Content, 2)
+
+	contentTypes := map[string]string{
+		"gs://testbucket/api/v1/locations.json":         "application/json",
+		"gs://testbucket/api/v1/locations.csv":          "text/csv; charset=utf-8",
+		"gs://testbucket/api/v1/locations.geojson":      "application/geo+json",
+		"gs://testbucket/api/v1/locations.lineage.md":   "text/markdown; charset=utf-8",
+		"gs://testbucket/api/v1/locations.lineage.json": "application/json",
+		"gs://testbucket/api/v1/locations.atom":         "application/atom+xml",
+	}
+	for f, ct := range contentTypes {
+		assert.Equal(t, ct, cs.written[f].ContentType, f)
+	}
+
+	// Legacy is a bare list; other versions are wrapped.
+	var legacy types.TableContent
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/legacy/Locations.json"].Data, &legacy))
+	assert.Len(t, legacy, 10)
+	var v1 metadata.APIResponse
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations.json"].Data, &v1))
+	assert.Len(t, v1.Content, 10)
+	// v1 predates the metadata block, so doesn't opt in to it.
+	assert.Nil(t, v1.Metadata)
+
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/openapi.json"].Data, &doc))
+	assert.Contains(t, doc.Components.Schemas, "v1.locations")
+
+	// Every location is in one of the tiles, each of which has a file.
+	var tileIndex tiles.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/tiles/index.json"].Data, &tileIndex))
+	assert.Equal(t, tiles.Zoom, tileIndex.Zoom)
+	assert.Equal(t, len(tileIndex.Tiles)+1, tiled)
+	total = 0
+	for _, e := range tileIndex.Tiles {
+		total += e.Rows
+		var tile metadata.APIResponse
+		require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/tiles/"+e.Path()+".json"].Data, &tile))
+		assert.Len(t, tile.Content, e.Rows)
+	}
+	assert.Equal(t, 10, total+rep.Count("tiles_skipped.1/locations"))
+
+	var idx manifest.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/index.json"].Data, &idx))
+	assert.Equal(t, "1", idx.Version)
+	require.Len(t, idx.Resources, 3)
+	loc := idx.Resources[1]
+	assert.Equal(t, "locations", loc.Resource)
+	assert.Equal(t, 10, loc.Rows)
+	assert.Equal(t, len(cs.written["gs://testbucket/api/v1/locations.json"].Data), loc.Bytes)
+	assert.Equal(t, manifest.SHA256(cs.written["gs://testbucket/api/v1/locations.json"].Data), loc.SHA256)
+
+	var fc geojson.FeatureCollection
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations.geojson"].Data, &fc))
+	assert.Equal(t, 10, len(fc.Features)+rep.Count("geojson_skipped.1/locations"))
+
+	assert.Equal(t, 10, rep.Count("rows.1/locations"))
+	assert.Equal(t, 63, rep.Count("rows.LEGACY/Counties"))
+}
+
+func TestRun_Errors(t *testing.T) {
+	cs := newCaptureStorage()
+
+	// Without a Locations table, the two locations endpoints fail, and
+	// nothing else does.
+	f := testFetcher()
+	delete(f, "Locations")
+
+	ctx := context.Background()
+	tables := airtable.NewFakeTables(ctx, f)
+	_, err := Run(ctx, tables)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 outputs failed")
+	// Three endpoints, each with JSON, CSV and schema, and two lineage
+	// files, the two v1 endpoints' protobuf and .proto, the OpenAPI
+	// description, an index for each version, and the archive of the three
+	// endpoints and its two indexes.
+	assert.Equal(t, 37, len(cs.written))
+
+	// The index still lists the failed endpoint.
+	var idx manifest.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/legacy/index.json"].Data, &idx))
+	require.Len(t, idx.Resources, 2)
+	assert.Equal(t, "Locations", idx.Resources[1].Resource)
+	assert.True(t, idx.Resources[1].Failed)
+	assert.Empty(t, idx.Resources[1].SHA256)
+}
+
+// numericName is a fetcher which gives the first location a number for a
+// name, which protobuf can't encode in its string field.
+type numericName struct {
+	stubFetchFromFiles
+}
+
+func (n numericName) Download(ctx context.Context, table string) (types.TableContent, error) {
+	o, err := n.stubFetchFromFiles.Download(ctx, table)
+	if err != nil || table != "Locations" {
+		return o, err
+	}
+	o[0]["Name"] = 42.0
+	return o, nil
+}
+
+func TestRun_FormatError(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	pb := cs.written["gs://testbucket/api/v1/locations.pb"]
+	delete(cs.written, "gs://testbucket/api/v1/locations.changes.json")
+	delete(cs.written, "gs://testbucket/api/v1/locations/by-county/los_angeles.json")
+	delete(cs.written, "gs://testbucket/api/v1/locations.lineage.json")
+
+	// Only the protobuf fails; the endpoint's other outputs are all
+	// published.
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, numericName{testFetcher()}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1/locations: failed to publish 1 outputs: protobuf")
+	assert.Equal(t, pb, cs.written["gs://testbucket/api/v1/locations.pb"])
+	assert.Contains(t, string(cs.written["gs://testbucket/api/v1/locations.json"].Data), `"Name":42`)
+	for _, f := range []string{
+		"gs://testbucket/api/v1/locations.changes.json",
+		"gs://testbucket/api/v1/locations/by-county/los_angeles.json",
+		"gs://testbucket/api/v1/locations.lineage.json",
+	} {
+		assert.Contains(t, cs.written, f)
+	}
+	var set changes.Set
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations.changes.json"].Data, &set))
+	assert.Len(t, set.Modified, 1)
+
+	// The index lists its JSON.
+	var idx manifest.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/index.json"].Data, &idx))
+	for _, r := range idx.Resources {
+		assert.False(t, r.Failed, r.Resource)
+	}
+}
+
+// renameLocation is a fetcher which renames the first location.
+type renameLocation struct {
+	stubFetchFromFiles
+}
+
+func (r renameLocation) Download(ctx context.Context, table string) (types.TableContent, error) {
+	o, err := r.stubFetchFromFiles.Download(ctx, table)
+	if err != nil || table != "Locations" {
+		return o, err
+	}
+	o[0]["Name"] = "Renamed"
+	return o, nil
+}
+
+// pauseOnFetch is a fetcher which renames the first location, and pauses
+// publishing as the locations are fetched, as a rollback starting partway
+// through a run would.
+type pauseOnFetch struct {
+	stubFetchFromFiles
+	sw deploys.StorageWriter
+}
+
+func (p pauseOnFetch) Download(ctx context.Context, table string) (types.TableContent, error) {
+	if table == "Locations" {
+		if err := control.SetPause(ctx, p.sw, control.Pause{By: "someone", Reason: "rolling back"}); err != nil {
+			return nil, err
+		}
+	}
+	return renameLocation{p.stubFetchFromFiles}.Download(ctx, table)
+}
+
+// reportYes is a fetcher which reports vaccine available at the first
+// location, in Los Angeles County.
+type reportYes struct {
+	stubFetchFromFiles
+}
+
+func (r reportYes) Download(ctx context.Context, table string) (types.TableContent, error) {
+	return reportYesAt{r.stubFetchFromFiles, "2021-04-01T17:30:00.000Z"}.Download(ctx, table)
+}
+
+// reportYesAt is a fetcher which reports vaccine available at the first
+// location, at the given time.
+type reportYesAt struct {
+	stubFetchFromFiles
+	at string
+}
+
+func (r reportYesAt) Download(ctx context.Context, table string) (types.TableContent, error) {
+	o, err := r.stubFetchFromFiles.Download(ctx, table)
+	if err != nil || table != "Locations" {
+		return o, err
+	}
+	o[0]["Latest report"] = r.at
+	o[0]["Latest report yes?"] = 1.0
+	o[0]["Availability Info"] = []interface{}{"Yes: walk-ins accepted"}
+	return o, nil
+}
+
+func TestRun_Feed(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	feed := func(file string) atom.Feed {
+		t.Helper()
+		var f atom.Feed
+		require.Contains(t, cs.written, file)
+		require.NoError(t, xml.Unmarshal(cs.written[file].Data, &f))
+		return f
+	}
+
+	// There's nothing to compare the first run with, so nothing has
+	// changed.
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	assert.Empty(t, feed("gs://testbucket/api/v1/locations.atom").Entries)
+
+	rep, err := Run(ctx, airtable.NewFakeTables(ctx, reportYes{testFetcher()}))
+	require.NoError(t, err)
+	assert.Equal(t, 0, rep.Count("feed_invalid_reports.1/locations"))
+	state := feed("gs://testbucket/api/v1/locations.atom")
+	require.Len(t, state.Entries, 1)
+	entry := state.Entries[0]
+	assert.Equal(t, "Kaiser Permanente Pharmacy #568", entry.Title)
+	assert.Equal(t, "2021-04-01T17:30:00Z", entry.Updated)
+	assert.Equal(t, entry.Updated, state.Updated)
+	assert.Contains(t, entry.Content.Body, "Availability: Yes: walk-ins accepted")
+
+	la := feed("gs://testbucket/api/v1/locations/by-county/los_angeles.atom")
+	assert.Equal(t, []atom.Entry{entry}, la.Entries)
+	assert.Contains(t, la.Title, "Los Angeles County")
+	assert.Empty(t, feed("gs://testbucket/api/v1/locations/by-county/san_diego.atom").Entries)
+
+	// The entry stays in the feeds once the report is no longer new.
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, reportYes{testFetcher()}))
+	require.NoError(t, err)
+	assert.Equal(t, []atom.Entry{entry}, feed("gs://testbucket/api/v1/locations.atom").Entries)
+	assert.Equal(t, []atom.Entry{entry}, feed("gs://testbucket/api/v1/locations/by-county/los_angeles.atom").Entries)
+
+	// Reports at times which can't be parsed are counted.
+	rep, err = Run(ctx, airtable.NewFakeTables(ctx, reportYesAt{testFetcher(), "yesterday"}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, rep.Count("feed_invalid_reports.1/locations"))
+	assert.Equal(t, []atom.Entry{entry}, feed("gs://testbucket/api/v1/locations.atom").Entries)
+
+	var index bycounty.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations/by-county/index.json"].Data, &index))
+	for _, c := range index.Counties {
+		if c.Slug == "los_angeles" {
+			assert.Equal(t, "https://storage.googleapis.com/testbucket/api/v1/locations/by-county/los_angeles.atom", c.Feed)
+		}
+	}
+}
+
+func TestRun_Changes(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	// There's nothing to compare the first run with.
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	assert.NotContains(t, cs.written, "gs://testbucket/api/v1/locations.changes.json")
+
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, renameLocation{testFetcher()}))
+	require.NoError(t, err)
+	var set changes.Set
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations.changes.json"].Data, &set))
+	assert.Len(t, set.Added, 0)
+	assert.Len(t, set.Removed, 0)
+	require.Len(t, set.Modified, 1)
+	assert.Equal(t, "Renamed", set.Modified[0].Fields["Name"].After)
+	var recent changes.Recent
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations.changes.recent.json"].Data, &recent))
+	require.Len(t, recent.Sets, 1)
+	assert.Equal(t, set.RunID, recent.Sets[0].RunID)
+
+	// Counties didn't change, so has an empty change set, and no recent
+	// change sets.
+	set = changes.Set{}
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/counties.changes.json"].Data, &set))
+	assert.True(t, set.Empty())
+	recent = changes.Recent{}
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/counties.changes.recent.json"].Data, &recent))
+	assert.Len(t, recent.Sets, 0)
+
+	// Renaming it back adds a second recent change set.
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	set = changes.Set{}
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations.changes.json"].Data, &set))
+	require.Len(t, set.Modified, 1)
+	assert.Equal(t, "Renamed", set.Modified[0].Fields["Name"].Before)
+	recent = changes.Recent{}
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations.changes.recent.json"].Data, &recent))
+	assert.Len(t, recent.Sets, 2)
+}
+
+func TestRun_Archive(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	// An expired snapshot from a previous run, which the testing deploy's
+	// retention of two days should prune.
+	old := archive.Snapshot{
+		RunID:     "20210301T120000Z",
+		Generated: time.Now().UTC().Add(-72 * time.Hour),
+		Files:     map[string][]string{"v1": {"locations.json"}},
+	}
+	oldDay := archive.Day(old.Generated)
+	oldFile := "gs://testbucket/api/archive/v1/" + old.Stamp() + "/locations.json"
+	oldIndex := "gs://testbucket/api/archive/" + oldDay + "/index.json"
+	topIndex := "gs://testbucket/api/archive/index.json"
+	for file, v := range map[string]interface{}{
+		oldFile:  []string{},
+		oldIndex: archive.DayIndex{Day: oldDay, Snapshots: []archive.Snapshot{old}},
+		topIndex: archive.Index{Days: []archive.DayEntry{{Day: oldDay, Snapshots: 1}}},
+	} {
+		enc, err := storage.EncodeJSON(ctx, v)
+		require.NoError(t, err)
+		cs.written[file] = enc
+	}
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	assert.NotContains(t, cs.written, oldFile)
+	assert.NotContains(t, cs.written, oldIndex)
+
+	var idx archive.Index
+	require.NoError(t, json.Unmarshal(cs.written[topIndex].Data, &idx))
+	assert.Equal(t, "48h0m0s", idx.Retention)
+	require.Len(t, idx.Days, 1)
+	day := idx.Days[0]
+	assert.Equal(t, 1, day.Snapshots)
+
+	var d archive.DayIndex
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/archive/"+day.Day+"/index.json"].Data, &d))
+	require.Len(t, d.Snapshots, 1)
+	snapshot := d.Snapshots[0]
+	assert.Equal(t, []string{"Counties.csv", "Counties.json", "Counties.schema.json", "Locations.csv", "Locations.json", "Locations.schema.json"}, snapshot.Files["legacy"])
+	for _, p := range snapshot.Paths() {
+		assert.Contains(t, cs.written, "gs://testbucket/api/archive/"+p)
+	}
+	// The archived bytes are exactly those published.
+	assert.Equal(t,
+		cs.written["gs://testbucket/api/v1/locations.json"],
+		cs.written["gs://testbucket/api/archive/v1/"+snapshot.Stamp()+"/locations.json"])
+}
+
+func TestRun_ArchivePruneFails(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	// Two expired snapshots, one of whose files can't be deleted.
+	generated := time.Now().UTC().Add(-72 * time.Hour).Truncate(time.Hour)
+	stuck := archive.Snapshot{
+		RunID:     "stuck",
+		Generated: generated,
+		Files:     map[string][]string{"v1": {"locations.csv", "locations.json"}},
+	}
+	pruned := archive.Snapshot{
+		RunID:     "pruned",
+		Generated: generated.Add(time.Minute),
+		Files:     map[string][]string{"v1": {"locations.json"}},
+	}
+	day := archive.Day(generated)
+	dayIndex := "gs://testbucket/api/archive/" + day + "/index.json"
+	for file, v := range map[string]interface{}{
+		"gs://testbucket/api/archive/v1/" + stuck.Stamp() + "/locations.csv":   []string{},
+		"gs://testbucket/api/archive/v1/" + stuck.Stamp() + "/locations.json":  []string{},
+		"gs://testbucket/api/archive/v1/" + pruned.Stamp() + "/locations.json": []string{},
+		dayIndex:                                 archive.DayIndex{Day: day, Snapshots: []archive.Snapshot{pruned, stuck}},
+		"gs://testbucket/api/archive/index.json": archive.Index{Days: []archive.DayEntry{{Day: day, Snapshots: 2}}},
+	} {
+		enc, err := storage.EncodeJSON(ctx, v)
+		require.NoError(t, err)
+		cs.written[file] = enc
+	}
+	undeletable := "gs://testbucket/api/archive/v1/" + stuck.Stamp() + "/locations.csv"
+	deploys.SetTestingDeleter(func(ctx context.Context, file string) error {
+		if file == undeletable {
+			return errors.New("permission denied")
+		}
+		return cs.delete(ctx, file)
+	})
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to delete 1 expired files")
+	assert.NotContains(t, cs.written, "gs://testbucket/api/archive/v1/"+pruned.Stamp()+"/locations.json")
+	assert.NotContains(t, cs.written, "gs://testbucket/api/archive/v1/"+stuck.Stamp()+"/locations.json")
+
+	// The snapshot which wasn't completely deleted is still listed, to be
+	// pruned on the next run.
+	var d archive.DayIndex
+	require.NoError(t, json.Unmarshal(cs.written[dayIndex].Data, &d))
+	require.Len(t, d.Snapshots, 1)
+	assert.Equal(t, "stuck", d.Snapshots[0].RunID)
+}
+
+func TestRollback(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+	defer func(wait time.Duration) { rollbackWait = wait }(rollbackWait)
+	rollbackWait = 0
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	var idx archive.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/archive/index.json"].Data, &idx))
+	var d archive.DayIndex
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/archive/"+idx.Days[0].Day+"/index.json"].Data, &d))
+	good := d.Snapshots[0]
+
+	// A bad publish.
+	live := "gs://testbucket/api/v1/locations.json"
+	archived := cs.written[live]
+	cs.written[live] = storage.Encoded{Data: []byte(`[]`), ContentType: "application/json", Extension: ".json"}
+	cs.written["gs://testbucket/api/v1/locations.csv"] = storage.Encoded{Data: []byte(`id`), ContentType: "text/csv; charset=utf-8", Extension: ".csv"}
+	county := "gs://testbucket/api/v1/locations/by-county/los_angeles.json"
+	archivedCounty := cs.written[county]
+	cs.written[county] = storage.Encoded{Data: []byte(`[]`), ContentType: "application/json", Extension: ".json"}
+	var tileIndex tiles.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/tiles/index.json"].Data, &tileIndex))
+	require.NotEmpty(t, tileIndex.Tiles)
+	tile := "gs://testbucket/api/v1/tiles/" + tileIndex.Tiles[0].Tile.Path() + ".json"
+	archivedTile := cs.written[tile]
+	delete(cs.written, tile)
+
+	_, err = Rollback(ctx, "20000101T000000Z", control.Pause{By: "someone", Reason: "testing"})
+	assert.Error(t, err)
+	restored, err := Rollback(ctx, good.RunID, control.Pause{By: "someone", Reason: "bad data"})
+	require.NoError(t, err)
+	// Every format of every endpoint, and the files derived from them.
+	assert.Len(t, restored, 147)
+	assert.Contains(t, restored, live)
+	assert.Equal(t, archived, cs.written[live])
+	assert.Equal(t, archivedCounty, cs.written[county])
+	assert.Equal(t, archivedTile, cs.written[tile])
+	assert.Contains(t, restored, "gs://testbucket/api/v1/locations.atom")
+	assert.Equal(t, atom.ContentType, cs.written["gs://testbucket/api/v1/locations/by-county/los_angeles.atom"].ContentType)
+	assert.Equal(t, "text/csv; charset=utf-8", cs.written["gs://testbucket/api/v1/locations.csv"].ContentType)
+	assert.NotEqual(t, "id", string(cs.written["gs://testbucket/api/v1/locations.csv"].Data))
+
+	// Scheduled runs don't publish until resumed.
+	pause, err := control.GetPause(ctx, cs.read)
+	require.NoError(t, err)
+	require.NotNil(t, pause)
+	assert.Equal(t, good.RunID, pause.RolledBackTo)
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, renameLocation{testFetcher()}))
+	assert.True(t, errors.Is(err, control.ErrPaused), "got error %v", err)
+	assert.Equal(t, archived, cs.written[live])
+
+	require.NoError(t, control.ClearPause(ctx, cs.delete))
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, renameLocation{testFetcher()}))
+	require.NoError(t, err)
+	assert.NotEqual(t, archived, cs.written[live])
+
+	// Snapshots can also be given by their minute.
+	_, err = Rollback(ctx, good.Stamp(), control.Pause{By: "someone", Reason: "bad data"})
+	require.NoError(t, err)
+}
+
+func TestRun_PausedMidway(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	live := "gs://testbucket/api/v1/locations.json"
+	good := cs.written[live]
+	topIndex := "gs://testbucket/api/archive/index.json"
+	goodIndex := cs.written[topIndex]
+
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, pauseOnFetch{testFetcher(), cs.store}))
+	assert.True(t, errors.Is(err, control.ErrPaused), "got error %v", err)
+	// Nothing which needed the locations was written after the pause,
+	// and the run wasn't archived.
+	assert.Equal(t, good, cs.written[live])
+	assert.Equal(t, goodIndex, cs.written[topIndex])
+}
+
+func TestRun_Pinned(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	good := cs.written["gs://testbucket/api/v1/locations.json"]
+
+	require.NoError(t, control.SetPin(ctx, cs.read, cs.store, control.Pin{Endpoint: "1/locations", By: "someone", Reason: "bad data"}))
+	rep, err := Run(ctx, airtable.NewFakeTables(ctx, renameLocation{testFetcher()}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, rep.Count("pinned.1/locations"))
+	assert.Equal(t, good, cs.written["gs://testbucket/api/v1/locations.json"])
+	// Other endpoints are still published.
+	assert.Equal(t, 1, rep.Count("changed.LEGACY/Locations"))
+
+	var idx manifest.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/index.json"].Data, &idx))
+	loc := idx.Resources[1]
+	assert.Equal(t, "locations", loc.Resource)
+	assert.True(t, loc.Pinned)
+	assert.False(t, loc.Failed)
+
+	require.NoError(t, control.RemovePin(ctx, cs.read, cs.store, "1/locations"))
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, renameLocation{testFetcher()}))
+	require.NoError(t, err)
+	assert.NotEqual(t, good, cs.written["gs://testbucket/api/v1/locations.json"])
+}
+
+// moveLocation is a fetcher which moves the first location to Crescent City,
+// far from any other.
+type moveLocation struct {
+	stubFetchFromFiles
+}
+
+func (m moveLocation) Download(ctx context.Context, table string) (types.TableContent, error) {
+	o, err := m.stubFetchFromFiles.Download(ctx, table)
+	if err != nil || table != "Locations" {
+		return o, err
+	}
+	o[0]["Latitude"] = 41.7558
+	o[0]["Longitude"] = -124.2026
+	return o, nil
+}
+
+func TestRun_Tiles(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, moveLocation{testFetcher()}))
+	require.NoError(t, err)
+	moved := tiles.At(tiles.Zoom, 41.7558, -124.2026)
+	file := "gs://testbucket/api/v1/tiles/" + moved.Path() + ".json"
+	var tile metadata.APIResponse
+	require.NoError(t, json.Unmarshal(cs.written[file].Data, &tile))
+	require.Len(t, tile.Content, 1)
+
+	// Once it moves back, its tile is emptied, and no longer listed.
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(cs.written[file].Data, &tile))
+	assert.Len(t, tile.Content, 0)
+	var index tiles.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/tiles/index.json"].Data, &index))
+	for _, e := range index.Tiles {
+		assert.NotEqual(t, moved, e.Tile)
+	}
+}
+
+// dropCounty is a fetcher which leaves San Diego County out of the Counties
+// table.
+type dropCounty struct {
+	stubFetchFromFiles
+}
+
+func (d dropCounty) Download(ctx context.Context, table string) (types.TableContent, error) {
+	o, err := d.stubFetchFromFiles.Download(ctx, table)
+	if err != nil || table != "Counties" {
+		return o, err
+	}
+	var out types.TableContent
+	for _, c := range o {
+		if c["County"] != "San Diego County" {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func TestRun_ByCountyDropped(t *testing.T) {
+	cs := newCaptureStorage()
+	ctx := context.Background()
+
+	_, err := Run(ctx, airtable.NewFakeTables(ctx, testFetcher()))
+	require.NoError(t, err)
+	file := "gs://testbucket/api/v1/locations/by-county/san_diego.json"
+	var county metadata.APIResponse
+	require.NoError(t, json.Unmarshal(cs.written[file].Data, &county))
+	require.NotEmpty(t, county.Content)
+
+	// Once the county is gone, its file is emptied, and no longer listed.
+	_, err = Run(ctx, airtable.NewFakeTables(ctx, dropCounty{testFetcher()}))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(cs.written[file].Data, &county))
+	assert.Len(t, county.Content, 0)
+	var index bycounty.Index
+	require.NoError(t, json.Unmarshal(cs.written["gs://testbucket/api/v1/locations/by-county/index.json"].Data, &index))
+	for _, c := range index.Counties {
+		assert.NotEqual(t, "san_diego", c.Slug)
+	}
+}
@@ -0,0 +1,195 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/archive"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/atom"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/control"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	beeline "github.com/honeycombio/beeline-go"
+)
+
+// rollbackWait is how long Rollback waits after pausing, for a run already
+// under way to finish.
+var rollbackWait = Timeout
+
+// Rollback re-uploads the archived outputs of every endpoint in a snapshot
+// to their live paths, with the content types of their formats, and pauses
+// publishing, so that the next scheduled run doesn't overwrite them; resume
+// with control.ClearPause.  Having paused, it waits for any run already
+// under way to finish before restoring anything, so it takes at least
+// Timeout.  The snapshot is given by its run ID (e.g.
+// "20261018T153012Z") or its minute, as in the archive path (e.g.
+// "2026/10/18/1530").  Only the endpoints' outputs, and the files derived
+// from them, like their by-county files, feeds and tiles, are restored; the
+// files describing the API, like the indexes and change feeds, are left as
+// they are.  It returns the live files which were restored.
+func Rollback(ctx context.Context, snapshot string, pause control.Pause) ([]string, error) {
+	ctx, span := beeline.StartSpan(ctx, "publisher.Rollback")
+	defer span.Send()
+	beeline.AddField(ctx, "snapshot", snapshot)
+
+	sw, err := deploys.GetStorage()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	sr, err := deploys.GetReader()
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	a, err := newArchiver(sr, sw)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	s, err := a.find(ctx, snapshot)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+
+	// Pause first: runs which start after this publish nothing, and a run
+	// already under way stops before writing any endpoint it hasn't
+	// started writing yet.  Those it has are only safe to restore once it
+	// has finished, so wait out the longest a run may take.
+	pause.RolledBackTo = s.RunID
+	if err := control.SetPause(ctx, sw, pause); err != nil {
+		err = fmt.Errorf("failed to pause publishing: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	log.Printf("Paused; waiting %s for any run in progress to finish\n", rollbackWait)
+	select {
+	case <-time.After(rollbackWait):
+	case <-ctx.Done():
+		err := fmt.Errorf("failed waiting for runs to finish: %w", ctx.Err())
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+
+	eps := endpoints.AllEndpoints()
+	var (
+		wg             sync.WaitGroup
+		mu             sync.Mutex
+		restored, errs []string
+		sem            = make(chan struct{}, archiveWorkers)
+	)
+	for dir, files := range s.Files {
+		for _, file := range files {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(dir, file string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				live, err := a.restore(ctx, s, dir, file, eps)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s/%s: %v", dir, file, err))
+					return
+				}
+				restored = append(restored, live)
+			}(dir, file)
+		}
+	}
+	wg.Wait()
+	sort.Strings(restored)
+	log.Printf("Restored %d files from %s\n", len(restored), s.RunID)
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		err := fmt.Errorf("failed to restore %d files: %s", len(errs), strings.Join(errs, "; "))
+		beeline.AddField(ctx, "error", err)
+		return restored, err
+	}
+	return restored, nil
+}
+
+// find returns the archived snapshot with the given run ID or minute.
+func (a *archiver) find(ctx context.Context, snapshot string) (archive.Snapshot, error) {
+	t, err := time.Parse(runIDFormat, snapshot)
+	if err != nil {
+		t, err = time.Parse("2006/01/02/1504", strings.Trim(snapshot, "/"))
+	}
+	if err != nil {
+		return archive.Snapshot{}, fmt.Errorf("%q is neither a run ID nor a snapshot's minute", snapshot)
+	}
+
+	day := archive.Day(t)
+	d := archive.DayIndex{Day: day}
+	if err := a.read(ctx, a.dayIndex(day), &d); err != nil {
+		return archive.Snapshot{}, err
+	}
+	for _, s := range d.Snapshots {
+		if s.RunID == snapshot || s.Stamp() == archive.Stamp(t) {
+			return s, nil
+		}
+	}
+	return archive.Snapshot{}, fmt.Errorf("no archived snapshot %q", snapshot)
+}
+
+// restore copies an archived file of a snapshot to its live path, and
+// returns the path.  The file's endpoint must still exist, to know its
+// format.
+func (a *archiver) restore(ctx context.Context, s archive.Snapshot, dir, file string, eps []endpoints.Endpoint) (string, error) {
+	version, enc, err := archivedType(dir, file, eps)
+	if err != nil {
+		return "", err
+	}
+	data, err := a.sr(ctx, a.root+"/"+dir+"/"+s.Stamp()+"/"+file)
+	if err != nil {
+		return "", err
+	}
+	baseURL, err := deploys.GetUploadURL(version)
+	if err != nil {
+		return "", err
+	}
+	destination := baseURL + "/" + strings.TrimSuffix(file, enc.Extension)
+	enc.Data = data
+	if err := a.sw(ctx, destination, enc); err != nil {
+		return "", err
+	}
+	return destination + enc.Extension, nil
+}
+
+// archivedType returns the version of an archived file, and its content type
+// and extension: those of the endpoint's format, for an endpoint's output,
+// or of JSON or Atom, for the files derived from it.
+func archivedType(dir, file string, eps []endpoints.Endpoint) (deploys.VersionType, storage.Encoded, error) {
+	var (
+		version deploys.VersionType
+		found   bool
+	)
+	for _, ep := range eps {
+		if archive.VersionDir(ep.Version) != dir {
+			continue
+		}
+		version, found = ep.Version, true
+		for _, format := range ep.Formats {
+			f, err := storage.LookupFormat(format)
+			if err != nil {
+				return "", storage.Encoded{}, err
+			}
+			if ep.Resource+f.Extension == file {
+				return ep.Version, storage.Encoded{ContentType: f.ContentType, Extension: f.Extension}, nil
+			}
+		}
+	}
+	if found && strings.HasSuffix(file, atom.Extension) {
+		return version, storage.Encoded{ContentType: atom.ContentType, Extension: atom.Extension}, nil
+	}
+	if f, err := storage.LookupFormat(storage.FormatJSON); found && err == nil && strings.HasSuffix(file, f.Extension) {
+		return version, storage.Encoded{ContentType: f.ContentType, Extension: f.Extension}, nil
+	}
+	return "", storage.Encoded{}, fmt.Errorf("no endpoint publishes %s", file)
+}
@@ -0,0 +1,121 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/deploys"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/tiles"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	beeline "github.com/honeycombio/beeline-go"
+)
+
+// tileWorkers is how many tile files are written at once.
+const tileWorkers = 8
+
+// publishTiles writes the endpoint's table split into map tiles, each in the
+// same shape as the endpoint's JSON, and an index of them, under dir.
+// Tiles which had locations on the previous run, but don't now, are
+// emptied.  Rows without coordinates are counted in the run report.
+func publishTiles(ctx context.Context, ep endpoints.Endpoint, table types.TableContent, run metadata.Run, snapshot time.Time, dir string, sr deploys.StorageReader, sw deploys.StorageWriter) error {
+	ctx, span := beeline.StartSpan(ctx, "publisher.publishTiles")
+	defer span.Send()
+
+	buckets, skipped := tiles.Split(table, tiles.Zoom)
+	beeline.AddField(ctx, "tiles", len(buckets))
+	report.FromContext(ctx).Add("tiles_skipped."+ep.String(), skipped)
+	if skipped > 0 {
+		log.Printf("[%s] %d rows have no coordinates, so are in no tile\n", &ep, skipped)
+	}
+
+	var previous tiles.Index
+	data, err := sr(ctx, dir+"/"+tiles.IndexName+".json")
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+	case err != nil:
+		log.Printf("[%s] Failed to read previous tile index; not emptying old tiles: %v\n", &ep, err)
+	default:
+		if err := json.Unmarshal(data, &previous); err != nil {
+			log.Printf("[%s] Failed to parse previous tile index; not emptying old tiles: %v\n", &ep, err)
+		}
+	}
+	current := map[tiles.Tile]bool{}
+	for _, b := range buckets {
+		current[b.Tile] = true
+	}
+	writes := append([]*tiles.Bucket{}, buckets...)
+	for _, e := range previous.Tiles {
+		if !current[e.Tile] {
+			writes = append(writes, &tiles.Bucket{Tile: e.Tile, Rows: types.TableContent{}})
+		}
+	}
+
+	downloadURL, err := deploys.GetDownloadURL(ep.Version)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+		sem  = make(chan struct{}, tileWorkers)
+	)
+	for _, b := range writes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(b *tiles.Bucket) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			enc, err := storage.Encode(ctx, storage.FormatJSON, ep.Payload(b.Rows, run, snapshot))
+			if err == nil {
+				err = sw(ctx, dir+"/"+b.Tile.Path(), enc)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", b.Tile.Path(), err))
+				mu.Unlock()
+			}
+		}(b)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		err := fmt.Errorf("failed to store %d tiles: %s", len(errs), strings.Join(errs, "; "))
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+
+	index := tiles.Index{Zoom: tiles.Zoom, Tiles: make([]tiles.IndexEntry, len(buckets))}
+	for i, b := range buckets {
+		index.Tiles[i] = tiles.IndexEntry{
+			Tile:   b.Tile,
+			Bounds: b.Tile.Bounds(),
+			URL:    downloadURL + "/tiles/" + b.Tile.Path() + ".json",
+			Rows:   len(b.Rows),
+		}
+	}
+	enc, err := storage.EncodeJSON(ctx, index)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	if err := sw(ctx, dir+"/"+tiles.IndexName, enc); err != nil {
+		err = fmt.Errorf("failed to store tile index: %w", err)
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	return nil
+}
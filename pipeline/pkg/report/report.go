@@ -0,0 +1,114 @@
+// Package report collects counts and findings over the course of a single
+// pipeline run, so they can be logged once the run completes.  A Report is
+// carried on the context, so that code deep in the pipeline (e.g. a munger
+// setup function) can contribute to it without extra plumbing.
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+type contextKey struct{}
+
+// Report accumulates named counters, and named sections of arbitrary
+// JSON-serializable findings.  All methods are safe for concurrent use, and
+// are no-ops on a nil Report.
+type Report struct {
+	mu       sync.Mutex
+	counters map[string]int
+	sections map[string]interface{}
+}
+
+// New returns an empty Report.
+func New() *Report {
+	return &Report{
+		counters: map[string]int{},
+		sections: map[string]interface{}{},
+	}
+}
+
+// NewContext returns a copy of ctx which carries r.
+func NewContext(ctx context.Context, r *Report) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the Report carried by ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Report {
+	r, _ := ctx.Value(contextKey{}).(*Report)
+	return r
+}
+
+// Add increments the named counter by n.
+func (r *Report) Add(name string, n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name] += n
+}
+
+// Count returns the current value of the named counter.
+func (r *Report) Count(name string) int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+// Counters returns the names of all counters, sorted.
+func (r *Report) Counters() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.counters))
+	for n := range r.counters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set stores v as the named section, replacing any previous value.
+func (r *Report) Set(section string, v interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sections[section] = v
+}
+
+// Get returns the named section, or nil if it has not been set.
+func (r *Report) Get(section string) interface{} {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sections[section]
+}
+
+// MarshalJSON serializes the report as an object with "counters" and
+// "sections" keys.
+func (r *Report) MarshalJSON() ([]byte, error) {
+	if r == nil {
+		return []byte("null"), nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Marshal(struct {
+		Counters map[string]int         `json:"counters"`
+		Sections map[string]interface{} `json:"sections,omitempty"`
+	}{
+		Counters: r.counters,
+		Sections: r.sections,
+	})
+}
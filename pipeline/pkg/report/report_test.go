@@ -0,0 +1,54 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReport(t *testing.T) {
+	r := New()
+	ctx := NewContext(context.Background(), r)
+
+	FromContext(ctx).Add("b", 1)
+	FromContext(ctx).Add("a", 2)
+	FromContext(ctx).Add("b", 3)
+	FromContext(ctx).Set("section", []string{"x"})
+
+	if got := r.Count("b"); got != 4 {
+		t.Errorf("Count(b): got %d, want 4", got)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, r.Counters()); diff != "" {
+		t.Errorf("Counters(): -want +got:\n%v\n", diff)
+	}
+	if diff := cmp.Diff([]string{"x"}, r.Get("section")); diff != "" {
+		t.Errorf("Get(section): -want +got:\n%v\n", diff)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+	want := `{"counters":{"a":2,"b":4},"sections":{"section":["x"]}}`
+	if string(b) != want {
+		t.Errorf("json: got %s, want %s", b, want)
+	}
+}
+
+func TestReport_NoReport(t *testing.T) {
+	// Everything must be safe to call without a report in the context.
+	r := FromContext(context.Background())
+	if r != nil {
+		t.Fatalf("got %v, want nil report", r)
+	}
+	r.Add("a", 1)
+	r.Set("s", 1)
+	if got := r.Count("a"); got != 0 {
+		t.Errorf("Count(a): got %d, want 0", got)
+	}
+	if got := r.Get("s"); got != nil {
+		t.Errorf("Get(s): got %v, want nil", got)
+	}
+}
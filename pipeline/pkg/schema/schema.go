@@ -0,0 +1,183 @@
+// Package schema generates JSON Schema (draft 2020-12) documents describing
+// each endpoint's output, from its declared fields and their types, and
+// validates data against them.
+package schema
+
+import (
+	"context"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// FormatName is the name JSON Schema is registered under as a storage
+// format.
+const FormatName = "jsonschema"
+
+// ContentType is the media type of JSON Schema documents.
+const ContentType = "application/schema+json"
+
+// Draft is the JSON Schema dialect of generated schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// The JSON Schema type names.
+const (
+	TypeArray   = "array"
+	TypeBoolean = "boolean"
+	TypeNull    = "null"
+	TypeNumber  = "number"
+	TypeObject  = "object"
+	TypeString  = "string"
+)
+
+// Schema is the subset of JSON Schema which this package generates and
+// validates.
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type is the list of types a value may have; it is empty if any type
+	// is allowed, e.g. for a field which was never observed.
+	Type                 Types              `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// Generate returns the schema of an endpoint's output.  The rows are objects
+// with only the given columns, of which only "id" is required, since
+// Airtable leaves empty fields out.  Each column's type is its declared type
+// in fieldTypes; columns without one may have any type.  If wrapped is set,
+// the rows are the content of a metadata.APIResponse; otherwise they're a
+// bare list.
+func Generate(title string, columns []string, fieldTypes map[string]types.FieldType, wrapped bool) *Schema {
+	row := &Schema{
+		Type:                 Types{TypeObject},
+		Properties:           make(map[string]*Schema, len(columns)),
+		Required:             []string{"id"},
+		AdditionalProperties: boolPtr(false),
+	}
+	for _, c := range columns {
+		row.Properties[c] = forFieldType(fieldTypes[c])
+	}
+	rows := &Schema{Type: Types{TypeArray}, Items: row}
+
+	s := rows
+	if wrapped {
+		s = envelope(rows)
+	}
+	s.Schema = Draft
+	s.Title = title
+	return s
+}
+
+// forFieldType returns the schema of a field's values, given its declared
+// type.
+func forFieldType(t types.FieldType) *Schema {
+	switch t {
+	case types.String:
+		return &Schema{Type: Types{TypeString}}
+	case types.Number:
+		return &Schema{Type: Types{TypeNumber}}
+	case types.StringList:
+		return &Schema{Type: Types{TypeArray}, Items: &Schema{Type: Types{TypeString}}}
+	}
+	return &Schema{}
+}
+
+// ForPayload returns the schema of the JSON encoding of an endpoint's
+// payload.
+func ForPayload(p storage.Payload) *Schema {
+	resp, wrapped := p.Data.(metadata.APIResponse)
+	s := Generate(p.Name, p.Columns, p.Types, wrapped)
+	if wrapped && resp.Metadata != nil {
+		s.Properties["metadata"] = metadataSchema()
+		s.Required = append(s.Required, "metadata")
+	}
+	return s
+}
+
+// metadataSchema returns the schema of a metadata.Metadata.
+func metadataSchema() *Schema {
+	str := func() *Schema { return &Schema{Type: Types{TypeString}} }
+	return &Schema{
+		Type: Types{TypeObject},
+		Properties: map[string]*Schema{
+			"generated":      str(),
+			"sourceSnapshot": str(),
+			"commit":         str(),
+			"runId":          str(),
+			"records":        {Type: Types{TypeNumber}},
+			"apiVersion":     str(),
+			"deprecation":    str(),
+		},
+		Required: []string{"generated", "sourceSnapshot", "commit", "runId", "records", "apiVersion"},
+	}
+}
+
+// envelope returns the schema of a metadata.APIResponse with the given
+// content.
+func envelope(content *Schema) *Schema {
+	str := func() *Schema { return &Schema{Type: Types{TypeString}} }
+	return &Schema{
+		Type: Types{TypeObject},
+		Properties: map[string]*Schema{
+			"usage": {
+				Type: Types{TypeObject},
+				Properties: map[string]*Schema{
+					"notice": str(),
+					"contact": {
+						Type: Types{TypeObject},
+						Properties: map[string]*Schema{
+							"partnersEmail": str(),
+						},
+						Required: []string{"partnersEmail"},
+					},
+				},
+				Required: []string{"notice", "contact"},
+			},
+			"content": content,
+		},
+		Required: []string{"usage", "content"},
+	}
+}
+
+// TypeOf returns the JSON Schema type of a value decoded from JSON, or
+// produced by a Transform.
+func TypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case float64, float32, int, int64:
+		return TypeNumber
+	case string:
+		return TypeString
+	case []interface{}, []string:
+		return TypeArray
+	default:
+		return TypeObject
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func init() {
+	storage.RegisterFormat(&storage.Format{
+		Name:        FormatName,
+		Extension:   ".schema.json",
+		ContentType: ContentType,
+		Encode: func(_ context.Context, p storage.Payload) ([]byte, error) {
+			buf, err := storage.Serialize(ForPayload(p))
+			if err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+	})
+}
@@ -0,0 +1,145 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/storage"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testTable() types.TableContent {
+	return types.TableContent{
+		{"id": "rec1", "Name": "A", "Yes?": 1.0, "Types": []interface{}{"Pharmacy"}},
+		{"id": "rec2", "Name": "B", "Yes?": 0.0, "Types": []interface{}{}},
+		{"id": "rec3"},
+	}
+}
+
+// testTypes are the declared types of testTable's fields.
+var testTypes = map[string]types.FieldType{
+	"id":    types.String,
+	"Name":  types.String,
+	"Yes?":  types.Number,
+	"Types": types.StringList,
+}
+
+func TestGenerate(t *testing.T) {
+	s := Generate("1/test", []string{"id", "Name", "Yes?", "Types", "Undeclared"}, testTypes, false)
+	got, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `{"$schema":"https://json-schema.org/draft/2020-12/schema","title":"1/test","type":"array",` +
+		`"items":{"type":"object","properties":{` +
+		`"Name":{"type":"string"},` +
+		`"Types":{"type":"array","items":{"type":"string"}},` +
+		`"Undeclared":{},` +
+		`"Yes?":{"type":"number"},` +
+		`"id":{"type":"string"}},` +
+		`"required":["id"],"additionalProperties":false}}`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+
+	var roundTrip Schema
+	if err := json.Unmarshal(got, &roundTrip); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(s, &roundTrip); diff != "" {
+		t.Errorf("round trip: -want +got:\n%v\n", diff)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	columns := []string{"id", "Name", "Yes?", "Types"}
+	bare := Generate("LEGACY/test", columns, testTypes, false)
+	wrapped := Generate("1/test", columns, testTypes, true)
+	withMetadataData := metadata.WrapWithMetadata(testTable(), &metadata.Metadata{
+		Generated:      time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+		SourceSnapshot: time.Date(2021, 3, 1, 11, 59, 30, 0, time.UTC),
+		Commit:         "abc123",
+		RunID:          "20210301T120000Z",
+		Records:        3,
+		APIVersion:     "2",
+	})
+	withMetadata := ForPayload(storage.Payload{Name: "2/test", Data: withMetadataData, Table: testTable(), Columns: columns, Types: testTypes})
+
+	tests := []struct {
+		desc      string
+		schema    *Schema
+		data      interface{}
+		wantErrs  []string
+		wantValid bool
+	}{
+		{
+			desc:      "bare output",
+			schema:    bare,
+			data:      testTable(),
+			wantValid: true,
+		},
+		{
+			desc:      "wrapped output",
+			schema:    wrapped,
+			data:      metadata.Wrap(testTable()),
+			wantValid: true,
+		},
+		{
+			desc:      "wrapped output with metadata",
+			schema:    withMetadata,
+			data:      withMetadataData,
+			wantValid: true,
+		},
+		{
+			desc:     "metadata schema, no metadata",
+			schema:   withMetadata,
+			data:     metadata.Wrap(testTable()),
+			wantErrs: []string{`$: missing required property "metadata"`},
+		},
+		{
+			desc:     "wrapped schema, bare output",
+			schema:   wrapped,
+			data:     testTable(),
+			wantErrs: []string{`$: got array, want object`},
+		},
+		{
+			desc:   "bad rows",
+			schema: bare,
+			data: types.TableContent{
+				{"Name": "no id"},
+				{"id": "rec1", "Yes?": true, "Extra": 1.0},
+				{"id": "rec2", "Types": []interface{}{1.0}},
+				{"id": "rec3", "Name": nil, "Yes?": "1"},
+			},
+			wantErrs: []string{
+				`$[0]: missing required property "id"`,
+				`$[1]["Extra"]: property is not allowed`,
+				`$[1]["Yes?"]: got boolean, want number`,
+				`$[2]["Types"][0]: got number, want string`,
+				`$[3]["Name"]: got null, want string`,
+				`$[3]["Yes?"]: got string, want number`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			b, err := json.Marshal(tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = tt.schema.Validate(b)
+			if (err == nil) != tt.wantValid {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			for _, w := range tt.wantErrs {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q does not contain %q", err, w)
+				}
+			}
+		})
+	}
+}
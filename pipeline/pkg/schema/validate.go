@@ -0,0 +1,65 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Validate checks that the JSON document b is valid against the schema, for
+// the keywords this package generates: type, properties, required,
+// additionalProperties and items.  The error lists every problem found, with
+// its location.
+func (s *Schema) Validate(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var problems []string
+	s.validate("$", v, &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("%d schema violations: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, v interface{}, problems *[]string) {
+	t := TypeOf(v)
+	if len(s.Type) > 0 && !s.Type.Has(t) {
+		*problems = append(*problems, fmt.Sprintf("%s: got %s, want %s", path, t, strings.Join(s.Type, " or ")))
+		return
+	}
+	switch t {
+	case TypeObject:
+		obj := v.(map[string]interface{})
+		for _, r := range s.Required {
+			if _, ok := obj[r]; !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: missing required property %q", path, r))
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := fmt.Sprintf("%s[%q]", path, k)
+			ps, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*problems = append(*problems, fmt.Sprintf("%s: property is not allowed", p))
+				}
+				continue
+			}
+			ps.validate(p, obj[k], problems)
+		}
+	case TypeArray:
+		if s.Items == nil {
+			return
+		}
+		for i, e := range v.([]interface{}) {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e, problems)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package scrub redacts personal information from free-text fields, such as
+// the notes volunteers type in while reporting on a location.
+package scrub
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/filter"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+var (
+	// phonePattern matches North American phone numbers, with or without a
+	// leading country code and with most common separators.
+	phonePattern = regexp.MustCompile(`(?:\+?1[\s.-]?)?\(?\b\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{4}\b`)
+	// emailPattern matches email addresses.
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	nonDigit     = regexp.MustCompile(`\D`)
+)
+
+type pattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Scrubber redacts phone numbers, email addresses, and any configured
+// patterns from a set of fields.  A Scrubber is safe for concurrent use once
+// constructed.
+type Scrubber struct {
+	fields      []string
+	patterns    []pattern
+	allowFields []string
+	allowed     map[string]struct{}
+}
+
+// Option configures a Scrubber.
+type Option func(*Scrubber)
+
+// WithPattern additionally redacts every match of re, which is counted under
+// the given name.
+func WithPattern(name string, re *regexp.Regexp) Option {
+	return func(s *Scrubber) {
+		s.patterns = append(s.patterns, pattern{name: name, re: re})
+	}
+}
+
+// WithAllowedField allows phone numbers which are the same as the value of
+// the given field in the same row; this is for a site's own published phone
+// number, which is fine to leave in.
+func WithAllowedField(field string) Option {
+	return func(s *Scrubber) {
+		s.allowFields = append(s.allowFields, field)
+	}
+}
+
+// WithAllowed allows the given phone numbers or email addresses everywhere.
+func WithAllowed(values ...string) Option {
+	return func(s *Scrubber) {
+		for _, v := range values {
+			s.allowed[allowKey(v)] = struct{}{}
+		}
+	}
+}
+
+// New returns a Scrubber which redacts the given fields.
+func New(fields []string, opts ...Option) *Scrubber {
+	s := &Scrubber{
+		fields: fields,
+		patterns: []pattern{
+			{name: "email", re: emailPattern},
+			{name: "phone", re: phonePattern},
+		},
+		allowed: map[string]struct{}{},
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Munger returns a filter.Munger which scrubs rows, and counts every
+// redaction in the run report carried by ctx, under "redactions.<name>".  It
+// follows the copy-on-write contract: rows which need redacting are copied,
+// and rows which don't are returned as-is.
+func (s *Scrubber) Munger(ctx context.Context) filter.Munger {
+	rep := report.FromContext(ctx)
+	return func(row map[string]interface{}) (map[string]interface{}, error) {
+		changed, counts := s.scrub(row)
+		if len(changed) == 0 {
+			return row, nil
+		}
+		row = types.CloneRow(row)
+		for f, v := range changed {
+			row[f] = v
+		}
+		for name, n := range counts {
+			rep.Add("redactions."+name, n)
+		}
+		return row, nil
+	}
+}
+
+// Scrub redacts the configured fields of a row in place, and returns the
+// number of redactions made, by pattern name.
+func (s *Scrubber) Scrub(row map[string]interface{}) map[string]int {
+	changed, counts := s.scrub(row)
+	for f, v := range changed {
+		row[f] = v
+	}
+	return counts
+}
+
+// scrub returns the new value of every configured field which needs
+// redacting, and the number of redactions made, by pattern name.  The row is
+// not modified.
+func (s *Scrubber) scrub(row map[string]interface{}) (map[string]interface{}, map[string]int) {
+	changed := map[string]interface{}{}
+	counts := map[string]int{}
+	allowed := s.rowAllowed(row)
+	for _, f := range s.fields {
+		before := sum(counts)
+		var out interface{}
+		switch v := row[f].(type) {
+		case string:
+			out = s.scrubString(v, allowed, counts)
+		case []string:
+			l := make([]string, len(v))
+			for i, e := range v {
+				l[i] = s.scrubString(e, allowed, counts)
+			}
+			out = l
+		case []interface{}:
+			l := make([]interface{}, len(v))
+			for i, e := range v {
+				if str, ok := e.(string); ok {
+					l[i] = s.scrubString(str, allowed, counts)
+				} else {
+					l[i] = e
+				}
+			}
+			out = l
+		}
+		if sum(counts) > before {
+			changed[f] = out
+		}
+	}
+	return changed, counts
+}
+
+func sum(counts map[string]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+// rowAllowed returns the set of allowed values for a row, including those
+// from its allowed fields.
+func (s *Scrubber) rowAllowed(row map[string]interface{}) map[string]struct{} {
+	if len(s.allowFields) == 0 {
+		return s.allowed
+	}
+	allowed := make(map[string]struct{}, len(s.allowed)+len(s.allowFields))
+	for k := range s.allowed {
+		allowed[k] = struct{}{}
+	}
+	for _, f := range s.allowFields {
+		if v, ok := row[f].(string); ok && v != "" {
+			allowed[allowKey(v)] = struct{}{}
+		}
+	}
+	return allowed
+}
+
+func (s *Scrubber) scrubString(in string, allowed map[string]struct{}, counts map[string]int) string {
+	for _, p := range s.patterns {
+		in = p.re.ReplaceAllStringFunc(in, func(m string) string {
+			if _, ok := allowed[allowKey(m)]; ok {
+				return m
+			}
+			counts[p.name]++
+			return "[redacted " + p.name + "]"
+		})
+	}
+	return in
+}
+
+// allowKey canonicalizes a value for comparison with the allowlist: phone
+// numbers are compared by their last ten digits, everything else
+// case-insensitively.
+func allowKey(v string) string {
+	if phonePattern.MatchString(v) && !emailPattern.MatchString(v) {
+		d := nonDigit.ReplaceAllString(v, "")
+		if len(d) > 10 {
+			d = d[len(d)-10:]
+		}
+		return d
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
@@ -0,0 +1,145 @@
+package scrub
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScrub(t *testing.T) {
+	tests := []struct {
+		desc       string
+		in         map[string]interface{}
+		opts       []Option
+		want       map[string]interface{}
+		wantCounts map[string]int
+	}{
+		{
+			desc: "phone numbers",
+			in: map[string]interface{}{
+				"Notes": "Call Jane at (415) 555-0134 or 415.555.0199, or +1 415 555 0100.",
+			},
+			want: map[string]interface{}{
+				"Notes": "Call Jane at [redacted phone] or [redacted phone], or [redacted phone].",
+			},
+			wantCounts: map[string]int{"phone": 3},
+		},
+		{
+			desc: "emails",
+			in: map[string]interface{}{
+				"Notes": "Reported by someone@gmail.com",
+			},
+			want: map[string]interface{}{
+				"Notes": "Reported by [redacted email]",
+			},
+			wantCounts: map[string]int{"email": 1},
+		},
+		{
+			desc: "site's own phone number is allowed",
+			in: map[string]interface{}{
+				"Phone number": "833-480-4700",
+				"Notes":        "Call (833) 480-4700 to book; the pharmacist's cell is 415-555-0134",
+			},
+			opts: []Option{WithAllowedField("Phone number")},
+			want: map[string]interface{}{
+				"Phone number": "833-480-4700",
+				"Notes":        "Call (833) 480-4700 to book; the pharmacist's cell is [redacted phone]",
+			},
+			wantCounts: map[string]int{"phone": 1},
+		},
+		{
+			desc: "static allowlist",
+			in: map[string]interface{}{
+				"Notes": "Email API@vaccinateca.com or call 1-800-555-0000",
+			},
+			opts: []Option{WithAllowed("api@vaccinateca.com", "(800) 555-0000")},
+			want: map[string]interface{}{
+				"Notes": "Email API@vaccinateca.com or call 1-800-555-0000",
+			},
+			wantCounts: map[string]int{},
+		},
+		{
+			desc: "configured pattern",
+			in: map[string]interface{}{
+				"Notes": "Spoke to caller Bob Smith, who said yes",
+			},
+			opts: []Option{WithPattern("name", regexp.MustCompile(`caller [A-Z][a-z]+ [A-Z][a-z]+`))},
+			want: map[string]interface{}{
+				"Notes": "Spoke to [redacted name], who said yes",
+			},
+			wantCounts: map[string]int{"name": 1},
+		},
+		{
+			desc: "lists",
+			in: map[string]interface{}{
+				"Notes": []interface{}{"ok", "call 415-555-0134", 12.0},
+				"List":  []string{"a@b.co"},
+			},
+			want: map[string]interface{}{
+				"Notes": []interface{}{"ok", "call [redacted phone]", 12.0},
+				"List":  []string{"[redacted email]"},
+			},
+			wantCounts: map[string]int{"phone": 1, "email": 1},
+		},
+		{
+			desc: "other fields are untouched",
+			in: map[string]interface{}{
+				"Phone number": "415-555-0134",
+			},
+			want: map[string]interface{}{
+				"Phone number": "415-555-0134",
+			},
+			wantCounts: map[string]int{},
+		},
+		{
+			desc: "not phone numbers",
+			in: map[string]interface{}{
+				"Notes": "Open 9-5; 200 doses; zip 94110; 2021-01-16",
+			},
+			want: map[string]interface{}{
+				"Notes": "Open 9-5; 200 doses; zip 94110; 2021-01-16",
+			},
+			wantCounts: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			s := New([]string{"Notes", "List"}, tt.opts...)
+			counts := s.Scrub(tt.in)
+			if diff := cmp.Diff(tt.want, tt.in); diff != "" {
+				t.Errorf("row: -want +got:\n%v\n", diff)
+			}
+			if diff := cmp.Diff(tt.wantCounts, counts); diff != "" {
+				t.Errorf("counts: -want +got:\n%v\n", diff)
+			}
+		})
+	}
+}
+
+func TestMunger(t *testing.T) {
+	rep := report.New()
+	ctx := report.NewContext(context.Background(), rep)
+	m := New([]string{"Notes"}).Munger(ctx)
+
+	orig := []interface{}{"call 415-555-0134"}
+	row := map[string]interface{}{"Notes": orig}
+	for i := 0; i < 2; i++ {
+		if _, err := m(row); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		row["Notes"] = orig
+	}
+
+	if got := rep.Count("redactions.phone"); got != 2 {
+		t.Errorf("redactions.phone: got %d, want 2", got)
+	}
+	// Lists are replaced, not modified, since they may be shared with the
+	// source data.
+	if orig[0] != "call 415-555-0134" {
+		t.Errorf("source list was modified: %v", orig)
+	}
+}
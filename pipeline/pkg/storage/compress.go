@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encoding is an HTTP content coding which files can be stored in.
+type Encoding string
+
+// The encodings which files can be stored in.
+const (
+	Identity Encoding = "identity"
+	Gzip     Encoding = "gzip"
+	Brotli   Encoding = "br"
+)
+
+// suffixes are appended to the path of a file to give the path of its
+// variant in each encoding, other than the file's primary encoding, which is
+// stored at the file's own path.
+var suffixes = map[Encoding]string{
+	Gzip:   ".gz",
+	Brotli: ".br",
+}
+
+// levels are the range of compression levels of each encoding.
+var levels = map[Encoding][2]int{
+	Gzip:   {gzip.HuffmanOnly, gzip.BestCompression},
+	Brotli: {brotli.BestSpeed, brotli.BestCompression},
+}
+
+// Compression describes which encodings a StorageWriter stores each file in.
+type Compression struct {
+	// Encodings are the encodings to store each file in.  The first is the
+	// primary encoding, stored at the file's own path; as storage readers
+	// must be able to read it back, it must be Identity or Gzip.  Each of
+	// the others is stored as a variant, at the file's path with the
+	// encoding's suffix (".gz" or ".br") added.
+	Encodings []Encoding
+	// Levels are the compression levels of the encodings; encodings which
+	// aren't listed use their library's default.
+	Levels map[Encoding]int
+}
+
+// Validate returns an error if the Compression can't be used.
+func (c Compression) Validate() error {
+	if len(c.Encodings) == 0 {
+		return fmt.Errorf("no encodings")
+	}
+	if p := c.Encodings[0]; p != Identity && p != Gzip {
+		return fmt.Errorf("primary encoding %q must be %q or %q", p, Identity, Gzip)
+	}
+	seen := map[Encoding]bool{}
+	for i, e := range c.Encodings {
+		if seen[e] {
+			return fmt.Errorf("duplicate encoding %q", e)
+		}
+		seen[e] = true
+		if i == 0 {
+			continue
+		}
+		if _, ok := suffixes[e]; !ok {
+			return fmt.Errorf("encoding %q can't be a variant", e)
+		}
+	}
+	for e, l := range c.Levels {
+		r, ok := levels[e]
+		if !ok {
+			return fmt.Errorf("encoding %q has no compression levels", e)
+		}
+		if l < r[0] || l > r[1] {
+			return fmt.Errorf("%q compression level %d is not between %d and %d", e, l, r[0], r[1])
+		}
+	}
+	return nil
+}
+
+// Variant is a file's data in one encoding.
+type Variant struct {
+	Encoding Encoding
+	// Suffix is added to the file's path to give the variant's path; it is
+	// empty for the primary encoding.
+	Suffix string
+	Data   []byte
+}
+
+// Compress returns the data in each of the Compression's encodings, primary
+// first.
+func (c Compression) Compress(data []byte) ([]Variant, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid compression: %w", err)
+	}
+	out := make([]Variant, len(c.Encodings))
+	for i, e := range c.Encodings {
+		compressed, err := c.compress(e, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress with %s: %w", e, err)
+		}
+		out[i] = Variant{Encoding: e, Data: compressed}
+		if i > 0 {
+			out[i].Suffix = suffixes[e]
+		}
+	}
+	return out, nil
+}
+
+func (c Compression) compress(e Encoding, data []byte) ([]byte, error) {
+	if e == Identity {
+		return data, nil
+	}
+	level, ok := c.Levels[e]
+	var (
+		b bytes.Buffer
+		w io.WriteCloser
+	)
+	switch e {
+	case Gzip:
+		if !ok {
+			level = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(&b, level)
+		if err != nil {
+			return nil, err
+		}
+		w = gw
+	case Brotli:
+		if !ok {
+			level = brotli.DefaultCompression
+		}
+		w = brotli.NewWriterLevel(&b, level)
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", e)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decompress returns data as it was before being compressed with the given
+// encoding.
+func decompress(e Encoding, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch e {
+	case Identity:
+		return data, nil
+	case Gzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case Brotli:
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", e)
+	}
+	return ioutil.ReadAll(r)
+}
+
+// variantPaths returns the paths of every variant which may have been stored
+// for a file, other than the file itself.
+func variantPaths(file string) []string {
+	return []string{file + suffixes[Gzip], file + suffixes[Brotli]}
+}
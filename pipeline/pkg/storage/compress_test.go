@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompressionValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Compression
+		wantErr bool
+	}{
+		{"identity", Compression{Encodings: []Encoding{Identity}}, false},
+		{"gzip with variants", Compression{Encodings: []Encoding{Gzip, Brotli}}, false},
+		{"identity with variants", Compression{Encodings: []Encoding{Identity, Gzip, Brotli}}, false},
+		{"levels", Compression{Encodings: []Encoding{Gzip, Brotli}, Levels: map[Encoding]int{Gzip: 9, Brotli: 11}}, false},
+		{"none", Compression{}, true},
+		{"brotli primary", Compression{Encodings: []Encoding{Brotli}}, true},
+		{"identity variant", Compression{Encodings: []Encoding{Gzip, Identity}}, true},
+		{"duplicate", Compression{Encodings: []Encoding{Gzip, Brotli, Brotli}}, true},
+		{"unknown", Compression{Encodings: []Encoding{Gzip, "zstd"}}, true},
+		{"level too high", Compression{Encodings: []Encoding{Gzip}, Levels: map[Encoding]int{Gzip: 11}}, true},
+		{"identity level", Compression{Encodings: []Encoding{Identity}, Levels: map[Encoding]int{Identity: 1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id":"rec1","Name":"Somewhere"},`), 100)
+	c := Compression{
+		Encodings: []Encoding{Identity, Gzip, Brotli},
+		Levels:    map[Encoding]int{Gzip: 1, Brotli: 11},
+	}
+	variants, err := c.Compress(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var suffixes []string
+	for _, v := range variants {
+		suffixes = append(suffixes, v.Suffix)
+		if v.Encoding != Identity && len(v.Data) >= len(data) {
+			t.Errorf("%s: compressed to %d bytes from %d", v.Encoding, len(v.Data), len(data))
+		}
+		got, err := decompress(v.Encoding, v.Data)
+		if err != nil {
+			t.Fatalf("%s: unexpected error decompressing: %v", v.Encoding, err)
+		}
+		if !bytes.Equal(data, got) {
+			t.Errorf("%s: didn't round-trip", v.Encoding)
+		}
+	}
+	if diff := cmp.Diff([]string{"", ".gz", ".br"}, suffixes); diff != "" {
+		t.Errorf("unexpected suffixes (-want +got):\n%s", diff)
+	}
+
+	if _, err := (Compression{Encodings: []Encoding{Brotli}}).Compress(data); err == nil {
+		t.Errorf("got no error from an invalid Compression")
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// csvContentType is the content type of CSV documents.
+const csvContentType = "text/csv; charset=utf-8"
+
+// utf8BOM is the byte order mark which tells spreadsheet programs, notably
+// Excel, that a CSV file is UTF-8.
+const utf8BOM = "\ufeff"
+
+// listSeparator joins the elements of list values into one CSV cell.
+const listSeparator = "; "
+
+// formulaPrefixes are the characters which make spreadsheet programs treat
+// a cell as a formula, or, for tab and carriage return, can hide one.
+const formulaPrefixes = "=+-@\t\r"
+
+// CSVOptions configures how a table is serialized as CSV.
+type CSVOptions struct {
+	// BOM starts the file with a UTF-8 byte order mark.
+	BOM bool
+	// EscapeFormulas prefixes text cells which spreadsheet programs would
+	// run as a formula, such as notes starting with "=", with a "'", which
+	// makes them show the cell as text instead.  Numbers are never
+	// escaped, so negative ones stay numbers.
+	EscapeFormulas bool
+}
+
+// SerializeCSV writes a header row of the columns, then a row for each row
+// of the table.  Lists are flattened into a single cell, separated by "; ";
+// objects are written as JSON.
+func SerializeCSV(table types.TableContent, columns []string, opts CSVOptions) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	if opts.BOM {
+		buf.WriteString(utf8BOM)
+	}
+	w := csv.NewWriter(buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(columns))
+	for i, row := range table {
+		for j, c := range columns {
+			cell, err := csvCell(row[c])
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %q: %w", i, c, err)
+			}
+			if opts.EscapeFormulas && !isNumber(row[c]) && cell != "" && strings.ContainsRune(formulaPrefixes, rune(cell[0])) {
+				cell = "'" + cell
+			}
+			record[j] = cell
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// isNumber reports if v is a single number.
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case float64, int:
+		return true
+	}
+	return false
+}
+
+// csvCell formats a single value as a CSV cell.
+func csvCell(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(t), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case []string:
+		return strings.Join(t, listSeparator), nil
+	case []interface{}:
+		cells := make([]string, len(t))
+		for i, e := range t {
+			c, err := csvCell(e)
+			if err != nil {
+				return "", err
+			}
+			cells[i] = c
+		}
+		return strings.Join(cells, listSeparator), nil
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSerializeCSV(t *testing.T) {
+	table := types.TableContent{
+		{
+			"id":         "rec1",
+			"Name":       "Kaiser, \"Oakland\"",
+			"Latitude":   37.8,
+			"Yes?":       1.0,
+			"Types":      []interface{}{"Pharmacy", "Clinic"},
+			"Tags":       []string{"a", "b"},
+			"Open":       true,
+			"Embedded":   map[string]interface{}{"id": "recX"},
+			"Not listed": "left out",
+		},
+		{
+			"id":   "rec2",
+			"Name": "Café Ñandú",
+		},
+	}
+	columns := []string{"id", "Name", "Latitude", "Yes?", "Types", "Tags", "Open", "Embedded"}
+
+	tests := []struct {
+		desc string
+		opts CSVOptions
+		want string
+	}{
+		{
+			desc: "plain",
+			want: "id,Name,Latitude,Yes?,Types,Tags,Open,Embedded\n" +
+				`rec1,"Kaiser, ""Oakland""",37.8,1,Pharmacy; Clinic,a; b,true,"{""id"":""recX""}"` + "\n" +
+				"rec2,Café Ñandú,,,,,,\n",
+		},
+		{
+			desc: "BOM",
+			opts: CSVOptions{BOM: true},
+			want: "\ufeffid,Name,Latitude,Yes?,Types,Tags,Open,Embedded\n" +
+				`rec1,"Kaiser, ""Oakland""",37.8,1,Pharmacy; Clinic,a; b,true,"{""id"":""recX""}"` + "\n" +
+				"rec2,Café Ñandú,,,,,,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := SerializeCSV(table, columns, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got.String()); diff != "" {
+				t.Errorf("-want +got:\n%v\n", diff)
+			}
+		})
+	}
+}
+
+func TestSerializeCSV_EscapeFormulas(t *testing.T) {
+	table := types.TableContent{
+		{"id": "rec1", "Notes": "=HYPERLINK(\"http://evil.example\")", "Longitude": -118.2},
+		{"id": "rec2", "Notes": "+1 555 0100", "Longitude": -118},
+		{"id": "rec3", "Notes": "-", "Longitude": 0},
+		{"id": "rec4", "Notes": "@SUM(A1)"},
+		{"id": "rec5", "Notes": "\t=1+1"},
+		{"id": "rec6", "Notes": "\r=1+1"},
+		{"id": "rec7", "Notes": []interface{}{"=1+1", "ok"}},
+		{"id": "rec8", "Notes": "1 + 1 = 2"},
+	}
+	columns := []string{"id", "Notes", "Longitude"}
+
+	tests := []struct {
+		desc string
+		opts CSVOptions
+		want string
+	}{
+		{
+			desc: "escaped",
+			opts: CSVOptions{EscapeFormulas: true},
+			want: "id,Notes,Longitude\n" +
+				`rec1,"'=HYPERLINK(""http://evil.example"")",-118.2` + "\n" +
+				"rec2,'+1 555 0100,-118\n" +
+				"rec3,'-,0\n" +
+				"rec4,'@SUM(A1),\n" +
+				"rec5,'\t=1+1,\n" +
+				"rec6,\"'\r=1+1\",\n" +
+				"rec7,'=1+1; ok,\n" +
+				"rec8,1 + 1 = 2,\n",
+		},
+		{
+			desc: "not escaped",
+			want: "id,Notes,Longitude\n" +
+				`rec1,"=HYPERLINK(""http://evil.example"")",-118.2` + "\n" +
+				"rec2,+1 555 0100,-118\n" +
+				"rec3,-,0\n" +
+				"rec4,@SUM(A1),\n" +
+				"rec5,\"\t=1+1\",\n" +
+				"rec6,\"\r=1+1\",\n" +
+				"rec7,=1+1; ok,\n" +
+				"rec8,1 + 1 = 2,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := SerializeCSV(table, columns, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got.String()); diff != "" {
+				t.Errorf("-want +got:\n%v\n", diff)
+			}
+		})
+	}
+}
+
+func TestEncodeCSV(t *testing.T) {
+	p := Payload{
+		Table:   types.TableContent{{"id": "rec1", "Notes": "ok"}},
+		Columns: []string{"id", "Notes"},
+	}
+	got, err := Encode(context.Background(), FormatCSVBOM, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Encoded{
+		Data:        []byte("\ufeffid,Notes\nrec1,ok\n"),
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   ".csv",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
@@ -5,21 +5,19 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
 	beeline "github.com/honeycombio/beeline-go"
 )
 
-func DebugToSTDERR(ctx context.Context, destinationFile string, transformedData metadata.JSONData) error {
+func DebugToSTDERR(ctx context.Context, destination string, enc Encoded) error {
 	ctx, span := beeline.StartSpan(ctx, "storage.Print")
 	defer span.Send()
+	destinationFile := destination + enc.Extension
 	beeline.AddField(ctx, "destinationFile", destinationFile)
 
-	serializedData, err := Serialize(transformedData)
-	if err != nil {
-		return fmt.Errorf("failed to write serialized json: %w", err)
+	if err := checkEncoded(enc); err != nil {
+		return fmt.Errorf("failed to check encoded data: %w", err)
 	}
 
-	byteLen := len(serializedData.Bytes())
-	fmt.Fprintf(os.Stderr, "======> Would write %d bytes to %s\n", byteLen, destinationFile)
-	return err
+	fmt.Fprintf(os.Stderr, "======> Would write %d bytes of %s to %s\n", len(enc.Data), enc.ContentType, destinationFile)
+	return nil
 }
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+)
+
+// ErrDenied is returned when data which is about to be written contains a
+// denylisted key or value.
+var ErrDenied = errors.New("denylisted content")
+
+// Denylist describes keys and values which must never be published, no
+// matter which endpoint produced them.  It is a backstop for mistakes in each
+// endpoint's field list.
+type Denylist struct {
+	// Keys are object keys which must not appear at any depth.
+	Keys []string
+	// ValuePatterns must not match any string value, at any depth.
+	ValuePatterns []*regexp.Regexp
+}
+
+// DefaultDenylist is checked by Encode, and again by every StorageWriter
+// before it writes.
+var DefaultDenylist = Denylist{
+	Keys: []string{
+		"Internal notes",
+		"Last report author",
+		"airtable_id",
+	},
+	ValuePatterns: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)[a-z0-9._%+-]+@gmail\.com`),
+	},
+}
+
+// Check returns an error wrapping ErrDenied, describing where the first
+// denylisted content was found, if jd contains any.  The check is made on the
+// JSON form of the data, so it sees exactly what would be published.
+func (d Denylist) Check(jd metadata.JSONData) error {
+	b, err := json.Marshal(jd)
+	if err != nil {
+		return err
+	}
+	return d.checkSerialized(b)
+}
+
+// checkSerialized is Check, for data which has already been serialized.
+func (d Denylist) checkSerialized(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	keys := make(map[string]struct{}, len(d.Keys))
+	for _, k := range d.Keys {
+		keys[k] = struct{}{}
+	}
+	return d.walk("$", v, keys)
+}
+
+// checkLines is checkSerialized, for newline-delimited JSON.
+func (d Denylist) checkLines(b []byte) error {
+	for i, line := range bytes.Split(bytes.TrimRight(b, "\n"), []byte("\n")) {
+		if err := d.checkSerialized(line); err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// checkText checks a Document which isn't JSON.  Keys can't be told apart
+// from values in arbitrary text, so only ValuePatterns are checked.
+func (d Denylist) checkText(b []byte) error {
+	for _, re := range d.ValuePatterns {
+		if loc := re.FindIndex(b); loc != nil {
+			return fmt.Errorf("%w: value at byte %d matches %q", ErrDenied, loc[0], re)
+		}
+	}
+	return nil
+}
+
+func (d Denylist) walk(path string, v interface{}, keys map[string]struct{}) error {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		// Sort, so the error is the same every time.
+		names := make([]string, 0, len(t))
+		for k := range t {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			p := fmt.Sprintf("%s[%q]", path, k)
+			if _, ok := keys[k]; ok {
+				return fmt.Errorf("%w: key at %s", ErrDenied, p)
+			}
+			if err := d.walk(p, t[k], keys); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			if err := d.walk(fmt.Sprintf("%s[%d]", path, i), e, keys); err != nil {
+				return err
+			}
+		}
+	case string:
+		for _, re := range d.ValuePatterns {
+			if re.MatchString(t) {
+				return fmt.Errorf("%w: value at %s matches %q", ErrDenied, path, re)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+func TestDenylistCheck(t *testing.T) {
+	dl := Denylist{
+		Keys:          []string{"Internal notes"},
+		ValuePatterns: []*regexp.Regexp{regexp.MustCompile(`@gmail\.com`)},
+	}
+
+	tests := []struct {
+		desc     string
+		in       metadata.JSONData
+		wantErr  bool
+		wantPath string
+	}{
+		{
+			desc: "clean",
+			in: types.TableContent{
+				{"id": "1", "Notes": "fine", "List": []interface{}{"a", 1.0}},
+			},
+		},
+		{
+			desc: "denied key",
+			in: types.TableContent{
+				{"id": "1"},
+				{"id": "2", "Internal notes": ""},
+			},
+			wantErr:  true,
+			wantPath: `$[1]["Internal notes"]`,
+		},
+		{
+			desc:     "denied value",
+			in:       types.TableContent{{"id": "1", "Notes": "from someone@gmail.com"}},
+			wantErr:  true,
+			wantPath: `$[0]["Notes"]`,
+		},
+		{
+			desc:     "denied value nested in a list",
+			in:       types.TableContent{{"id": "1", "Notes": []string{"ok", "someone@gmail.com"}}},
+			wantErr:  true,
+			wantPath: `$[0]["Notes"][1]`,
+		},
+		{
+			desc:     "denied key nested in a wrapped response",
+			in:       metadata.Wrap(types.TableContent{{"id": "1", "Embedded": map[string]interface{}{"Internal notes": "x"}}}),
+			wantErr:  true,
+			wantPath: `$["content"][0]["Embedded"]["Internal notes"]`,
+		},
+		{
+			desc: "key names are not matched as values",
+			in:   types.TableContent{{"id": "1", "Notes": "Internal notes"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := dl.Check(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, ErrDenied) {
+				t.Errorf("got error %v, want %v", err, ErrDenied)
+			}
+			if !strings.Contains(err.Error(), tt.wantPath) {
+				t.Errorf("error %q does not contain path %s", err, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestCheckEncoded(t *testing.T) {
+	tests := []struct {
+		desc    string
+		enc     Encoded
+		wantErr bool
+	}{
+		{
+			desc: "text may mention denied keys",
+			enc:  Encoded{Data: []byte("Internal notes: fine in text"), ContentType: "text/plain"},
+		},
+		{
+			desc:    "text with denied values",
+			enc:     Encoded{Data: []byte("from someone@gmail.com"), ContentType: "text/plain"},
+			wantErr: true,
+		},
+		{
+			desc:    "JSON-based formats get the full check",
+			enc:     Encoded{Data: []byte(`{"type":"Feature","properties":{"Internal notes":"x"}}`), ContentType: "application/geo+json"},
+			wantErr: true,
+		},
+		{
+			desc: "clean JSON-based format",
+			enc:  Encoded{Data: []byte(`{"type":"Feature","properties":{"Notes":"x"}}`), ContentType: "application/geo+json"},
+		},
+		{
+			desc:    "NDJSON gets the full check on every line",
+			enc:     Encoded{Data: []byte("{\"id\":\"1\"}\n{\"airtable_id\":\"2\"}\n"), ContentType: ndjsonContentType},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := checkEncoded(tt.enc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err != nil && !errors.Is(err, ErrDenied) {
+				t.Errorf("got error %v, want %v", err, ErrDenied)
+			}
+		})
+	}
+}
+
+func TestEncodeRefusesDenied(t *testing.T) {
+	ctx := context.Background()
+	bad := metadata.Wrap(types.TableContent{{"id": "1", "Last report author": []interface{}{"recX"}}})
+	_, err := EncodeJSON(ctx, bad)
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("EncodeJSON: got error %v, want %v", err, ErrDenied)
+	}
+}
+
+func TestWritersRefuseDenied(t *testing.T) {
+	ctx := context.Background()
+	// Writers check again, in case they're given data which didn't come
+	// from Encode.
+	bad := Encoded{
+		Data:        []byte(`{"content":[{"id":"1","Last report author":["recX"]}]}`),
+		ContentType: "application/json",
+		Extension:   ".json",
+	}
+
+	err := DebugToSTDERR(ctx, "gs://local/api/v1/locations", bad)
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("DebugToSTDERR: got error %v, want %v", err, ErrDenied)
+	}
+
+	// The bucket name is invalid, so this would fail if it got as far as
+	// trying to upload.
+	err = UploadToGCS(ctx, "gs://invalid bucket/locations", bad)
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("UploadToGCS: got error %v, want %v", err, ErrDenied)
+	}
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// Names of the formats registered by this package.
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+	// FormatCSVBOM is CSV starting with a UTF-8 byte order mark, for CSV
+	// which is mostly opened in spreadsheet programs; Excel needs it to
+	// read the file as UTF-8.  Text which would be run as a formula is
+	// escaped; see CSVOptions.EscapeFormulas.
+	FormatCSVBOM = "csv-bom"
+)
+
+// Payload is the data an endpoint publishes, which each Format encodes in its
+// own way.
+type Payload struct {
+	// Name identifies the endpoint, for logging and the run report.
+	Name string
+	// Data is the full JSON response, e.g. the table wrapped with the usage
+	// notice.
+	Data metadata.JSONData
+	// Table is the endpoint's rows.
+	Table types.TableContent
+	// Columns are the names of the endpoint's fields, in order.
+	Columns []string
+	// Types are the types of the endpoint's fields, by name.
+	Types map[string]types.FieldType
+}
+
+// Encoded is data which has been encoded in some format, ready to be given
+// to a StorageWriter.
+type Encoded struct {
+	Data        []byte
+	ContentType string
+	// Extension is the file extension, including the leading ".", which the
+	// StorageWriter adds to the destination.
+	Extension string
+}
+
+// Format encodes payloads into a particular file format.
+type Format struct {
+	Name        string
+	Extension   string
+	ContentType string
+	// Encode serializes the payload.  The context carries the run report.
+	Encode func(context.Context, Payload) ([]byte, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]*Format{}
+)
+
+// RegisterFormat makes a format available by name.  It panics if a format
+// with the same name is already registered, so is expected to be called from
+// an init function.
+func RegisterFormat(f *Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if _, ok := formats[f.Name]; ok {
+		panic(fmt.Sprintf("storage: format %q registered twice", f.Name))
+	}
+	formats[f.Name] = f
+}
+
+// LookupFormat returns the named format.
+func LookupFormat(name string) (*Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", name)
+	}
+	return f, nil
+}
+
+// Formats returns the names of every registered format, sorted.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for n := range formats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Encode encodes the payload in the named format, and refuses to if the
+// result contains anything on the DefaultDenylist.
+func Encode(ctx context.Context, format string, p Payload) (Encoded, error) {
+	f, err := LookupFormat(format)
+	if err != nil {
+		return Encoded{}, err
+	}
+	b, err := f.Encode(ctx, p)
+	if err != nil {
+		return Encoded{}, fmt.Errorf("failed to encode %s: %w", f.Name, err)
+	}
+	enc := Encoded{Data: b, ContentType: f.ContentType, Extension: f.Extension}
+	if err := checkEncoded(enc); err != nil {
+		return Encoded{}, err
+	}
+	return enc, nil
+}
+
+// EncodeJSON encodes arbitrary data as JSON; it is for files other than
+// endpoints, like reports, which are always JSON.
+func EncodeJSON(ctx context.Context, jd metadata.JSONData) (Encoded, error) {
+	return Encode(ctx, FormatJSON, Payload{Data: jd})
+}
+
+// checkEncoded returns an error wrapping ErrDenied if the encoded data
+// contains anything on the DefaultDenylist.  JSON-based formats get the full
+// check; for anything else, only the value patterns can be checked.
+func checkEncoded(enc Encoded) error {
+	check := DefaultDenylist.checkText
+	if isJSON(enc.ContentType) {
+		check = DefaultDenylist.checkSerialized
+	} else if enc.ContentType == ndjsonContentType {
+		check = DefaultDenylist.checkLines
+	}
+	if err := check(enc.Data); err != nil {
+		log.Printf("REFUSING TO WRITE DATA: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func init() {
+	RegisterFormat(&Format{
+		Name:        FormatJSON,
+		Extension:   ".json",
+		ContentType: jsonContentType,
+		Encode: func(_ context.Context, p Payload) ([]byte, error) {
+			buf, err := Serialize(p.Data)
+			if err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+	})
+	RegisterFormat(&Format{
+		Name:        FormatNDJSON,
+		Extension:   ".ndjson",
+		ContentType: ndjsonContentType,
+		Encode: func(_ context.Context, p Payload) ([]byte, error) {
+			buf, err := SerializeNDJSON(p.Table)
+			if err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+	})
+	RegisterFormat(&Format{
+		Name:        FormatCSV,
+		Extension:   ".csv",
+		ContentType: csvContentType,
+		Encode: func(_ context.Context, p Payload) ([]byte, error) {
+			buf, err := SerializeCSV(p.Table, p.Columns, CSVOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+	})
+	RegisterFormat(&Format{
+		Name:        FormatCSVBOM,
+		Extension:   ".csv",
+		ContentType: csvContentType,
+		Encode: func(_ context.Context, p Payload) ([]byte, error) {
+			buf, err := SerializeCSV(p.Table, p.Columns, CSVOptions{BOM: true, EscapeFormulas: true})
+			if err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+	})
+}
+
+// SerializeNDJSON writes each row as a line of JSON, HTML-escaped like
+// Serialize.
+func SerializeNDJSON(table types.TableContent) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	for _, row := range table {
+		line, err := Serialize(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line.Bytes())
+		buf.WriteByte('\n')
+	}
+	return buf, nil
+}
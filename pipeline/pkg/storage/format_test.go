@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEncode(t *testing.T) {
+	table := types.TableContent{
+		{"id": "1", "Name": "<b>"},
+		{"id": "2", "Name": "ok"},
+	}
+	p := Payload{
+		Name:    "1/test",
+		Data:    metadata.JSONData(table),
+		Table:   table,
+		Columns: []string{"id", "Name"},
+	}
+
+	tests := []struct {
+		format  string
+		want    Encoded
+		wantErr bool
+	}{
+		{
+			format: FormatJSON,
+			want: Encoded{
+				Data:        []byte(`[{"Name":"\u003cb\u003e","id":"1"},{"Name":"ok","id":"2"}]`),
+				ContentType: "application/json",
+				Extension:   ".json",
+			},
+		},
+		{
+			format: FormatNDJSON,
+			want: Encoded{
+				Data:        []byte("{\"Name\":\"\\u003cb\\u003e\",\"id\":\"1\"}\n{\"Name\":\"ok\",\"id\":\"2\"}\n"),
+				ContentType: "application/x-ndjson",
+				Extension:   ".ndjson",
+			},
+		},
+		{
+			format: FormatCSV,
+			want: Encoded{
+				Data:        []byte("id,Name\n1,<b>\n2,ok\n"),
+				ContentType: "text/csv; charset=utf-8",
+				Extension:   ".csv",
+			},
+		},
+		{
+			format:  "doesnotexist",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := Encode(context.Background(), tt.format, p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("-want +got:\n%v\n", diff)
+			}
+		})
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a format twice did not panic")
+		}
+	}()
+	RegisterFormat(&Format{Name: FormatJSON})
+}
+
+func TestFormats(t *testing.T) {
+	want := []string{"csv", "csv-bom", "json", "ndjson"}
+	if diff := cmp.Diff(want, Formats()); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
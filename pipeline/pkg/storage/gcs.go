@@ -2,66 +2,168 @@ package storage
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strings"
+	"sync"
 
 	"cloud.google.com/go/storage"
-	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
 	beeline "github.com/honeycombio/beeline-go"
 )
 
 // Update the README.md for new latencies if you adjust the max-age.
 const cacheControl = "public,max-age=120"
 
-// UploadToGCS uploads to GCS, after gzip'ing and setting a cache-control header.
-func UploadToGCS(ctx context.Context, destinationFile string, transformedData metadata.JSONData) error {
+// UploadToGCS uploads to GCS, after gzip'ing and setting a cache-control
+// header.  It stores no other variants; see NewGCSWriter.
+func UploadToGCS(ctx context.Context, destination string, enc Encoded) error {
+	return uploadToGCS(ctx, destination, enc, Compression{Encodings: []Encoding{Gzip}})
+}
+
+// NewGCSWriter returns a StorageWriter which uploads to GCS, storing each
+// file in the given encodings, with a cache-control header.  Each object's
+// Content-Encoding is set to its encoding, and its "encodings" metadata
+// lists all of the file's encodings, so that whatever serves the bucket can
+// pick the variant which best matches a request's Accept-Encoding.
+func NewGCSWriter(c Compression) func(context.Context, string, Encoded) error {
+	return func(ctx context.Context, destination string, enc Encoded) error {
+		return uploadToGCS(ctx, destination, enc, c)
+	}
+}
+
+func uploadToGCS(ctx context.Context, destination string, enc Encoded, c Compression) error {
 	ctx, span := beeline.StartSpan(ctx, "storage.UploadToGCS")
 	defer span.Send()
+	destinationFile := destination + enc.Extension
 	beeline.AddField(ctx, "destinationFile", destinationFile)
 
-	serializedData, err := Serialize(transformedData)
+	err := checkEncoded(enc)
 	if err != nil {
-		err = fmt.Errorf("failed to write serialized json: %w", err)
+		err = fmt.Errorf("failed to check encoded data: %w", err)
 		beeline.AddField(ctx, "error", err)
 		return err
 	}
 
-	gb := &bytes.Buffer{}
-	gw := gzip.NewWriter(gb)
-	_, err = gw.Write(serializedData.Bytes())
+	variants, err := c.Compress(enc.Data)
 	if err != nil {
-		err = fmt.Errorf("failed to compress serialized json: %w", err)
+		err = fmt.Errorf("failed to compress %s: %w", enc.ContentType, err)
 		beeline.AddField(ctx, "error", err)
 		return err
 	}
-	gw.Close()
+	meta := map[string]string{"encodings": encodingList(c.Encodings)}
+
+	// Variants are uploaded before the primary, so that a client which
+	// finds the new primary finds new variants too.
+	for i := len(variants) - 1; i >= 0; i-- {
+		v := variants[i]
+		bucket, object, err := parts(destinationFile + v.Suffix)
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			return err
+		}
+		opts := []UploadOptionFunc{
+			WithCacheControl(cacheControl),
+			WithContentType(enc.ContentType),
+			WithMetadata(meta),
+		}
+		if v.Encoding != Identity {
+			opts = append(opts, WithContentEncoding(string(v.Encoding)))
+		}
+		err = uploadFile(ctx, bucket, object, v.Data, opts...)
+		if err != nil {
+			err = fmt.Errorf("failed to upload %s variant: %w", v.Encoding, err)
+			beeline.AddField(ctx, "error", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodingList returns the encodings as a comma-separated list, as in an
+// HTTP header.
+func encodingList(es []Encoding) string {
+	s := make([]string, len(es))
+	for i, e := range es {
+		s[i] = string(e)
+	}
+	return strings.Join(s, ", ")
+}
+
+// ReadFromGCS reads an object uploaded by UploadToGCS, decompressing it.  It
+// returns an error wrapping ErrNotExist if there is no such object.
+func ReadFromGCS(ctx context.Context, file string) ([]byte, error) {
+	ctx, span := beeline.StartSpan(ctx, "storage.ReadFromGCS")
+	defer span.Send()
+	beeline.AddField(ctx, "file", file)
+
+	bucket, object, err := parts(file)
 	if err != nil {
-		err = fmt.Errorf("failed to close compressed serialized json: %w", err)
 		beeline.AddField(ctx, "error", err)
-		return err
+		return nil, err
 	}
+	data, err := downloadFile(ctx, bucket, object)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// DeleteFromGCS deletes an object, and any variants of it in other
+// encodings; it is not an error if there is no such object.
+func DeleteFromGCS(ctx context.Context, file string) error {
+	ctx, span := beeline.StartSpan(ctx, "storage.DeleteFromGCS")
+	defer span.Send()
+	beeline.AddField(ctx, "file", file)
 
-	bucket, object, err := parts(destinationFile)
+	bucket, object, err := parts(file)
 	if err != nil {
 		beeline.AddField(ctx, "error", err)
 		return err
 	}
-	err = uploadFile(ctx, bucket, object, gb.Bytes(),
-		WithContentEncoding("gzip"),
-		WithCacheControl(cacheControl),
-		WithContentType("application/json"))
+	client, err := gcsClient()
 	if err != nil {
-		err = fmt.Errorf("failed to upload file: %w", err)
 		beeline.AddField(ctx, "error", err)
 		return err
 	}
-
+	for _, o := range append([]string{object}, variantPaths(object)...) {
+		err = client.Bucket(bucket).Object(o).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			err = fmt.Errorf("Object.Delete: %v", err)
+			beeline.AddField(ctx, "error", err)
+			return err
+		}
+	}
 	return nil
 }
 
+var (
+	clientMu     sync.Mutex
+	sharedClient *storage.Client
+)
+
+// gcsClient returns the client shared by every call, creating it on first
+// use; clients are safe for concurrent use, and creating one for each call
+// is slow.  It is created without the caller's context, which would end the
+// shared client's credentials along with it.
+func gcsClient() (*storage.Client, error) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if sharedClient != nil {
+		return sharedClient, nil
+	}
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	sharedClient = client
+	return client, nil
+}
+
 // UploadOptionFunc is used to support named parameters to uploadFile.  It's exported to quiet a lint warning, but otherwise doesn't need to be because it's only used by an unexported function.
 type UploadOptionFunc func(w *storage.Writer)
 
@@ -80,6 +182,11 @@ func WithContentType(ct string) UploadOptionFunc {
 	return func(w *storage.Writer) { w.ContentType = ct }
 }
 
+// WithMetadata returns an uploadOptionFunc that sets the Metadata field on the provided storage.Writer.
+func WithMetadata(m map[string]string) UploadOptionFunc {
+	return func(w *storage.Writer) { w.Metadata = m }
+}
+
 // parts splits a gs:// URI into component parts (bucket, object)
 func parts(p string) (string, string, error) {
 	if !strings.HasPrefix(p, "gs://") {
@@ -92,11 +199,10 @@ func parts(p string) (string, string, error) {
 
 // uploadFile uploads an object to Cloud Storage
 func uploadFile(ctx context.Context, bucket, object string, content []byte, opts ...UploadOptionFunc) error {
-	client, err := storage.NewClient(ctx)
+	client, err := gcsClient()
 	if err != nil {
-		return fmt.Errorf("storage.NewClient: %v", err)
+		return err
 	}
-	defer client.Close()
 
 	br := bytes.NewReader(content)
 
@@ -113,3 +219,26 @@ func uploadFile(ctx context.Context, bucket, object string, content []byte, opts
 	}
 	return nil
 }
+
+// downloadFile downloads an object from Cloud Storage; objects stored with
+// gzip content encoding are transparently decompressed.
+func downloadFile(ctx context.Context, bucket, object string) ([]byte, error) {
+	client, err := gcsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("gs://%s/%s: %w", bucket, object, ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Object.NewReader: %v", err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadAll: %v", err)
+	}
+	return data, nil
+}
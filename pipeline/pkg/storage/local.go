@@ -10,34 +10,54 @@ import (
 	"path"
 	"path/filepath"
 
-	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
 	beeline "github.com/honeycombio/beeline-go"
 )
 
-// StoreLocal writes out to a `local/` directory
-func StoreLocal(ctx context.Context, destinationFile string, transformedData metadata.JSONData) error {
+// StoreLocal writes out to a `local/` directory, uncompressed.  It writes no
+// other variants; see NewLocalWriter.
+func StoreLocal(ctx context.Context, destination string, enc Encoded) error {
+	return storeLocal(ctx, destination, enc, Compression{Encodings: []Encoding{Identity}})
+}
+
+// NewLocalWriter returns a StorageWriter which writes out to a `local/`
+// directory, storing each file in the given encodings, as NewGCSWriter does.
+// The primary encoding must be Identity, so that local files can be read as
+// they are; the others are written alongside, as variants.
+func NewLocalWriter(c Compression) func(context.Context, string, Encoded) error {
+	return func(ctx context.Context, destination string, enc Encoded) error {
+		return storeLocal(ctx, destination, enc, c)
+	}
+}
+
+func storeLocal(ctx context.Context, destination string, enc Encoded, c Compression) error {
 	ctx, span := beeline.StartSpan(ctx, "storage.StoreLocal")
 	defer span.Send()
+	destinationFile := destination + enc.Extension
 	beeline.AddField(ctx, "destinationFile", destinationFile)
 
-	serializedData, err := Serialize(transformedData)
-	if err != nil {
-		err = fmt.Errorf("failed to serialize json: %w", err)
+	if err := checkEncoded(enc); err != nil {
+		err = fmt.Errorf("failed to check encoded data: %w", err)
 		beeline.AddField(ctx, "error", err)
 		return err
 	}
 
-	writeToURL, err := url.Parse(destinationFile)
+	if len(c.Encodings) > 0 && c.Encodings[0] != Identity {
+		err := fmt.Errorf("primary encoding %q: local files must be stored %q", c.Encodings[0], Identity)
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	variants, err := c.Compress(enc.Data)
 	if err != nil {
-		err = fmt.Errorf("Invalid destination URL %s: %w", destinationFile, err)
+		err = fmt.Errorf("failed to compress %s: %w", enc.ContentType, err)
 		beeline.AddField(ctx, "error", err)
 		return err
 	}
 
-	// Strip of the gs://; the "host" is the bucket name, which in
-	// treat as a directory.  In most cases this is "local", which,
-	// in Docker, is mounted out to the host OS.
-	localFilePath := filepath.Join(writeToURL.Host, writeToURL.Path)
+	localFilePath, err := localPath(destinationFile)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
 	err = os.MkdirAll(path.Dir(localFilePath), 0755)
 	if err != nil {
 		err = fmt.Errorf("failed to make directories %s: %w", path.Dir(localFilePath), err)
@@ -45,13 +65,75 @@ func StoreLocal(ctx context.Context, destinationFile string, transformedData met
 		return err
 	}
 
-	err = ioutil.WriteFile(localFilePath, serializedData.Bytes(), 0644)
-	log.Printf("Wrote out to local path: %s", localFilePath)
+	for i := len(variants) - 1; i >= 0; i-- {
+		v := variants[i]
+		err = ioutil.WriteFile(localFilePath+v.Suffix, v.Data, 0644)
+		if err != nil {
+			err = fmt.Errorf("failed to write %s %s variant: %w", enc.ContentType, v.Encoding, err)
+			beeline.AddField(ctx, "error", err)
+			return err
+		}
+	}
+	log.Printf("Wrote out to local path: %s\n", localFilePath)
+
+	return nil
+}
+
+// ReadLocal reads a file written by StoreLocal or a NewLocalWriter.  It
+// returns an error wrapping ErrNotExist if there is no such file.
+func ReadLocal(ctx context.Context, file string) ([]byte, error) {
+	ctx, span := beeline.StartSpan(ctx, "storage.ReadLocal")
+	defer span.Send()
+	beeline.AddField(ctx, "file", file)
+
+	localFilePath, err := localPath(file)
 	if err != nil {
-		err = fmt.Errorf("failed to write serialized json: %w", err)
 		beeline.AddField(ctx, "error", err)
-		return err
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(localFilePath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", localFilePath, ErrNotExist)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read %s: %w", localFilePath, err)
+		beeline.AddField(ctx, "error", err)
+		return nil, err
 	}
+	return data, nil
+}
+
+// DeleteLocal deletes a file written by StoreLocal or a NewLocalWriter, and
+// any variants of it in other encodings; it is not an error if there is no
+// such file.
+func DeleteLocal(ctx context.Context, file string) error {
+	ctx, span := beeline.StartSpan(ctx, "storage.DeleteLocal")
+	defer span.Send()
+	beeline.AddField(ctx, "file", file)
 
+	localFilePath, err := localPath(file)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		return err
+	}
+	for _, p := range append([]string{localFilePath}, variantPaths(localFilePath)...) {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("failed to delete %s: %w", p, err)
+			beeline.AddField(ctx, "error", err)
+			return err
+		}
+	}
 	return nil
 }
+
+// localPath returns the local path of a gs:// URL, as used by StoreLocal.
+func localPath(file string) (string, error) {
+	u, err := url.Parse(file)
+	if err != nil {
+		return "", fmt.Errorf("Invalid destination URL %s: %w", file, err)
+	}
+	// Strip of the gs://; the "host" is the bucket name, which in
+	// treat as a directory.  In most cases this is "local", which,
+	// in Docker, is mounted out to the host OS.
+	return filepath.Join(u.Host, u.Path), nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+	// StoreLocal writes relative to the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	ctx := context.Background()
+	_, err = ReadLocal(ctx, "gs://local/api/v1/locations.json")
+	if !errors.Is(err, ErrNotExist) {
+		t.Errorf("ReadLocal before writing: got error %v, want %v", err, ErrNotExist)
+	}
+
+	enc := Encoded{
+		Data:        []byte(`{"content":[{"id":"rec1"}]}`),
+		ContentType: jsonContentType,
+		Extension:   ".json",
+	}
+	if err := StoreLocal(ctx, "gs://local/api/v1/locations", enc); err != nil {
+		t.Fatalf("StoreLocal: unexpected error: %v", err)
+	}
+	got, err := ReadLocal(ctx, "gs://local/api/v1/locations.json")
+	if err != nil {
+		t.Fatalf("ReadLocal: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(string(enc.Data), string(got)); diff != "" {
+		t.Errorf("-want +got:\n%v\n", diff)
+	}
+}
+
+func TestDeleteLocal(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	ctx := context.Background()
+	enc := Encoded{Data: []byte(`[]`), ContentType: jsonContentType, Extension: ".json"}
+	if err := StoreLocal(ctx, "gs://local/archive/v1/locations", enc); err != nil {
+		t.Fatalf("StoreLocal: unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		// Deleting something which is already gone is fine.
+		if err := DeleteLocal(ctx, "gs://local/archive/v1/locations.json"); err != nil {
+			t.Fatalf("DeleteLocal: unexpected error: %v", err)
+		}
+	}
+	_, err = ReadLocal(ctx, "gs://local/archive/v1/locations.json")
+	if !errors.Is(err, ErrNotExist) {
+		t.Errorf("ReadLocal after deleting: got error %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestLocalVariants(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	ctx := context.Background()
+	sw := NewLocalWriter(Compression{Encodings: []Encoding{Identity, Gzip, Brotli}})
+	enc := Encoded{
+		Data:        []byte(`{"content":[{"id":"rec1"}]}`),
+		ContentType: jsonContentType,
+		Extension:   ".json",
+	}
+	if err := sw(ctx, "gs://local/api/v1/locations", enc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The primary is stored uncompressed, with the variants alongside.
+	for file, e := range map[string]Encoding{
+		"local/api/v1/locations.json":    Identity,
+		"local/api/v1/locations.json.gz": Gzip,
+		"local/api/v1/locations.json.br": Brotli,
+	} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := decompress(e, data)
+		if err != nil {
+			t.Fatalf("%s: unexpected error decompressing: %v", file, err)
+		}
+		if diff := cmp.Diff(string(enc.Data), string(got)); diff != "" {
+			t.Errorf("%s: -want +got:\n%v\n", file, diff)
+		}
+	}
+	got, err := ReadLocal(ctx, "gs://local/api/v1/locations.json")
+	if err != nil {
+		t.Fatalf("ReadLocal: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(string(enc.Data), string(got)); diff != "" {
+		t.Errorf("ReadLocal: -want +got:\n%v\n", diff)
+	}
+
+	// Deleting the file deletes its variants.
+	if err := DeleteLocal(ctx, "gs://local/api/v1/locations.json"); err != nil {
+		t.Fatalf("DeleteLocal: unexpected error: %v", err)
+	}
+	for _, file := range []string{"local/api/v1/locations.json", "local/api/v1/locations.json.gz", "local/api/v1/locations.json.br"} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s: got %v, want it deleted", file, err)
+		}
+	}
+}
+
+func TestLocalCompressedPrimary(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// Local files must be readable as they are.
+	sw := NewLocalWriter(Compression{Encodings: []Encoding{Gzip, Brotli}})
+	enc := Encoded{Data: []byte(`{}`), ContentType: jsonContentType, Extension: ".json"}
+	if err := sw(context.Background(), "gs://local/api/v1/locations", enc); err == nil {
+		t.Error("got no error storing a gzip'd primary")
+	}
+	if _, err := os.Stat("local/api/v1/locations.json"); !os.IsNotExist(err) {
+		t.Errorf("got %v, want nothing written", err)
+	}
+}
@@ -0,0 +1,7 @@
+package storage
+
+import "errors"
+
+// ErrNotExist is wrapped by the errors which storage readers (ReadFromGCS,
+// ReadLocal) return when nothing has been stored at the given path.
+var ErrNotExist = errors.New("does not exist")
@@ -3,10 +3,17 @@ package storage
 import (
 	"bytes"
 	"encoding/json"
+	"mime"
+	"strings"
 
 	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/endpoints/metadata"
 )
 
+const (
+	jsonContentType   = "application/json"
+	ndjsonContentType = "application/x-ndjson"
+)
+
 // JSON marshals the data, then HTML-escapes it so it could possibly
 // be inlined within <script> tags (though we do not currently do so).
 func Serialize(jd metadata.JSONData) (*bytes.Buffer, error) {
@@ -18,3 +25,13 @@ func Serialize(jd metadata.JSONData) (*bytes.Buffer, error) {
 	json.HTMLEscape(buf, unsanitizedJSON)
 	return buf, nil
 }
+
+// isJSON returns if the content type is JSON, or a JSON-based format like
+// GeoJSON.
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == jsonContentType || strings.HasSuffix(mt, "+json")
+}
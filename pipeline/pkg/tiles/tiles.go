@@ -0,0 +1,130 @@
+// Package tiles buckets tables of locations into a fixed grid of map tiles,
+// so that map clients only need to fetch the locations in the area they
+// show.  Tiles are the usual Web Mercator ("slippy map") tiles, as used by
+// OpenStreetMap and most map libraries, addressed by zoom, x and y.
+package tiles
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/geojson"
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+)
+
+// Zoom is the zoom level of the tile grid.  At zoom 8, a tile is about 150km
+// across in California, which keeps each tile's file small in cities
+// without splitting the state into too many files.
+const Zoom = 8
+
+// IndexName is the base name of the tile index.
+const IndexName = "index"
+
+// Tile is a map tile.
+type Tile struct {
+	Z int `json:"z"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// At returns the tile of the given zoom which contains a point.
+func At(z int, lat, lng float64) Tile {
+	n := math.Exp2(float64(z))
+	x := int(math.Floor((lng + 180) / 360 * n))
+	// Web Mercator doesn't reach the poles; points beyond its edge belong
+	// to the first or last row of tiles.
+	lat = math.Max(-maxLat, math.Min(maxLat, lat))
+	latRad := lat * math.Pi / 180
+	y := int(math.Floor((1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n))
+	// Points on the east or south edge of the map belong to the last tile.
+	return Tile{Z: z, X: clamp(x, int(n)-1), Y: clamp(y, int(n)-1)}
+}
+
+// maxLat is the latitude of the north edge of the Web Mercator map.
+var maxLat = math.Atan(math.Sinh(math.Pi)) * 180 / math.Pi
+
+func clamp(v, max int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Path returns the tile's path, "{z}/{x}/{y}", without an extension.
+func (t Tile) Path() string {
+	return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+}
+
+// Bounds returns the tile's west, south, east and north edges, in degrees,
+// as in a GeoJSON bounding box.
+func (t Tile) Bounds() [4]float64 {
+	n := math.Exp2(float64(t.Z))
+	lng := func(x int) float64 { return float64(x)/n*360 - 180 }
+	lat := func(y int) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+	}
+	return [4]float64{lng(t.X), lat(t.Y + 1), lng(t.X + 1), lat(t.Y)}
+}
+
+// Bucket is a tile with the locations in it.
+type Bucket struct {
+	Tile Tile
+	// Rows are the locations in the tile.  They are shared with the table
+	// which was split.
+	Rows types.TableContent
+}
+
+// Split returns a Bucket for every tile of the given zoom with any rows of
+// the table in it, sorted by x then y, and the number of rows which were
+// skipped because they don't have valid coordinates; see
+// geojson.Coordinates.
+func Split(table types.TableContent, z int) ([]*Bucket, int) {
+	byTile := map[Tile]*Bucket{}
+	skipped := 0
+	for _, row := range table {
+		lat, lng, ok := geojson.Coordinates(row)
+		if !ok {
+			skipped++
+			continue
+		}
+		t := At(z, lat, lng)
+		b, ok := byTile[t]
+		if !ok {
+			b = &Bucket{Tile: t, Rows: types.TableContent{}}
+			byTile[t] = b
+		}
+		b.Rows = append(b.Rows, row)
+	}
+
+	out := make([]*Bucket, 0, len(byTile))
+	for _, b := range byTile {
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tile.X != out[j].Tile.X {
+			return out[i].Tile.X < out[j].Tile.X
+		}
+		return out[i].Tile.Y < out[j].Tile.Y
+	})
+	return out, skipped
+}
+
+// IndexEntry describes one tile's file.
+type IndexEntry struct {
+	Tile
+	// Bounds are the tile's west, south, east and north edges.
+	Bounds [4]float64 `json:"bounds"`
+	URL    string     `json:"url"`
+	Rows   int        `json:"rows"`
+}
+
+// Index lists every tile with locations in it.  Tiles which aren't listed
+// have none; if they had any on an earlier run, their files are emptied.
+type Index struct {
+	Zoom  int          `json:"zoom"`
+	Tiles []IndexEntry `json:"tiles"`
+}
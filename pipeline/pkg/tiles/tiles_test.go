@@ -0,0 +1,78 @@
+package tiles
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CAVaccineInventory/airtable-export/pipeline/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		z        int
+		lat, lng float64
+		want     Tile
+	}{
+		{"origin", 0, 0, 0, Tile{0, 0, 0}},
+		{"san francisco", 8, 37.77, -122.42, Tile{8, 40, 98}},
+		{"los angeles", 8, 34.05, -118.24, Tile{8, 43, 102}},
+		{"east edge", 1, 10, 180, Tile{1, 1, 0}},
+		{"north pole", 2, 90, 0, Tile{2, 2, 0}},
+		{"south pole", 2, -90, 0, Tile{2, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := At(tt.z, tt.lat, tt.lng); got != tt.want {
+				t.Errorf("At(%d, %v, %v) = %v, want %v", tt.z, tt.lat, tt.lng, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBounds(t *testing.T) {
+	approx := cmp.Comparer(func(a, b float64) bool { return math.Abs(a-b) < 1e-6 })
+	if diff := cmp.Diff([4]float64{-180, -85.0511288, 180, 85.0511288}, Tile{0, 0, 0}.Bounds(), approx); diff != "" {
+		t.Errorf("unexpected zoom 0 bounds (-want +got):\n%s", diff)
+	}
+
+	// A point is inside the bounds of its tile.
+	lat, lng := 37.77, -122.42
+	b := At(Zoom, lat, lng).Bounds()
+	if lng < b[0] || lat < b[1] || lng >= b[2] || lat >= b[3] {
+		t.Errorf("(%v, %v) is outside of its tile's bounds %v", lat, lng, b)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	in := types.TableContent{
+		{"id": "sf1", "Latitude": 37.77, "Longitude": -122.42},
+		{"id": "la", "Latitude": 34.05, "Longitude": -118.24},
+		{"id": "sf2", "Latitude": 37.78, "Longitude": -122.41},
+		{"id": "missing", "Latitude": 37.77},
+		{"id": "zero", "Latitude": 0.0, "Longitude": 0.0},
+	}
+	buckets, skipped := Split(in, Zoom)
+	if skipped != 2 {
+		t.Errorf("got %d skipped, want 2", skipped)
+	}
+	got := map[string][]string{}
+	var order []string
+	for _, b := range buckets {
+		order = append(order, b.Tile.Path())
+		for _, r := range b.Rows {
+			got[b.Tile.Path()] = append(got[b.Tile.Path()], r["id"].(string))
+		}
+	}
+	want := map[string][]string{
+		"8/40/98":  {"sf1", "sf2"},
+		"8/43/102": {"la"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected buckets (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"8/40/98", "8/43/102"}, order); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+}
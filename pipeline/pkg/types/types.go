@@ -7,16 +7,72 @@ package types
 // string keys and arbitrary values.
 type TableContent []map[string]interface{}
 
-// Clone does a clone of a TableContent structure returning a new copy.
+// Clone does a deep clone of a TableContent structure returning a new copy.
 func (tc TableContent) Clone() TableContent {
 	var out TableContent
 
 	for _, row := range tc {
-		var new = make(map[string]interface{})
-		for k, v := range row {
-			new[k] = v
-		}
-		out = append(out, new)
+		out = append(out, CloneRow(row))
 	}
 	return out
 }
+
+// CloneRow returns a deep copy of a single row; nested lists and objects are
+// copied too, so that modifying any part of the copy doesn't affect the
+// original.
+func CloneRow(row map[string]interface{}) map[string]interface{} {
+	new := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		new[k] = CloneValue(v)
+	}
+	return new
+}
+
+// CloneValue returns a deep copy of a value found in a row.  Scalars are
+// returned as-is, since they're immutable.
+func CloneValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return CloneRow(t)
+	case []interface{}:
+		new := make([]interface{}, len(t))
+		for i, e := range t {
+			new[i] = CloneValue(e)
+		}
+		return new
+	case []string:
+		new := make([]string, len(t))
+		copy(new, t)
+		return new
+	}
+	return v
+}
+
+// FieldType is the type of the values of a published field.
+type FieldType string
+
+// The types of published fields.  Numbers are decoded from Airtable's JSON,
+// so are float64s.
+const (
+	String     FieldType = "string"
+	Number     FieldType = "number"
+	StringList FieldType = "string list"
+)
+
+// TypedField is a field and the type of its values.
+type TypedField struct {
+	Name string
+	Type FieldType
+}
+
+// FieldList is a list of fields with their types.
+type FieldList []TypedField
+
+// Names returns the names of the fields, in order.
+func (fl FieldList) Names() []string {
+	names := make([]string, len(fl))
+	for i, f := range fl {
+		names[i] = f.Name
+	}
+	return names
+}
@@ -36,3 +36,25 @@ func TestClone(t *testing.T) {
 	}
 
 }
+
+// TestCloneDeep ensures that nested values are copied, not shared.
+func TestCloneDeep(t *testing.T) {
+	src := TableContent{
+		{
+			"list":   []interface{}{"a", map[string]interface{}{"b": "c"}},
+			"slice":  []string{"d"},
+			"nested": map[string]interface{}{"e": []interface{}{"f"}},
+		},
+	}
+	want := src.Clone()
+
+	cpy := src.Clone()
+	cpy[0]["list"].([]interface{})[0] = "X"
+	cpy[0]["list"].([]interface{})[1].(map[string]interface{})["b"] = "X"
+	cpy[0]["slice"].([]string)[0] = "X"
+	cpy[0]["nested"].(map[string]interface{})["e"].([]interface{})[0] = "X"
+
+	if diff := cmp.Diff(want, src); diff != "" {
+		t.Errorf("src modified unexpectedly: -want +src:\n%v\n", diff)
+	}
+}